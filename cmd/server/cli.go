@@ -0,0 +1,457 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "text/tabwriter"
+
+    "terraform-http-backend/internal/admin"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/states"
+    "terraform-http-backend/internal/tenants"
+)
+
+const usage = `Usage: terraform-http-backend <command> [flags] [args]
+
+Commands:
+  serve                                  start the server (default)
+  config check [file]                    validate the configuration file
+  states ls [prefix]                     list states
+  states show <path>                     print a state
+  states rm <path>                       delete a state
+  states history <path>                  list previous versions of a state
+  states rollback <path> <version>       restore a previous version of a state
+  locks ls [prefix]                      list held locks
+  locks show <path>                      print a lock
+  locks force-unlock <path>              release a lock
+  export [--prefix p] [--output file]    write states to a tar.gz archive
+  import [--force] [file]                restore states from a tar.gz archive
+  fsck [prefix]                          check states and locks for corruption
+  reindex                                rebuild the state index from DATA_DIR
+
+Commands other than serve, config and reindex work on DATA_DIR directly, or on a
+running server when --server is given. Run '<command> -h' for its flags.
+`
+
+// runCommand runs the subcommand named by args[0] and returns the exit code
+func runCommand(args []string) int {
+    switch args[0] {
+    case "serve":
+        serve()
+        return 0
+    case "config":
+        return configCommand(args[1:])
+    case "states":
+        return statesCommand(args[1:])
+    case "locks":
+        return locksCommand(args[1:])
+    case "export":
+        return exportCommand(args[1:])
+    case "import":
+        return importCommand(args[1:])
+    case "fsck":
+        return fsckCommand(args[1:])
+    case "reindex":
+        return reindexCommand(args[1:])
+    case "help":
+        fmt.Print(usage)
+        return 0
+    }
+    fmt.Fprint(os.Stderr, usage)
+    return 2
+}
+
+// connection holds the flags selecting where administrative commands operate
+type connection struct {
+    server   string
+    username string
+    password string
+    dataDir  string
+    tenant   string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *connection) {
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    conn := &connection{}
+    fs.StringVar(&conn.server, "server", config.GetEnv("BACKEND_SERVER", ""), "URL of a running server, instead of working on the data directory")
+    fs.StringVar(&conn.username, "username", config.GetEnv("AUTH_USERNAME", ""), "username for --server")
+    fs.StringVar(&conn.password, "password", "", "password for --server (default AUTH_PASSWORD or AUTH_PASSWORD_FILE)")
+    fs.StringVar(&conn.dataDir, "data-dir", config.GetEnv("DATA_DIR", "./data"), "data directory to work on without --server")
+    fs.StringVar(&conn.tenant, "tenant", "", "tenant whose states to work on")
+    return fs, conn
+}
+
+// backend returns the Backend the connection flags point at
+func (c *connection) backend() (admin.Backend, error) {
+    if c.server == "" {
+        dataDir := c.dataDir
+        if c.tenant != "" {
+            tenant, err := lookupTenant(c.tenant)
+            if err != nil {
+                return nil, err
+            }
+            dataDir = tenant.Root(dataDir)
+        }
+        if _, err := os.Stat(dataDir); err != nil {
+            return nil, err
+        }
+        useIndex(c.dataDir)
+        return admin.NewLocal(dataDir), nil
+    }
+    password := c.password
+    if password == "" {
+        var err error
+        if password, err = config.GetEnvOrFile("AUTH_PASSWORD", ""); err != nil {
+            return nil, err
+        }
+    }
+    server := c.server
+    if c.tenant != "" {
+        server += "/tenants/" + c.tenant
+    }
+    return admin.NewRemote(server, c.username, password), nil
+}
+
+// lookupTenant finds the tenant named by --tenant in TENANTS_FILE, so a typo
+// can't create a new tenant's directory
+func lookupTenant(name string) (*tenants.Tenant, error) {
+    file := config.GetEnv("TENANTS_FILE", "")
+    if file == "" {
+        return nil, fmt.Errorf("--tenant needs TENANTS_FILE to be set")
+    }
+    registry, err := tenants.Load(file)
+    if err != nil {
+        return nil, err
+    }
+    tenant, ok := registry.Lookup(name)
+    if !ok {
+        return nil, fmt.Errorf("unknown tenant %q in %s", name, file)
+    }
+    return tenant, nil
+}
+
+// useIndex keeps the data directory's index, when it has one, up to date with
+// the changes commands make, warning when a running server holds it
+func useIndex(dataDir string) {
+    if config.GetEnv("INDEX_ENABLED", "true") != "true" {
+        return
+    }
+    if _, err := os.Stat(filepath.Join(dataDir, index.FileName)); err != nil {
+        return
+    }
+    ix, err := index.Open(dataDir)
+    if err == index.ErrInUse {
+        fmt.Fprintln(os.Stderr, "Warning: a running server holds the index, its listings won't show changes made here until its next INDEX_SYNC_INTERVAL; use --server instead")
+        return
+    } else if err != nil {
+        fmt.Fprintln(os.Stderr, "Warning: not updating the index:", err)
+        return
+    }
+    index.Activate(ix)
+}
+
+// parse parses args into fs and connects to the backend, checking the number of positional arguments
+func parse(fs *flag.FlagSet, conn *connection, args []string, min, max int) (admin.Backend, []string, int) {
+    if err := fs.Parse(args); err != nil {
+        if err == flag.ErrHelp {
+            return nil, nil, 0
+        }
+        return nil, nil, 2
+    }
+    if fs.NArg() < min || fs.NArg() > max {
+        fmt.Fprint(os.Stderr, usage)
+        return nil, nil, 2
+    }
+    backend, err := conn.backend()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return nil, nil, 1
+    }
+    return backend, fs.Args(), -1
+}
+
+// fail prints err and returns the exit code for a failed command
+func fail(err error) int {
+    fmt.Fprintln(os.Stderr, err)
+    return 1
+}
+
+func statesCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+    fs, conn := newFlagSet("states " + args[0])
+    jsonOutput := fs.Bool("json", false, "print listings as JSON")
+    switch args[0] {
+    case "ls":
+        backend, rest, code := parse(fs, conn, args[1:], 0, 1)
+        if backend == nil {
+            return code
+        }
+        prefix := ""
+        if len(rest) == 1 {
+            prefix = rest[0]
+        }
+        entries, err := backend.ListStates(prefix)
+        if err != nil {
+            return fail(err)
+        }
+        if *jsonOutput {
+            return printJSON(entries)
+        }
+        tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(tw, "PATH\tSIZE\tMODIFIED\tSERIAL\tRESOURCES\tLOCKED")
+        for _, entry := range entries {
+            metadata := entry.Metadata
+            if metadata == nil {
+                metadata = &states.Metadata{}
+            }
+            fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%v\n", entry.Path, entry.Size, entry.Modified.Format("2006-01-02 15:04:05"),
+                metadata.Serial, metadata.Resources, metadata.Locked)
+        }
+        tw.Flush()
+    case "show":
+        backend, rest, code := parse(fs, conn, args[1:], 1, 1)
+        if backend == nil {
+            return code
+        }
+        data, err := backend.ReadState(rest[0])
+        if err != nil {
+            return fail(err)
+        }
+        os.Stdout.Write(data)
+    case "rm":
+        backend, rest, code := parse(fs, conn, args[1:], 1, 1)
+        if backend == nil {
+            return code
+        }
+        if err := backend.DeleteState(rest[0]); err != nil {
+            return fail(err)
+        }
+        fmt.Printf("Deleted state %s\n", rest[0])
+    case "history":
+        backend, rest, code := parse(fs, conn, args[1:], 1, 1)
+        if backend == nil {
+            return code
+        }
+        versions, err := backend.History(rest[0])
+        if err != nil {
+            return fail(err)
+        }
+        if *jsonOutput {
+            return printJSON(versions)
+        }
+        tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(tw, "VERSION\tSIZE\tREPLACED")
+        for _, version := range versions {
+            fmt.Fprintf(tw, "%s\t%d\t%s\n", version.ID, version.Size, version.Replaced.Format("2006-01-02 15:04:05"))
+        }
+        tw.Flush()
+    case "rollback":
+        backend, rest, code := parse(fs, conn, args[1:], 2, 2)
+        if backend == nil {
+            return code
+        }
+        if err := backend.Rollback(rest[0], rest[1]); err != nil {
+            return fail(err)
+        }
+        fmt.Printf("Rolled back state %s to version %s\n", rest[0], rest[1])
+    default:
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+    return 0
+}
+
+func locksCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+    fs, conn := newFlagSet("locks " + args[0])
+    jsonOutput := fs.Bool("json", false, "print listings as JSON")
+    switch args[0] {
+    case "ls":
+        backend, rest, code := parse(fs, conn, args[1:], 0, 1)
+        if backend == nil {
+            return code
+        }
+        prefix := ""
+        if len(rest) == 1 {
+            prefix = rest[0]
+        }
+        entries, err := backend.ListLocks(prefix)
+        if err != nil {
+            return fail(err)
+        }
+        if *jsonOutput {
+            return printJSON(entries)
+        }
+        tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(tw, "PATH\tID\tWHO\tOPERATION\tACQUIRED")
+        for _, entry := range entries {
+            if entry.Lock == nil {
+                fmt.Fprintf(tw, "%s\t-\t-\t-\t%s\n", entry.Path, entry.Acquired.Format("2006-01-02 15:04:05"))
+                continue
+            }
+            fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Path, entry.Lock.ID, entry.Lock.Who, entry.Lock.Operation, entry.Acquired.Format("2006-01-02 15:04:05"))
+        }
+        tw.Flush()
+    case "show":
+        backend, rest, code := parse(fs, conn, args[1:], 1, 1)
+        if backend == nil {
+            return code
+        }
+        entries, err := backend.ListLocks(rest[0])
+        if err != nil {
+            return fail(err)
+        }
+        for _, entry := range entries {
+            if entry.Path == rest[0] {
+                return printJSON(entry)
+            }
+        }
+        return fail(fmt.Errorf("%s is not locked", rest[0]))
+    case "force-unlock":
+        backend, rest, code := parse(fs, conn, args[1:], 1, 1)
+        if backend == nil {
+            return code
+        }
+        if err := backend.ForceUnlock(rest[0]); err != nil {
+            return fail(err)
+        }
+        fmt.Printf("Released lock on %s\n", rest[0])
+    default:
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+    return 0
+}
+
+func exportCommand(args []string) int {
+    fs, conn := newFlagSet("export")
+    prefix := fs.String("prefix", "", "only export states whose path starts with prefix")
+    output := fs.String("output", "-", "archive to write, - for stdout")
+    backend, _, code := parse(fs, conn, args, 0, 0)
+    if backend == nil {
+        return code
+    }
+    var w io.Writer = os.Stdout
+    if *output != "-" {
+        file, err := os.Create(*output)
+        if err != nil {
+            return fail(err)
+        }
+        defer file.Close()
+        w = file
+    }
+    buffered := bufio.NewWriter(w)
+    count, err := admin.Export(backend, *prefix, buffered)
+    if err == nil {
+        err = buffered.Flush()
+    }
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Fprintf(os.Stderr, "Exported %d states\n", count)
+    return 0
+}
+
+func importCommand(args []string) int {
+    fs, conn := newFlagSet("import")
+    force := fs.Bool("force", false, "overwrite states that already exist")
+    backend, rest, code := parse(fs, conn, args, 0, 1)
+    if backend == nil {
+        return code
+    }
+    var r io.Reader = os.Stdin
+    if len(rest) == 1 && rest[0] != "-" {
+        file, err := os.Open(rest[0])
+        if err != nil {
+            return fail(err)
+        }
+        defer file.Close()
+        r = file
+    }
+    result, err := admin.Import(backend, bufio.NewReader(r), *force)
+    for _, path := range result.Skipped {
+        fmt.Fprintf(os.Stderr, "Skipped existing state %s\n", path)
+    }
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Fprintf(os.Stderr, "Imported %d states\n", result.Imported)
+    return 0
+}
+
+func fsckCommand(args []string) int {
+    fs, conn := newFlagSet("fsck")
+    backend, rest, code := parse(fs, conn, args, 0, 1)
+    if backend == nil {
+        return code
+    }
+    prefix := ""
+    if len(rest) == 1 {
+        prefix = rest[0]
+    }
+    problems, err := admin.Fsck(backend, prefix)
+    if err != nil {
+        return fail(err)
+    }
+    for _, problem := range problems {
+        fmt.Println(problem)
+    }
+    if len(problems) > 0 {
+        fmt.Fprintf(os.Stderr, "Found %d problems\n", len(problems))
+        return 1
+    }
+    fmt.Fprintln(os.Stderr, "No problems found")
+    return 0
+}
+
+func reindexCommand(args []string) int {
+    fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+    dataDir := fs.String("data-dir", config.GetEnv("DATA_DIR", "./data"), "data directory to index")
+    if err := fs.Parse(args); err != nil {
+        if err == flag.ErrHelp {
+            return 0
+        }
+        return 2
+    }
+    if fs.NArg() != 0 {
+        fmt.Fprint(os.Stderr, usage)
+        return 2
+    }
+    if _, err := os.Stat(*dataDir); err != nil {
+        return fail(err)
+    }
+    ix, err := index.Open(*dataDir)
+    if err == index.ErrInUse {
+        return fail(fmt.Errorf("%w, stop the server first", err))
+    } else if err != nil {
+        return fail(err)
+    }
+    defer ix.Close()
+    drift, err := ix.Rebuild()
+    if err != nil {
+        return fail(err)
+    }
+    fmt.Fprintf(os.Stderr, "Indexed %d states\n", drift.Added)
+    return 0
+}
+
+func printJSON(v interface{}) int {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(v); err != nil {
+        return fail(err)
+    }
+    return 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestLocalTenantMustExist(t *testing.T) {
+    dataDir := t.TempDir()
+    file := filepath.Join(dataDir, "tenants.json")
+    if err := os.WriteFile(file, []byte(`{"tenants": [{"name": "payments"}]}`), 0644); err != nil {
+        t.Fatalf("Failed to write tenants file: %v", err)
+    }
+    os.MkdirAll(filepath.Join(dataDir, "tenants", "payments"), 0755)
+
+    conn := &connection{dataDir: dataDir, tenant: "paymnets"}
+    if _, err := conn.backend(); err == nil {
+        t.Errorf("Unknown tenant was accepted without TENANTS_FILE")
+    }
+    t.Setenv("TENANTS_FILE", file)
+    if _, err := conn.backend(); err == nil {
+        t.Errorf("Unknown tenant was accepted")
+    }
+    conn.tenant = "payments"
+    if _, err := conn.backend(); err != nil {
+        t.Errorf("Known tenant was refused: %v", err)
+    }
+}
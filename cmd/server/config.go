@@ -0,0 +1,31 @@
+package main
+
+import (
+    "fmt"
+    "os"
+
+    "terraform-http-backend/internal/config"
+)
+
+// configCommand runs `config check [file]`, validating the configuration file
+// (CONFIG_FILE by default) and environment without starting the server
+func configCommand(args []string) int {
+    if len(args) == 0 || args[0] != "check" || len(args) > 2 {
+        fmt.Fprintln(os.Stderr, "Usage: terraform-http-backend config check [file]")
+        return 2
+    }
+    file := config.GetEnv("CONFIG_FILE", "")
+    if len(args) == 2 {
+        file = args[1]
+    }
+    if err := config.Check(file); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    if file == "" {
+        fmt.Println("Environment configuration is valid")
+    } else {
+        fmt.Printf("%s is valid\n", file)
+    }
+    return 0
+}
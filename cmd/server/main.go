@@ -1,45 +1,289 @@
 package main
 
 import (
-    "log"
+    "context"
+    "crypto/tls"
+    "log/slog"
     "net/http"
     "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
 
+    "terraform-http-backend/internal/aliases"
     "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/certs"
     "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/health"
+    "terraform-http-backend/internal/index"
     "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/logging"
+    "terraform-http-backend/internal/metrics"
+    "terraform-http-backend/internal/ratelimit"
+    "terraform-http-backend/internal/search"
     "terraform-http-backend/internal/states"
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/trash"
+    "terraform-http-backend/internal/utils"
 )
 
 func main() {
+    if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+        os.Exit(runCommand(os.Args[1:]))
+    }
+    serve()
+}
+
+// serve runs the backend server until it receives SIGTERM or SIGINT
+func serve() {
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+    defer stop()
+    run(ctx)
+}
+
+// run runs the backend server until ctx is done, then shuts it down gracefully
+func run(ctx context.Context) {
+    // Load and validate the configuration file and environment
+    loadConfig()
+    logging.Initialize()
+    initTracing(ctx)
+
     // Initialize authentication
     auth.Initialize()
+    watchAuth(ctx)
 
     // Get data directory from environment or use default
     dataDir := config.GetEnv("DATA_DIR", "./data")
-    log.Printf("Storing data in '%s'", dataDir)
+    slog.Info("Storing data", "dir", dataDir)
     createDataDir(dataDir)
+    openIndex(ctx, dataDir)
+    go trash.Watch(ctx, dataDir, time.Hour)
 
-    // Set up HTTP handlers with authentication
-    http.HandleFunc("/states/", auth.WithAuth(func(w http.ResponseWriter, r *http.Request) {
-        states.HandleStates(w, r, dataDir)
-    }))
-    http.HandleFunc("/locks/", auth.WithAuth(func(w http.ResponseWriter, r *http.Request) {
-        locks.HandleLocks(w, r, dataDir)
+    // Set up HTTP handlers with authentication, rate limited once the principal is known
+    ratelimit.Initialize()
+    mux := http.NewServeMux()
+    mux.HandleFunc("/states/", auth.WithAuth(ratelimit.Limit(func(w http.ResponseWriter, r *http.Request) {
+        states.HandleStates(w, r, tenants.DataDir(dataDir, r))
+    })))
+    mux.HandleFunc("/locks/", auth.WithAuth(ratelimit.Limit(func(w http.ResponseWriter, r *http.Request) {
+        locks.HandleLocks(w, r, tenants.DataDir(dataDir, r))
+    })))
+    mux.HandleFunc("/aliases/", auth.WithAuth(ratelimit.Limit(func(w http.ResponseWriter, r *http.Request) {
+        aliases.HandleAliases(w, r, tenants.DataDir(dataDir, r))
+    })))
+    mux.HandleFunc("/search", auth.WithAuth(ratelimit.Limit(func(w http.ResponseWriter, r *http.Request) {
+        search.HandleSearch(w, r, tenants.DataDir(dataDir, r))
+    })))
+    mux.HandleFunc("/tenants/", tenants.StripPrefix(mux))
+
+    // Probes are unauthenticated, status needs credentials like any other path
+    mux.HandleFunc("/healthz", health.HandleHealthz)
+    mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+        health.HandleReadyz(w, r, dataDir)
+    })
+    mux.HandleFunc("/status", auth.WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        health.HandleStatus(w, r, dataDir)
     }))
 
-    // Start the server
-    startServer()
+    // Metrics are unauthenticated unless METRICS_AUTH is set
+    metrics.Initialize()
+    metricsHandler := metrics.Handler(dataDir).ServeHTTP
+    if config.GetEnv("METRICS_AUTH", "false") == "true" {
+        metricsHandler = auth.WithAuth(metricsHandler)
+    }
+    mux.HandleFunc("/metrics", metricsHandler)
+
+    // Start the server, returning once it has shut down
+    startServer(ctx, tracing.Middleware(logging.Middleware(metrics.Instrument(mux))))
+    runShutdownHooks()
+    slog.Info("Server stopped")
+}
+
+// watchAuth reloads authentication settings on SIGHUP or when a credentials file changes
+func watchAuth(ctx context.Context) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    go func() {
+        defer signal.Stop(hup)
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-hup:
+                slog.Info("Received SIGHUP, reloading authentication settings")
+                if err := auth.Reload(); err != nil {
+                    slog.Error("Failed to reload authentication settings", "error", err)
+                }
+            }
+        }
+    }()
+    go auth.Watch(ctx, config.GetEnvDuration("AUTH_RELOAD_INTERVAL", 10*time.Second))
+}
+
+func loadConfig() {
+    file := config.GetEnv("CONFIG_FILE", "")
+    if err := config.Load(file); err != nil {
+        fatal("Invalid configuration", err)
+    }
+    if file != "" {
+        slog.Info("Loaded configuration", "file", file)
+    }
+}
+
+// initTracing installs the configured trace exporter, flushing it on shutdown
+func initTracing(ctx context.Context) {
+    shutdownTracing, err := tracing.Initialize(ctx, health.Version)
+    if err != nil {
+        fatal("Failed to initialize tracing", err)
+    }
+    onShutdown(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := shutdownTracing(ctx); err != nil {
+            slog.Error("Failed to flush traces", "error", err)
+        }
+    })
+}
+
+// fatal logs err and exits
+func fatal(message string, err error) {
+    slog.Error(message, "error", err)
+    os.Exit(1)
+}
+
+// openIndex opens the state index, first bringing it up to date with any
+// changes made to the files while the server wasn't running, then every
+// INDEX_SYNC_INTERVAL with changes made beside it
+func openIndex(ctx context.Context, dataDir string) {
+    if config.GetEnv("INDEX_ENABLED", "true") != "true" {
+        return
+    }
+    ix, err := index.Open(dataDir)
+    if err != nil {
+        fatal("Failed to open index", err)
+    }
+    start := time.Now()
+    drift, err := ix.Sync()
+    if err != nil {
+        fatal("Failed to check index", err)
+    }
+    if drift.Total() > 0 {
+        slog.Warn("Index was out of date with storage, updated it",
+            "added", drift.Added, "updated", drift.Updated, "removed", drift.Removed)
+    }
+    slog.Info("Index ready", "duration", time.Since(start).String())
+    index.Activate(ix)
+    if interval := config.GetEnvDuration("INDEX_SYNC_INTERVAL", 5*time.Minute); interval > 0 {
+        go index.Watch(ctx, ix, interval)
+    }
+    onShutdown(func() {
+        index.Activate(nil)
+        if err := ix.Close(); err != nil {
+            slog.Error("Failed to close index", "error", err)
+        }
+    })
 }
 
 func createDataDir(dataDir string) {
     if err := os.MkdirAll(dataDir, 0755); err != nil {
-        log.Fatalf("Failed to create storage root directory: %v", err)
+        fatal("Failed to create storage root directory", err)
     }
 }
 
-func startServer() {
+// startServer serves handler until ctx is done, then stops accepting
+// connections and waits for in-flight requests to finish
+func startServer(ctx context.Context, handler http.Handler) {
     port := config.GetEnv("PORT", "9944")
-    log.Printf("Starting server on port %s", port)
-    log.Fatal(http.ListenAndServe(":"+port, nil))
-}
\ No newline at end of file
+    server := &http.Server{
+        Addr:    config.GetEnv("HOST", "") + ":" + port,
+        Handler: utils.BodyReadTimeout(handler, config.GetEnvDuration("BODY_READ_TIMEOUT", 30*time.Second)),
+        // No read or write timeout by default, so large states can take as long as they need
+        ReadHeaderTimeout: config.GetEnvDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+        ReadTimeout:       config.GetEnvDuration("READ_TIMEOUT", 0),
+        WriteTimeout:      config.GetEnvDuration("WRITE_TIMEOUT", 0),
+        IdleTimeout:       config.GetEnvDuration("IDLE_TIMEOUT", 2*time.Minute),
+    }
+    servers := []*http.Server{server}
+    errs := make(chan error, 2)
+    certFile, keyFile := config.GetEnv("TLS_CERT_FILE", ""), config.GetEnv("TLS_KEY_FILE", "")
+    if certFile != "" && keyFile != "" {
+        server.TLSConfig = tlsConfig(ctx, certFile, keyFile)
+        if redirectPort := config.GetEnv("HTTP_REDIRECT_PORT", ""); redirectPort != "" {
+            redirect := &http.Server{
+                Addr:              config.GetEnv("HOST", "") + ":" + redirectPort,
+                Handler:           certs.RedirectHandler(port),
+                ReadHeaderTimeout: server.ReadHeaderTimeout,
+                IdleTimeout:       server.IdleTimeout,
+            }
+            servers = append(servers, redirect)
+            slog.Info("Redirecting HTTP to HTTPS", "port", redirectPort)
+            go func() { errs <- redirect.ListenAndServe() }()
+        }
+        slog.Info("Starting TLS server", "port", port)
+        go func() { errs <- server.ListenAndServeTLS("", "") }()
+    } else {
+        slog.Info("Starting server", "port", port)
+        go func() { errs <- server.ListenAndServe() }()
+    }
+
+    select {
+    case err := <-errs:
+        fatal("Server failed", err)
+    case <-ctx.Done():
+    }
+    shutdown(servers, config.GetEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+}
+
+// shutdown stops servers gracefully, closing connections still active after timeout.
+// Interrupted uploads never replace a state, as states are only renamed into place once complete.
+func shutdown(servers []*http.Server, timeout time.Duration) {
+    health.SetDraining()
+    if delay := config.GetEnvDuration("SHUTDOWN_DELAY", 0); delay > 0 {
+        // keep serving while load balancers notice /readyz failing
+        slog.Info("Draining, failing readiness before shutting down", "delay", delay.String())
+        time.Sleep(delay)
+    }
+    slog.Info("Shutting down, waiting for in-flight requests", "timeout", timeout.String())
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    for _, server := range servers {
+        if err := server.Shutdown(ctx); err != nil {
+            slog.Warn("Requests still in flight, closing connections", "timeout", timeout.String(), "error", err)
+            server.Close()
+        }
+    }
+}
+
+// shutdownHooks flush sinks once the server has stopped handling requests
+var shutdownHooks []func()
+
+// onShutdown registers hook to run after the server has shut down
+func onShutdown(hook func()) {
+    shutdownHooks = append(shutdownHooks, hook)
+}
+
+func runShutdownHooks() {
+    for _, hook := range shutdownHooks {
+        hook()
+    }
+}
+
+// tlsConfig loads the server certificate, reloading it whenever it's renewed
+func tlsConfig(ctx context.Context, certFile, keyFile string) *tls.Config {
+    cert, err := certs.Load(certFile, keyFile)
+    if err != nil {
+        fatal("Failed to load TLS certificate", err)
+    }
+    go cert.Watch(ctx, config.GetEnvDuration("TLS_RELOAD_INTERVAL", time.Minute))
+    cipherSuites, err := config.CipherSuites(config.GetEnv("TLS_CIPHER_SUITES", ""))
+    if err != nil {
+        fatal("Invalid TLS_CIPHER_SUITES", err)
+    }
+    return &tls.Config{
+        MinVersion:     config.TLSVersions[config.GetEnv("TLS_MIN_VERSION", "1.2")],
+        CipherSuites:   cipherSuites,
+        GetCertificate: cert.GetCertificate,
+    }
+}
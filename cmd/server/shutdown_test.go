@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// freePort returns a port nothing is listening on
+func freePort(t *testing.T) string {
+    t.Helper()
+    listener, err := net.Listen("tcp", "localhost:0")
+    if err != nil {
+        t.Fatalf("Failed to find a free port: %v", err)
+    }
+    defer listener.Close()
+    return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// waitFor polls until ready returns true, failing the test after timeout
+func waitFor(t *testing.T, what string, timeout time.Duration, ready func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(timeout); !ready(); time.Sleep(10 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("Timed out waiting for %s", what)
+        }
+    }
+}
+
+// TestGracefulShutdown stops the server during a slow upload and checks the
+// upload completes before the server stops
+func TestGracefulShutdown(t *testing.T) {
+    dataDir := t.TempDir()
+    port := freePort(t)
+    t.Setenv("DATA_DIR", dataDir)
+    t.Setenv("PORT", port)
+    t.Setenv("SHUTDOWN_TIMEOUT", "10s")
+    baseURL := "http://localhost:" + port
+
+    ctx, stop := context.WithCancel(context.Background())
+    defer stop()
+    stopped := make(chan struct{})
+    go func() {
+        run(ctx)
+        close(stopped)
+    }()
+    waitFor(t, "the server to listen", 5*time.Second, func() bool {
+        resp, err := http.Get(baseURL + "/healthz")
+        if err == nil {
+            resp.Body.Close()
+        }
+        return err == nil
+    })
+
+    for _, probe := range []string{"/healthz", "/readyz"} {
+        resp, err := http.Get(baseURL + probe)
+        if err != nil {
+            t.Fatalf("GET %s failed: %v", probe, err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            t.Errorf("GET %s returned status %v; want %v", probe, resp.StatusCode, http.StatusOK)
+        }
+    }
+
+    // The server asks for the body once the handler reads it, so the upload
+    // is known to be in flight before shutting down
+    body, upload := io.Pipe()
+    reading := make(chan struct{})
+    trace := &httptrace.ClientTrace{Got100Continue: func() { close(reading) }}
+    req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodPost, baseURL+"/states/slow.tfstate", body)
+    req.Header.Set("Expect", "100-continue")
+    responses := make(chan *http.Response, 1)
+    go func() {
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Errorf("POST request failed: %v", err)
+            close(responses)
+            return
+        }
+        responses <- resp
+    }()
+    select {
+    case <-reading:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("Server never started reading the upload")
+    }
+    upload.Write([]byte(`{"version": 4, `))
+
+    stop()
+    waitFor(t, "the server to refuse new connections", 5*time.Second, func() bool {
+        resp, err := http.Get(baseURL + "/healthz")
+        if err == nil {
+            resp.Body.Close()
+        }
+        return err != nil
+    })
+    select {
+    case <-stopped:
+        t.Fatalf("Server stopped before the in-flight upload finished")
+    default:
+    }
+
+    upload.Write([]byte(`"serial": 1}`))
+    upload.Close()
+
+    resp, ok := <-responses
+    if !ok {
+        return
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("POST during shutdown returned status %v; want %v", resp.StatusCode, http.StatusOK)
+    }
+
+    select {
+    case <-stopped:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("Server didn't stop after the upload finished")
+    }
+
+    data, err := os.ReadFile(filepath.Join(dataDir, "states", "slow.tfstate"))
+    if err != nil || string(data) != `{"version": 4, "serial": 1}` {
+        t.Errorf("State after shutdown = %q, %v; want the complete upload", data, err)
+    }
+    leftovers, _ := filepath.Glob(filepath.Join(dataDir, "states", ".tfstate-*"))
+    if len(leftovers) != 0 {
+        t.Errorf("Temporary files left behind: %s", strings.Join(leftovers, ", "))
+    }
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+    "errors"
+    "os"
+
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/states"
+)
+
+// ErrNotFound is returned when a state, version or lock doesn't exist
+var ErrNotFound = os.ErrNotExist
+
+// ErrLocked is returned when an operation is refused because the state is locked
+var ErrLocked = states.ErrLocked
+
+// Backend administers states and locks, either in a local data directory or on a running server
+type Backend interface {
+    ListStates(prefix string) ([]states.Entry, error)
+    ReadState(path string) ([]byte, error)
+    WriteState(path string, data []byte) error
+    DeleteState(path string) error
+    History(path string) ([]history.Version, error)
+    Rollback(path, version string) error
+    ListLocks(prefix string) ([]locks.Entry, error)
+    ForceUnlock(path string) error
+}
+
+// IsNotFound reports whether err means the requested item doesn't exist
+func IsNotFound(err error) bool {
+    return errors.Is(err, ErrNotFound)
+}
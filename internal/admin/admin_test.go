@@ -0,0 +1,179 @@
+package admin
+
+import (
+    "bytes"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/states"
+)
+
+// newRemote starts a server handling the states and locks routes on dataDir
+func newRemote(t *testing.T, dataDir string) *Remote {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/states/", func(w http.ResponseWriter, r *http.Request) {
+        states.HandleStates(w, r, dataDir)
+    })
+    mux.HandleFunc("/locks/", func(w http.ResponseWriter, r *http.Request) {
+        locks.HandleLocks(w, r, dataDir)
+    })
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+    return NewRemote(server.URL, "", "")
+}
+
+func TestBackends(t *testing.T) {
+    for name, backend := range map[string]func(string) Backend{
+        "local":  func(dataDir string) Backend { return NewLocal(dataDir) },
+        "remote": func(dataDir string) Backend { return newRemote(t, dataDir) },
+    } {
+        t.Run(name, func(t *testing.T) {
+            dataDir, err := ioutil.TempDir("", "admin")
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer os.RemoveAll(dataDir)
+            testBackend(t, backend(dataDir), dataDir)
+        })
+    }
+}
+
+func testBackend(t *testing.T, backend Backend, dataDir string) {
+    if err := backend.WriteState("team/app.tfstate", []byte(`{"version": 4}`)); err != nil {
+        t.Fatalf("WriteState failed: %v", err)
+    }
+    if err := backend.WriteState("other.tfstate", []byte(`{"version": 4}`)); err != nil {
+        t.Fatalf("WriteState failed: %v", err)
+    }
+
+    entries, err := backend.ListStates("team/")
+    if err != nil {
+        t.Fatalf("ListStates failed: %v", err)
+    }
+    if len(entries) != 1 || entries[0].Path != "team/app.tfstate" {
+        t.Errorf("ListStates returned %+v, want only team/app.tfstate", entries)
+    }
+
+    data, err := backend.ReadState("team/app.tfstate")
+    if err != nil || string(data) != `{"version": 4}` {
+        t.Errorf("ReadState returned %q, %v", data, err)
+    }
+    if _, err := backend.ReadState("missing.tfstate"); !IsNotFound(err) {
+        t.Errorf("ReadState of a missing state returned %v, want not found", err)
+    }
+
+    lockfilePath := filepath.Join(dataDir, "locks", "team", "app.tfstate")
+    os.MkdirAll(filepath.Dir(lockfilePath), 0755)
+    ioutil.WriteFile(lockfilePath, []byte(`{"ID": "abc", "Who": "tester"}`), 0644)
+    lockEntries, err := backend.ListLocks("")
+    if err != nil {
+        t.Fatalf("ListLocks failed: %v", err)
+    }
+    if len(lockEntries) != 1 || lockEntries[0].Lock == nil || lockEntries[0].Lock.ID != "abc" {
+        t.Errorf("ListLocks returned %+v, want the lock abc", lockEntries)
+    }
+    if err := backend.ForceUnlock("team/app.tfstate"); err != nil {
+        t.Errorf("ForceUnlock failed: %v", err)
+    }
+    if _, err := os.Stat(lockfilePath); !os.IsNotExist(err) {
+        t.Errorf("Lock file still exists after ForceUnlock")
+    }
+
+    if err := backend.DeleteState("other.tfstate"); err != nil {
+        t.Errorf("DeleteState failed: %v", err)
+    }
+    if err := backend.DeleteState("other.tfstate"); !IsNotFound(err) {
+        t.Errorf("DeleteState of a missing state returned %v, want not found", err)
+    }
+}
+
+func TestExportImport(t *testing.T) {
+    source, _ := ioutil.TempDir("", "admin")
+    defer os.RemoveAll(source)
+    target, _ := ioutil.TempDir("", "admin")
+    defer os.RemoveAll(target)
+
+    from := NewLocal(source)
+    from.WriteState("a.tfstate", []byte(`{"version": 4, "serial": 1}`))
+    from.WriteState("team/b.tfstate", []byte(`{"version": 4, "serial": 2}`))
+
+    var archive bytes.Buffer
+    count, err := Export(from, "", &archive)
+    if err != nil || count != 2 {
+        t.Fatalf("Export returned %d, %v; want 2 states", count, err)
+    }
+
+    to := NewLocal(target)
+    to.WriteState("a.tfstate", []byte(`{"version": 4, "serial": 9}`))
+    result, err := Import(to, bytes.NewReader(archive.Bytes()), false)
+    if err != nil {
+        t.Fatalf("Import failed: %v", err)
+    }
+    if result.Imported != 1 || len(result.Skipped) != 1 || result.Skipped[0] != "a.tfstate" {
+        t.Errorf("Import returned %+v, want 1 imported and a.tfstate skipped", result)
+    }
+    if data, _ := to.ReadState("a.tfstate"); !strings.Contains(string(data), `"serial": 9`) {
+        t.Errorf("Import overwrote an existing state without force: %s", data)
+    }
+
+    result, err = Import(to, bytes.NewReader(archive.Bytes()), true)
+    if err != nil || result.Imported != 2 {
+        t.Fatalf("Import with force returned %+v, %v; want 2 imported", result, err)
+    }
+    if data, _ := to.ReadState("a.tfstate"); !strings.Contains(string(data), `"serial": 1`) {
+        t.Errorf("Import with force didn't overwrite the existing state: %s", data)
+    }
+}
+
+func TestFsck(t *testing.T) {
+    dataDir, _ := ioutil.TempDir("", "admin")
+    defer os.RemoveAll(dataDir)
+
+    backend := NewLocal(dataDir)
+    backend.WriteState("good.tfstate", []byte(`{"version": 4}`))
+    backend.WriteState("broken.tfstate", []byte(`{"version": `))
+    backend.WriteState("noversion.tfstate", []byte(`{}`))
+    ioutil.WriteFile(filepath.Join(dataDir, "states", ".tfstate-123"), []byte("partial"), 0644)
+    os.MkdirAll(filepath.Join(dataDir, "locks"), 0755)
+    ioutil.WriteFile(filepath.Join(dataDir, "locks", "good.tfstate"), []byte("not json"), 0644)
+
+    problems, err := Fsck(backend, "")
+    if err != nil {
+        t.Fatalf("Fsck failed: %v", err)
+    }
+    var paths []string
+    for _, problem := range problems {
+        paths = append(paths, problem.Path)
+    }
+    want := "states/broken.tfstate states/noversion.tfstate locks/good.tfstate states/.tfstate-123"
+    if got := strings.Join(paths, " "); got != want {
+        t.Errorf("Fsck reported %q, want %q", got, want)
+    }
+}
+
+func TestLocalRefusesLockedStates(t *testing.T) {
+    dataDir := t.TempDir()
+    backend := NewLocal(dataDir)
+    if err := backend.WriteState("app.tfstate", []byte(`{"version": 4}`)); err != nil {
+        t.Fatalf("WriteState failed: %v", err)
+    }
+    lockFile := filepath.Join(dataDir, "locks", "app.tfstate")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    os.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+
+    if err := backend.WriteState("app.tfstate", []byte(`{"version": 4, "serial": 2}`)); err != states.ErrLocked {
+        t.Errorf("WriteState of a locked state returned %v; want ErrLocked", err)
+    }
+    if err := backend.DeleteState("app.tfstate"); err != states.ErrLocked {
+        t.Errorf("DeleteState of a locked state returned %v; want ErrLocked", err)
+    }
+    if data, _ := backend.ReadState("app.tfstate"); string(data) != `{"version": 4}` {
+        t.Errorf("Locked state was changed: %s", data)
+    }
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "path"
+    "strings"
+    "time"
+)
+
+// Export writes every state under prefix to w as a gzipped tar archive with
+// one states/<path> entry per state, returning how many states were written
+func Export(backend Backend, prefix string, w io.Writer) (int, error) {
+    entries, err := backend.ListStates(prefix)
+    if err != nil {
+        return 0, err
+    }
+    gz := gzip.NewWriter(w)
+    archive := tar.NewWriter(gz)
+    for _, entry := range entries {
+        data, err := backend.ReadState(entry.Path)
+        if err != nil {
+            return 0, fmt.Errorf("reading %s: %w", entry.Path, err)
+        }
+        modified := entry.Modified
+        if modified.IsZero() {
+            modified = time.Now()
+        }
+        header := &tar.Header{
+            Name:    "states/" + entry.Path,
+            Mode:    0644,
+            Size:    int64(len(data)),
+            ModTime: modified,
+        }
+        if err := archive.WriteHeader(header); err != nil {
+            return 0, err
+        }
+        if _, err := archive.Write(data); err != nil {
+            return 0, err
+        }
+    }
+    if err := archive.Close(); err != nil {
+        return 0, err
+    }
+    return len(entries), gz.Close()
+}
+
+// ImportResult counts what Import did with the states in an archive
+type ImportResult struct {
+    Imported int
+    Skipped  []string
+}
+
+// Import writes the states in a gzipped tar archive produced by Export.
+// States that already exist are skipped unless overwrite is set.
+func Import(backend Backend, r io.Reader, overwrite bool) (ImportResult, error) {
+    var result ImportResult
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return result, err
+    }
+    defer gz.Close()
+    archive := tar.NewReader(gz)
+    for {
+        header, err := archive.Next()
+        if err == io.EOF {
+            return result, nil
+        }
+        if err != nil {
+            return result, err
+        }
+        if header.Typeflag != tar.TypeReg {
+            continue
+        }
+        statePath, ok := strings.CutPrefix(path.Clean(header.Name), "states/")
+        if !ok || statePath == "" {
+            return result, fmt.Errorf("unexpected archive entry %q", header.Name)
+        }
+        if !overwrite {
+            if _, err := backend.ReadState(statePath); err == nil {
+                result.Skipped = append(result.Skipped, statePath)
+                continue
+            } else if !IsNotFound(err) {
+                return result, fmt.Errorf("checking %s: %w", statePath, err)
+            }
+        }
+        data, err := io.ReadAll(archive)
+        if err != nil {
+            return result, err
+        }
+        if err := backend.WriteState(statePath, data); err != nil {
+            return result, fmt.Errorf("writing %s: %w", statePath, err)
+        }
+        result.Imported++
+    }
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Problem is an inconsistency found by Fsck
+type Problem struct {
+    Path    string
+    Message string
+}
+
+func (p Problem) String() string {
+    return p.Path + ": " + p.Message
+}
+
+// Fsck checks that every state under prefix is a readable Terraform state and
+// every lock can be parsed. Problems are reported, not fixed.
+func Fsck(backend Backend, prefix string) ([]Problem, error) {
+    var problems []Problem
+    entries, err := backend.ListStates(prefix)
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range entries {
+        data, err := backend.ReadState(entry.Path)
+        if err != nil {
+            problems = append(problems, Problem{"states/" + entry.Path, err.Error()})
+            continue
+        }
+        if message := checkState(data); message != "" {
+            problems = append(problems, Problem{"states/" + entry.Path, message})
+        }
+    }
+    lockEntries, err := backend.ListLocks(prefix)
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range lockEntries {
+        if entry.Error != "" {
+            problems = append(problems, Problem{"locks/" + entry.Path, "unreadable lock: " + entry.Error})
+        }
+    }
+    if local, ok := backend.(*Local); ok {
+        leftovers, err := local.temporaryFiles()
+        if err != nil {
+            return nil, err
+        }
+        problems = append(problems, leftovers...)
+    }
+    return problems, nil
+}
+
+// checkState describes what is wrong with a state file, or returns "" when it looks valid
+func checkState(data []byte) string {
+    if len(data) == 0 {
+        return "empty state"
+    }
+    var state struct {
+        Version *int `json:"version"`
+    }
+    if err := json.Unmarshal(data, &state); err != nil {
+        return fmt.Sprintf("invalid JSON: %v", err)
+    }
+    if state.Version == nil {
+        return "missing \"version\" field"
+    }
+    return ""
+}
+
+// temporaryFiles finds uploads that were interrupted before being renamed into place
+func (l *Local) temporaryFiles() ([]Problem, error) {
+    var problems []Problem
+    root := filepath.Join(l.DataDir, "states")
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if !d.IsDir() && strings.HasPrefix(d.Name(), ".tfstate-") {
+            rel, err := filepath.Rel(l.DataDir, path)
+            if err != nil {
+                return err
+            }
+            problems = append(problems, Problem{filepath.ToSlash(rel), "leftover temporary file from an interrupted upload"})
+        }
+        return nil
+    })
+    return problems, err
+}
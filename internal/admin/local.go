@@ -0,0 +1,66 @@
+package admin
+
+import (
+    "bytes"
+    "os"
+    "os/user"
+
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/states"
+)
+
+// Local administers the states and locks stored in a data directory directly
+type Local struct {
+    DataDir string
+}
+
+// NewLocal returns a Backend for the data directory dataDir
+func NewLocal(dataDir string) *Local {
+    return &Local{DataDir: dataDir}
+}
+
+func (l *Local) ListStates(prefix string) ([]states.Entry, error) {
+    entries, err := states.List(l.DataDir, prefix)
+    if err != nil {
+        return nil, err
+    }
+    return entries, states.Describe(l.DataDir, entries)
+}
+
+func (l *Local) ReadState(path string) ([]byte, error) {
+    return os.ReadFile(states.FilePath(l.DataDir, path))
+}
+
+func (l *Local) WriteState(path string, data []byte) error {
+    return states.Save(l.DataDir, path, bytes.NewReader(data))
+}
+
+func (l *Local) DeleteState(path string) error {
+    return states.Delete(l.DataDir, path, localOperator())
+}
+
+// localOperator names who deletes states directly in the data directory, the
+// system user running the command
+func localOperator() string {
+    if current, err := user.Current(); err == nil {
+        return current.Username
+    }
+    return "local"
+}
+
+func (l *Local) History(path string) ([]history.Version, error) {
+    return history.List(l.DataDir, path)
+}
+
+func (l *Local) Rollback(path, version string) error {
+    return states.Rollback(l.DataDir, path, version)
+}
+
+func (l *Local) ListLocks(prefix string) ([]locks.Entry, error) {
+    return locks.List(l.DataDir, prefix)
+}
+
+func (l *Local) ForceUnlock(path string) error {
+    return locks.ForceUnlock(l.DataDir, path)
+}
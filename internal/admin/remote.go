@@ -0,0 +1,136 @@
+package admin
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/states"
+)
+
+// Remote administers the states and locks of a running server over its HTTP API
+type Remote struct {
+    BaseURL  string
+    Username string
+    Password string
+    Client   *http.Client
+}
+
+// NewRemote returns a Backend for the server at baseURL, e.g. http://localhost:9944
+// or http://localhost:9944/tenants/payments
+func NewRemote(baseURL, username, password string) *Remote {
+    return &Remote{
+        BaseURL:  strings.TrimSuffix(baseURL, "/"),
+        Username: username,
+        Password: password,
+        Client:   http.DefaultClient,
+    }
+}
+
+func (c *Remote) ListStates(prefix string) ([]states.Entry, error) {
+    var entries []states.Entry
+    query := url.Values{"prefix": {prefix}, "recursive": {"true"}, "limit": {"1000"}}
+    for {
+        var page states.Page
+        if err := c.getJSON("/states/?"+query.Encode(), &page); err != nil {
+            return nil, err
+        }
+        entries = append(entries, page.States...)
+        if page.NextOffset == 0 {
+            return entries, nil
+        }
+        query.Set("offset", strconv.Itoa(page.NextOffset))
+    }
+}
+
+func (c *Remote) ReadState(path string) ([]byte, error) {
+    return c.do(http.MethodGet, "/states/"+escapePath(path), nil)
+}
+
+func (c *Remote) WriteState(path string, data []byte) error {
+    _, err := c.do(http.MethodPost, "/states/"+escapePath(path), data)
+    return err
+}
+
+func (c *Remote) DeleteState(path string) error {
+    _, err := c.do(http.MethodDelete, "/states/"+escapePath(path), nil)
+    return err
+}
+
+func (c *Remote) History(path string) ([]history.Version, error) {
+    var response struct {
+        Versions []history.Version `json:"versions"`
+    }
+    err := c.getJSON("/states/"+escapePath(path)+"?history", &response)
+    return response.Versions, err
+}
+
+func (c *Remote) Rollback(path, version string) error {
+    _, err := c.do(http.MethodPost, "/states/"+escapePath(path)+"?rollback="+url.QueryEscape(version), nil)
+    return err
+}
+
+func (c *Remote) ListLocks(prefix string) ([]locks.Entry, error) {
+    var response struct {
+        Locks []locks.Entry `json:"locks"`
+    }
+    err := c.getJSON("/locks/?"+url.Values{"prefix": {prefix}}.Encode(), &response)
+    return response.Locks, err
+}
+
+func (c *Remote) ForceUnlock(path string) error {
+    _, err := c.do("UNLOCK", "/locks/"+escapePath(path)+"?force=true", nil)
+    return err
+}
+
+func (c *Remote) getJSON(path string, v interface{}) error {
+    data, err := c.do(http.MethodGet, path, nil)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// do sends a request and returns the response body, mapping error statuses to errors
+func (c *Remote) do(method, path string, body []byte) ([]byte, error) {
+    req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if c.Username != "" {
+        req.SetBasicAuth(c.Username, c.Password)
+    }
+    resp, err := c.Client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    switch {
+    case resp.StatusCode == http.StatusNotFound:
+        return nil, fmt.Errorf("%s %s: %w", method, path, ErrNotFound)
+    case resp.StatusCode == http.StatusLocked:
+        return nil, fmt.Errorf("%s %s: %w", method, path, ErrLocked)
+    case resp.StatusCode >= 300:
+        return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+    }
+    return data, nil
+}
+
+func escapePath(path string) string {
+    segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+    for i, segment := range segments {
+        segments[i] = url.PathEscape(segment)
+    }
+    return strings.Join(segments, "/")
+}
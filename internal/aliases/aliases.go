@@ -0,0 +1,325 @@
+package aliases
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/utils"
+)
+
+// Mode is how requests for an alias are answered
+type Mode string
+
+const (
+    // Redirect answers with 308 Permanent Redirect to the target
+    Redirect Mode = "redirect"
+    // Forward serves the request from the target as if it was made there
+    Forward Mode = "forward"
+)
+
+// maxHops bounds following aliases of aliases, which only loop once states
+// moved back and forth are deleted
+const maxHops = 8
+
+// ErrLoop is returned when following aliases doesn't reach a path without one
+var ErrLoop = errors.New("too many aliases followed")
+
+// Alias sends requests for the old path of a moved state to its new path. Unlike
+// a tombstone it stays until removed.
+type Alias struct {
+    Path     string    `json:"path"`
+    Target   string    `json:"target"`
+    Mode     Mode      `json:"mode"`
+    Created  time.Time `json:"created"`
+    Operator string    `json:"operator,omitempty"`
+}
+
+// DefaultMode returns the mode of the aliases left by moves from
+// MOVE_ALIAS_MODE, false when moves leave a tombstone instead
+func DefaultMode() (Mode, bool) {
+    switch mode := config.GetEnv("MOVE_ALIAS_MODE", string(Redirect)); mode {
+    case string(Redirect), string(Forward):
+        return Mode(mode), true
+    default:
+        return "", false
+    }
+}
+
+// ParseMode reads an alias mode, "redirect" or "forward"
+func ParseMode(value string) (Mode, error) {
+    if value != string(Redirect) && value != string(Forward) {
+        return "", fmt.Errorf("invalid alias mode %q, must be redirect or forward", value)
+    }
+    return Mode(value), nil
+}
+
+func clean(statePath string) string {
+    return filepath.ToSlash(filepath.Clean("/" + statePath))
+}
+
+func file(dataDir, statePath string) string {
+    return filepath.Join(dataDir, "aliases", filepath.FromSlash(clean(statePath)))
+}
+
+func stateFile(dataDir, statePath string) string {
+    return filepath.Join(dataDir, "states", filepath.FromSlash(clean(statePath)))
+}
+
+// Write stores alias, replacing any alias at its path
+func Write(dataDir string, alias Alias) error {
+    alias.Path, alias.Target, alias.Created = clean(alias.Path), clean(alias.Target), alias.Created.UTC()
+    data, err := json.Marshal(alias)
+    if err != nil {
+        return err
+    }
+    aliasFile := file(dataDir, alias.Path)
+    if err := os.MkdirAll(filepath.Dir(aliasFile), 0755); err != nil {
+        return err
+    }
+    return os.WriteFile(aliasFile, data, 0644)
+}
+
+// Remove removes the alias at statePath, if any
+func Remove(dataDir, statePath string) error {
+    if err := os.Remove(file(dataDir, statePath)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// Read returns the alias at statePath, nil when there is none
+func Read(dataDir, statePath string) (*Alias, error) {
+    aliasFile := file(dataDir, statePath)
+    if info, err := os.Stat(aliasFile); err != nil || info.IsDir() {
+        return nil, nil
+    }
+    data, err := os.ReadFile(aliasFile)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    var alias Alias
+    if err := json.Unmarshal(data, &alias); err != nil {
+        return nil, fmt.Errorf("alias of %s: %w", statePath, err)
+    }
+    return &alias, nil
+}
+
+// List returns the aliases whose path starts with prefix, sorted by path
+func List(dataDir, prefix string) ([]Alias, error) {
+    aliasesDir := filepath.Join(dataDir, "aliases")
+    list := []Alias{}
+    err := filepath.WalkDir(aliasesDir, func(path string, d fs.DirEntry, err error) error {
+        if os.IsNotExist(err) {
+            return nil
+        } else if err != nil || d.IsDir() {
+            return err
+        }
+        rel, err := filepath.Rel(aliasesDir, path)
+        if err != nil {
+            return err
+        }
+        alias, err := Read(dataDir, rel)
+        if err != nil || alias == nil || !strings.HasPrefix(strings.TrimPrefix(alias.Path, "/"), prefix) {
+            return err
+        }
+        list = append(list, *alias)
+        return nil
+    })
+    sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+    return list, err
+}
+
+// find returns the alias at statePath, or at one of its parents for paths
+// below a state such as <path>/outputs along with the rest of the path
+// below it. A stored state on the way shadows any alias.
+func find(dataDir, statePath string) (*Alias, string, error) {
+    statePath = clean(statePath)
+    for path := statePath; path != "/"; path = filepath.ToSlash(filepath.Dir(path)) {
+        if info, err := os.Stat(stateFile(dataDir, path)); err == nil && !info.IsDir() {
+            return nil, "", nil
+        }
+        alias, err := Read(dataDir, path)
+        if err != nil || alias != nil {
+            return alias, strings.TrimPrefix(statePath, path), err
+        }
+    }
+    return nil, "", nil
+}
+
+// Resolve follows the aliases from statePath, returning the first alias met
+// (nil when there is none) and the path they lead to
+func Resolve(dataDir, statePath string) (*Alias, string, error) {
+    var first *Alias
+    path := clean(statePath)
+    for hops := 0; ; hops++ {
+        alias, rest, err := find(dataDir, path)
+        if err != nil {
+            return nil, "", err
+        }
+        if alias == nil {
+            return first, path, nil
+        }
+        if hops == maxHops {
+            return nil, "", ErrLoop
+        }
+        if first == nil {
+            first = alias
+        }
+        path = alias.Target + rest
+    }
+}
+
+// Apply handles a /states or /locks request for a path with an alias:
+// redirect aliases are answered with 308 to the new path, returning true,
+// while forward aliases return the request rewritten to the new path. Both
+// explain the move, in the body or a Warning header.
+func Apply(w http.ResponseWriter, r *http.Request, dataDir string) (*http.Request, bool) {
+    if strings.HasSuffix(r.URL.Path, "/") {
+        return r, false
+    }
+    route, statePath := utils.SplitPath(r.URL.Path)
+    alias, target, err := Resolve(dataDir, statePath)
+    if err != nil {
+        utils.HTTPError(w, r, "Error resolving state alias", err)
+        return r, true
+    }
+    if alias == nil {
+        return r, false
+    }
+    message := fmt.Sprintf("State moved from %s to %s at %s, update the backend address.",
+        strings.TrimPrefix(alias.Path, "/"), strings.TrimPrefix(alias.Target, "/"), alias.Created.Format(time.RFC3339))
+
+    if alias.Mode == Redirect {
+        location := url.URL{Path: "/" + route + target, RawQuery: r.URL.RawQuery}
+        if name := tenants.Requested(r); name != "" {
+            location.Path = "/tenants/" + name + location.Path
+        }
+        w.Header().Set("Location", location.String())
+        http.Error(w, message, http.StatusPermanentRedirect)
+        slog.WarnContext(r.Context(), "Redirected request for moved state", "path", statePath, "location", location.String())
+        return r, true
+    }
+    w.Header().Set("Warning", `299 - "`+message+`"`)
+    r2 := r.Clone(r.Context())
+    r2.URL.Path = "/" + route + target
+    r2.URL.RawPath = ""
+    slog.WarnContext(r.Context(), "Forwarded request for moved state", "path", statePath, "target", target)
+    return r2, false
+}
+
+// HandleAliases lists, reads, creates and removes aliases. Changing them is an
+// administrative operation.
+func HandleAliases(w http.ResponseWriter, r *http.Request, dataDir string) {
+    _, statePath := utils.SplitPath(r.URL.Path)
+    switch r.Method {
+    case http.MethodGet:
+        if strings.HasSuffix(r.URL.Path, "/") {
+            list, err := List(dataDir, r.URL.Query().Get("prefix"))
+            if err != nil {
+                utils.HTTPError(w, r, "Error listing aliases", err)
+                return
+            }
+            utils.WriteJSON(w, map[string]interface{}{"aliases": list})
+            return
+        }
+        alias, err := Read(dataDir, statePath)
+        if err != nil {
+            utils.HTTPError(w, r, "Error reading alias", err)
+            return
+        } else if alias == nil {
+            http.NotFound(w, r)
+            return
+        }
+        utils.WriteJSON(w, alias)
+    case http.MethodPost, http.MethodPut:
+        if !admin(w, r) {
+            return
+        }
+        writeAlias(w, r, dataDir, statePath)
+    case http.MethodDelete:
+        if !admin(w, r) {
+            return
+        }
+        if alias, err := Read(dataDir, statePath); err != nil || alias == nil {
+            http.NotFound(w, r)
+            return
+        }
+        if err := Remove(dataDir, statePath); err != nil {
+            utils.HTTPError(w, r, "Error removing alias", err)
+            return
+        }
+        w.WriteHeader(http.StatusOK)
+        slog.InfoContext(r.Context(), "Alias removed", "path", statePath)
+    default:
+        utils.MethodNotAllowed(w, r)
+    }
+}
+
+func admin(w http.ResponseWriter, r *http.Request) bool {
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && !principal.Admin {
+        http.Error(w, "Forbidden", http.StatusForbidden)
+        slog.WarnContext(r.Context(), "Forbidden alias change", "path", r.URL.Path, "principal", principal.Username)
+        return false
+    }
+    return true
+}
+
+// writeAlias creates the alias at statePath to the target query parameter,
+// which must lead to a stored state
+func writeAlias(w http.ResponseWriter, r *http.Request, dataDir, statePath string) {
+    if statePath == "/" || strings.HasSuffix(r.URL.Path, "/") {
+        http.Error(w, "An alias needs a state path", http.StatusBadRequest)
+        return
+    }
+    mode := Redirect
+    if value := r.URL.Query().Get("mode"); value != "" {
+        var err error
+        if mode, err = ParseMode(value); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+    target := r.URL.Query().Get("target")
+    if target == "" || clean(target) == "/" || clean(target) == statePath {
+        http.Error(w, "A target path other than the alias is required in target", http.StatusBadRequest)
+        return
+    }
+    if _, err := os.Stat(stateFile(dataDir, statePath)); err == nil {
+        http.Error(w, "A state is stored at "+strings.TrimPrefix(statePath, "/")+", the alias would never apply", http.StatusConflict)
+        return
+    }
+    _, resolved, err := Resolve(dataDir, target)
+    if err == nil {
+        _, err = os.Stat(stateFile(dataDir, resolved))
+    }
+    if err != nil {
+        http.Error(w, "Target "+strings.TrimPrefix(clean(target), "/")+" doesn't lead to a state", http.StatusBadRequest)
+        return
+    }
+
+    alias := Alias{Path: statePath, Target: target, Mode: mode, Created: time.Now(), Operator: auth.ClientIP(r)}
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+        alias.Operator = principal.Username
+    }
+    if err := Write(dataDir, alias); err != nil {
+        utils.HTTPError(w, r, "Error writing alias", err)
+        return
+    }
+    w.WriteHeader(http.StatusOK)
+    slog.InfoContext(r.Context(), "Alias written", "path", statePath, "target", alias.Target, "mode", mode)
+}
@@ -0,0 +1,152 @@
+package aliases
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/tenants"
+)
+
+func writeState(t *testing.T, dataDir, statePath string) {
+    t.Helper()
+    file := stateFile(dataDir, statePath)
+    os.MkdirAll(filepath.Dir(file), 0755)
+    if err := os.WriteFile(file, []byte(`{"serial": 1}`), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+}
+
+func TestResolve(t *testing.T) {
+    tempDir := t.TempDir()
+    writeState(t, tempDir, "/prod/c")
+    writeState(t, tempDir, "/prod/shadowed")
+    now := time.Now()
+    for _, alias := range []Alias{
+        {Path: "/prod/a", Target: "/prod/b", Mode: Forward, Created: now},
+        {Path: "/prod/b", Target: "/prod/c", Mode: Redirect, Created: now},
+        {Path: "/prod/shadowed", Target: "/prod/c", Mode: Redirect, Created: now},
+        {Path: "/loop/a", Target: "/loop/b", Mode: Redirect, Created: now},
+        {Path: "/loop/b", Target: "/loop/a", Mode: Redirect, Created: now},
+    } {
+        if err := Write(tempDir, alias); err != nil {
+            t.Fatalf("Write failed: %v", err)
+        }
+    }
+
+    tests := []struct {
+        path      string
+        expected  string
+        firstPath string
+    }{
+        {"/prod/a", "/prod/c", "/prod/a"},
+        {"/prod/a/outputs/url", "/prod/c/outputs/url", "/prod/a"},
+        {"/prod/b", "/prod/c", "/prod/b"},
+        {"/prod/c", "/prod/c", ""},
+        {"/prod/shadowed", "/prod/shadowed", ""},
+        {"/prod/none", "/prod/none", ""},
+    }
+    for _, test := range tests {
+        alias, target, err := Resolve(tempDir, test.path)
+        if err != nil {
+            t.Errorf("Resolve(%q) failed: %v", test.path, err)
+            continue
+        }
+        var firstPath string
+        if alias != nil {
+            firstPath = alias.Path
+        }
+        if target != test.expected || firstPath != test.firstPath {
+            t.Errorf("Resolve(%q) = %q, %q; want %q, %q", test.path, firstPath, target, test.firstPath, test.expected)
+        }
+    }
+    if _, _, err := Resolve(tempDir, "/loop/a"); err != ErrLoop {
+        t.Errorf("Resolve of a loop returned %v; want ErrLoop", err)
+    }
+}
+
+func TestHandleAliases(t *testing.T) {
+    tempDir := t.TempDir()
+    writeState(t, tempDir, "/prod/new")
+    writeState(t, tempDir, "/prod/other")
+    admin := auth.WithPrincipal(context.Background(), auth.Principal{Username: "root", Role: auth.RoleReadWrite, Admin: true})
+    user := auth.WithPrincipal(context.Background(), auth.Principal{Username: "ci", Role: auth.RoleReadWrite})
+
+    tests := []struct {
+        description    string
+        ctx            context.Context
+        method         string
+        path           string
+        expectedStatus int
+    }{
+        {"create", admin, http.MethodPut, "/aliases/prod/old?target=prod/new&mode=forward", http.StatusOK},
+        {"not an admin", user, http.MethodPut, "/aliases/prod/old2?target=prod/new", http.StatusForbidden},
+        {"invalid mode", admin, http.MethodPut, "/aliases/prod/old2?target=prod/new&mode=proxy", http.StatusBadRequest},
+        {"missing target", admin, http.MethodPut, "/aliases/prod/old2", http.StatusBadRequest},
+        {"target without a state", admin, http.MethodPut, "/aliases/prod/old2?target=prod/none", http.StatusBadRequest},
+        {"over a stored state", admin, http.MethodPut, "/aliases/prod/other?target=prod/new", http.StatusConflict},
+        {"read", user, http.MethodGet, "/aliases/prod/old", http.StatusOK},
+        {"read missing", user, http.MethodGet, "/aliases/prod/old2", http.StatusNotFound},
+        {"list", user, http.MethodGet, "/aliases/", http.StatusOK},
+        {"remove missing", admin, http.MethodDelete, "/aliases/prod/old2", http.StatusNotFound},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(test.method, test.path, nil).WithContext(test.ctx)
+        rr := httptest.NewRecorder()
+        HandleAliases(rr, req, tempDir)
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v (%s)", test.description, status, test.expectedStatus, rr.Body.String())
+        }
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/aliases/?prefix=prod/", nil)
+    rr := httptest.NewRecorder()
+    HandleAliases(rr, req, tempDir)
+    var listing struct {
+        Aliases []Alias `json:"aliases"`
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+        t.Fatalf("Failed to decode listing: %v", err)
+    }
+    if len(listing.Aliases) != 1 || listing.Aliases[0].Target != "/prod/new" || listing.Aliases[0].Mode != Forward || listing.Aliases[0].Operator != "root" {
+        t.Errorf("Listed aliases %+v; want the forward alias to prod/new by root", listing.Aliases)
+    }
+
+    req = httptest.NewRequest(http.MethodDelete, "/aliases/prod/old", nil)
+    rr = httptest.NewRecorder()
+    HandleAliases(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if alias, _ := Read(tempDir, "/prod/old"); alias != nil {
+        t.Errorf("Alias was not removed")
+    }
+}
+
+func TestApplyTenantRedirect(t *testing.T) {
+    tempDir := t.TempDir()
+    writeState(t, tempDir, "/prod/new")
+    Write(tempDir, Alias{Path: "/prod/old", Target: "/prod/new", Mode: Redirect, Created: time.Now()})
+
+    var location string
+    handler := http.NewServeMux()
+    handler.HandleFunc("/locks/", func(w http.ResponseWriter, r *http.Request) {
+        if _, handled := Apply(w, r, tempDir); !handled {
+            t.Errorf("Request for an alias was not redirected")
+        }
+        location = w.Header().Get("Location")
+    })
+    handler.HandleFunc("/tenants/", tenants.StripPrefix(handler))
+    rr := httptest.NewRecorder()
+    handler.ServeHTTP(rr, httptest.NewRequest("LOCK", "/tenants/payments/locks/prod/old", nil))
+
+    if rr.Code != http.StatusPermanentRedirect || location != "/tenants/payments/locks/prod/new" {
+        t.Errorf("Got %v to %q; want 308 to the tenant's new path", rr.Code, location)
+    }
+}
@@ -2,38 +2,48 @@ package auth
 
 import (
     "encoding/base64"
-    "log"
+    "log/slog"
+    "math"
+    "net"
     "net/http"
     "os"
+    "strconv"
     "strings"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/logging"
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/tracing"
 )
 
-var authEnabled bool
-var authUsername string
-var authPassword string
+var failures = newThrottle(5, time.Second, 15*time.Minute, time.Now)
 
 // Initialize sets up authentication based on environment variables
 func Initialize() {
-    authUsername = os.Getenv("AUTH_USERNAME")
-    authPassword = os.Getenv("AUTH_PASSWORD")
-    if authUsername != "" && authPassword != "" {
-        authEnabled = true
-        log.Println("Basic authentication enabled")
-    } else {
-        authEnabled = false
-        log.Println("Warning: Basic authentication is disabled")
+    if err := Reload(); err != nil {
+        slog.Error("Failed to load authentication settings", "error", err)
+        os.Exit(1)
     }
+    failures = newThrottle(
+        config.GetEnvInt("AUTH_MAX_FAILURES", 5),
+        config.GetEnvDuration("AUTH_LOCKOUT_BASE", time.Second),
+        config.GetEnvDuration("AUTH_LOCKOUT_MAX", 15*time.Minute),
+        time.Now,
+    )
 }
 
-// WithAuth is a middleware that provides HTTP Basic Authentication
+// WithAuth is a middleware that provides HTTP Basic Authentication, or trusts
+// identity headers set by an authenticating reverse proxy, and scopes the
+// request to the tenant the principal belongs to
 func WithAuth(next http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
-        if authEnabled {
-            authHeader := r.Header.Get("Authorization")
-            if authHeader == "" || !checkAuth(authHeader) {
-                w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-                http.Error(w, "Unauthorized", http.StatusUnauthorized)
-								log.Println("Unauthorized: " + r.URL.Path)
+        current := active()
+        if current.enabled {
+            var ok bool
+            if r, ok = current.authorize(w, r); !ok {
                 return
             }
         }
@@ -41,20 +51,134 @@ func WithAuth(next http.HandlerFunc) http.HandlerFunc {
     }
 }
 
+// authorize authenticates and authorizes the request, returning it with the
+// principal and tenant in its context, or writes the error response
+func (s *settings) authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+    _, span := tracing.Start(r.Context(), "auth")
+    defer span.End()
+    requested, ok := s.requestedTenant(r)
+    if !ok {
+        span.SetAttributes(attribute.String("auth.result", "unknown_tenant"))
+        http.NotFound(w, r)
+        return r, false
+    }
+    var principal Principal
+    if s.proxy.carriesIdentity(r) {
+        if principal, ok = s.proxy.authenticate(r); !ok {
+            span.SetAttributes(attribute.String("auth.result", "untrusted_proxy"))
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            slog.WarnContext(r.Context(), "Rejected proxy identity headers from untrusted source", "remote", r.RemoteAddr, "path", r.URL.Path)
+            return r, false
+        }
+    } else if principal, ok = basicAuth(w, r, s, requested); !ok {
+        span.SetAttributes(attribute.String("auth.result", "unauthenticated"))
+        return r, false
+    }
+    logging.SetPrincipal(r.Context(), principal.Username)
+    span.SetAttributes(attribute.String("auth.principal", principal.Username))
+    tenant, ok := s.scope(principal, requested)
+    if !ok || !authorized(principal, r) {
+        span.SetAttributes(attribute.String("auth.result", "forbidden"))
+        http.Error(w, "Forbidden", http.StatusForbidden)
+        slog.WarnContext(r.Context(), "Forbidden", "method", r.Method, "path", r.URL.Path, "principal", principal.Username)
+        return r, false
+    }
+    span.SetAttributes(attribute.String("auth.result", "ok"))
+    ctx := WithPrincipal(r.Context(), principal)
+    if tenant != nil {
+        ctx = tenants.WithTenant(ctx, tenant)
+        span.SetAttributes(attribute.String("auth.tenant", tenant.Name))
+    }
+    return r.WithContext(ctx), true
+}
+
+// basicAuth authenticates the request's Basic credentials, writing the error response when it fails
+func basicAuth(w http.ResponseWriter, r *http.Request, current *settings, requested *tenants.Tenant) (Principal, bool) {
+    authHeader := r.Header.Get("Authorization")
+    username, _, _ := basicCredentials(authHeader)
+    keys := throttleKeys(r, username)
+    if wait := failures.retryAfter(keys...); wait > 0 {
+        tooManyAttempts(w, r, wait)
+        return Principal{}, false
+    }
+    principal, ok := current.authenticate(authHeader, requested)
+    if !ok {
+        if authHeader != "" {
+            failureCount.Add(1)
+            failures.fail(keys...)
+        }
+        w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        slog.WarnContext(r.Context(), "Unauthorized", "path", r.URL.Path)
+        return Principal{}, false
+    }
+    // Only the username is forgiven, clearing the client IP too would let a
+    // caller with one valid credential reset its backoff between guesses
+    failures.succeed("user:" + username)
+    return principal, true
+}
+
 func checkAuth(authHeader string) bool {
+    _, ok := active().authenticate(authHeader, nil)
+    return ok
+}
+
+func basicCredentials(authHeader string) (string, string, bool) {
     const prefix = "Basic "
     if !strings.HasPrefix(authHeader, prefix) {
-        return false
+        return "", "", false
     }
     authEncoded := strings.TrimPrefix(authHeader, prefix)
     authDecodedBytes, err := base64.StdEncoding.DecodeString(authEncoded)
     if err != nil {
-        return false
+        return "", "", false
     }
     authDecoded := string(authDecodedBytes)
     authPair := strings.SplitN(authDecoded, ":", 2)
     if len(authPair) != 2 {
-        return false
+        return "", "", false
     }
-    return authPair[0] == authUsername && authPair[1] == authPassword
-}
\ No newline at end of file
+    return authPair[0], authPair[1], true
+}
+
+// throttleKeys returns the keys failed attempts are tracked under: the client IP and, when given, the username
+func throttleKeys(r *http.Request, username string) []string {
+    keys := []string{"ip:" + ClientIP(r)}
+    if username != "" {
+        keys = append(keys, "user:"+username)
+    }
+    return keys
+}
+
+// ClientIP returns the address of the client, looking through X-Forwarded-For
+// when the request came from a trusted proxy
+func ClientIP(r *http.Request) string {
+    host := remoteHost(r)
+    proxy := active().proxy
+    if !proxy.trusted(host) {
+        return host
+    }
+    forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+    for i := len(forwarded) - 1; i >= 0; i-- {
+        hop := strings.TrimSpace(forwarded[i])
+        if hop != "" && !proxy.trusted(hop) {
+            return hop
+        }
+    }
+    return host
+}
+
+func remoteHost(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+func tooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+    throttledCount.Add(1)
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+    http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+    slog.WarnContext(r.Context(), "Throttled authentication attempt", "client", ClientIP(r), "path", r.URL.Path)
+}
@@ -18,10 +18,10 @@ func TestInitializeAuthEnabled(t *testing.T) {
 
     Initialize()
 
-    if !authEnabled {
+    if !active().enabled {
         t.Errorf("Expected authEnabled to be true, got false")
     }
-    if authUsername != "testuser" || authPassword != "testpass" {
+    if active().username != "testuser" || active().password != "testpass" {
         t.Errorf("Authentication credentials not set correctly")
     }
 }
@@ -32,15 +32,13 @@ func TestInitializeAuthDisabled(t *testing.T) {
 
     Initialize()
 
-    if authEnabled {
+    if active().enabled {
         t.Errorf("Expected authEnabled to be false, got true")
     }
 }
 
 func TestWithAuthAuthorized(t *testing.T) {
-    authEnabled = true
-    authUsername = "testuser"
-    authPassword = "testpass"
+    current.Store(&settings{enabled: true, username: "testuser", password: "testpass"})
 
     handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusOK)
@@ -60,9 +58,7 @@ func TestWithAuthAuthorized(t *testing.T) {
 }
 
 func TestWithAuthUnauthorized(t *testing.T) {
-    authEnabled = true
-    authUsername = "testuser"
-    authPassword = "testpass"
+    current.Store(&settings{enabled: true, username: "testuser", password: "testpass"})
 
     handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusOK)
@@ -103,7 +99,7 @@ func TestWithAuthUnauthorized(t *testing.T) {
 }
 
 func TestWithAuthDisabled(t *testing.T) {
-    authEnabled = false
+    current.Store(&settings{enabled: false})
 
     handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusOK)
@@ -121,8 +117,7 @@ func TestWithAuthDisabled(t *testing.T) {
 }
 
 func TestCheckAuth(t *testing.T) {
-    authUsername = "testuser"
-    authPassword = "testpass"
+    current.Store(&settings{enabled: true, username: "testuser", password: "testpass"})
 
     validAuthHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser:testpass"))
 
@@ -147,4 +142,64 @@ func TestCheckAuth(t *testing.T) {
     if checkAuth(noPrefixAuthHeader) {
         t.Errorf("checkAuth passed with missing 'Basic ' prefix")
     }
-}
\ No newline at end of file
+}
+
+func TestWithAuthReadOnly(t *testing.T) {
+    current.Store(&settings{
+        enabled:             true,
+        username:            "testuser",
+        password:            "testpass",
+        readOnlyUsername:    "reader",
+        readOnlyPassword:    "readpass",
+        readOnlyOutputsOnly: true,
+    })
+
+    var principal Principal
+    handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        principal, _ = PrincipalFrom(r.Context())
+        w.WriteHeader(http.StatusOK)
+    })
+
+    tests := []struct {
+        method         string
+        path           string
+        expectedStatus int
+    }{
+        {http.MethodGet, "/states/test", http.StatusOK},
+        {http.MethodPost, "/states/test", http.StatusForbidden},
+        {http.MethodDelete, "/states/test", http.StatusForbidden},
+        {"LOCK", "/locks/test", http.StatusForbidden},
+        {"UNLOCK", "/locks/test", http.StatusForbidden},
+        {http.MethodGet, "/search", http.StatusOK},
+        {http.MethodPost, "/search", http.StatusForbidden},
+    }
+
+    for _, test := range tests {
+        req := httptest.NewRequest(test.method, test.path, nil)
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("reader:readpass")))
+        rr := httptest.NewRecorder()
+
+        handler.ServeHTTP(rr, req)
+
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("Handler returned wrong status code for read-only %s %s: got %v want %v", test.method, test.path, status, test.expectedStatus)
+        }
+    }
+
+    if principal.Role != RoleReadOnly || !principal.OutputsOnly {
+        t.Errorf("Unexpected principal for read-only credentials: %+v", principal)
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/states/test", nil)
+    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("testuser:testpass")))
+    rr := httptest.NewRecorder()
+
+    handler.ServeHTTP(rr, req)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code for read-write POST: got %v want %v", status, http.StatusOK)
+    }
+    if principal.Role != RoleReadWrite {
+        t.Errorf("Unexpected principal for read-write credentials: %+v", principal)
+    }
+}
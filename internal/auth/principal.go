@@ -0,0 +1,63 @@
+package auth
+
+import (
+    "context"
+    "net/http"
+    "strings"
+)
+
+// Role determines which requests an authenticated principal may make
+type Role string
+
+const (
+    // RoleReadWrite may read, write, delete and lock states
+    RoleReadWrite Role = "readwrite"
+    // RoleReadOnly may only read states, e.g. for terraform_remote_state consumers
+    RoleReadOnly Role = "readonly"
+)
+
+// Principal is the identity a request was authenticated as
+type Principal struct {
+    Username string
+    Role     Role
+    // Tenant is the tenant the credentials belong to, empty for global credentials
+    Tenant string
+    // Admin may use administrative operations, and for global credentials enter any tenant
+    Admin bool
+    // OutputsOnly strips every non-output section from states served to this principal
+    OutputsOnly bool
+    // ReadSensitive may read sensitive output values and resource attributes
+    ReadSensitive bool
+}
+
+// CanReadSensitive reports whether the request may read sensitive values,
+// which takes an explicit read-sensitive permission
+func CanReadSensitive(r *http.Request) bool {
+    principal, ok := PrincipalFrom(r.Context())
+    return ok && principal.ReadSensitive
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+    return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal a request was authenticated as, if any
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+    principal, ok := ctx.Value(principalKey{}).(Principal)
+    return principal, ok
+}
+
+// authorized reports whether principal's role permits the request
+func authorized(principal Principal, r *http.Request) bool {
+    switch principal.Role {
+    case RoleReadWrite:
+        return true
+    case RoleReadOnly:
+        return r.Method == http.MethodGet && (strings.HasPrefix(r.URL.Path, "/states/") || r.URL.Path == "/search")
+    default:
+        return false
+    }
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+
+    "terraform-http-backend/internal/config"
+)
+
+// proxySettings configures trust in identity headers set by an authenticating
+// reverse proxy such as oauth2-proxy
+type proxySettings struct {
+    cidrs               []*net.IPNet
+    userHeader          string
+    groupsHeader        string
+    readWriteGroups     []string
+    readOnlyGroups      []string
+    readSensitiveGroups []string
+}
+
+func loadProxySettings() (proxySettings, error) {
+    proxy := proxySettings{
+        userHeader:          config.GetEnv("AUTH_PROXY_USER_HEADER", "X-Forwarded-User"),
+        groupsHeader:        config.GetEnv("AUTH_PROXY_GROUPS_HEADER", "X-Forwarded-Groups"),
+        readWriteGroups:     splitList(config.GetEnv("AUTH_PROXY_READWRITE_GROUPS", "")),
+        readOnlyGroups:      splitList(config.GetEnv("AUTH_PROXY_READONLY_GROUPS", "")),
+        readSensitiveGroups: splitList(config.GetEnv("AUTH_PROXY_READ_SENSITIVE_GROUPS", "")),
+    }
+    for _, cidr := range splitList(config.GetEnv("AUTH_PROXY_CIDRS", "")) {
+        if !strings.Contains(cidr, "/") {
+            if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+                cidr += "/32"
+            } else {
+                cidr += "/128"
+            }
+        }
+        _, network, err := net.ParseCIDR(cidr)
+        if err != nil {
+            return proxySettings{}, fmt.Errorf("invalid AUTH_PROXY_CIDRS entry: %w", err)
+        }
+        proxy.cidrs = append(proxy.cidrs, network)
+    }
+    return proxy, nil
+}
+
+func (p proxySettings) enabled() bool {
+    return len(p.cidrs) > 0
+}
+
+// trusted reports whether host is within one of the configured proxy CIDRs
+func (p proxySettings) trusted(host string) bool {
+    ip := net.ParseIP(host)
+    if ip == nil {
+        return false
+    }
+    for _, network := range p.cidrs {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// carriesIdentity reports whether proxy authentication is enabled and the
+// request carries a proxy identity header
+func (p proxySettings) carriesIdentity(r *http.Request) bool {
+    return p.enabled() && (r.Header.Get(p.userHeader) != "" || r.Header.Get(p.groupsHeader) != "")
+}
+
+// authenticate resolves the principal from the identity headers, provided the
+// request came directly from a trusted proxy. Read-only membership wins over
+// read-write, and every user gets read-write only when no groups are
+// configured at all. Users in none of the configured groups get a principal
+// without a role, which authorization then rejects.
+func (p proxySettings) authenticate(r *http.Request) (Principal, bool) {
+    username := r.Header.Get(p.userHeader)
+    if username == "" || !p.trusted(remoteHost(r)) {
+        return Principal{}, false
+    }
+    groups := splitList(r.Header.Get(p.groupsHeader))
+    principal := Principal{Username: username, ReadSensitive: memberOf(groups, p.readSensitiveGroups)}
+    switch {
+    case memberOf(groups, p.readOnlyGroups):
+        principal.Role = RoleReadOnly
+    case memberOf(groups, p.readWriteGroups), len(p.readWriteGroups) == 0 && len(p.readOnlyGroups) == 0:
+        principal.Role = RoleReadWrite
+    }
+    return principal, true
+}
+
+func memberOf(groups, allowed []string) bool {
+    for _, group := range groups {
+        for _, candidate := range allowed {
+            if group == candidate {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func splitList(value string) []string {
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+)
+
+func TestWithAuthProxyHeaders(t *testing.T) {
+    os.Setenv("AUTH_PROXY_CIDRS", "10.0.0.0/8, 192.168.1.1")
+    os.Setenv("AUTH_PROXY_READWRITE_GROUPS", "platform")
+    os.Setenv("AUTH_PROXY_READONLY_GROUPS", "developers")
+    defer func() {
+        os.Unsetenv("AUTH_PROXY_CIDRS")
+        os.Unsetenv("AUTH_PROXY_READWRITE_GROUPS")
+        os.Unsetenv("AUTH_PROXY_READONLY_GROUPS")
+    }()
+    if err := Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+    defer current.Store(&settings{})
+
+    var principal Principal
+    handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        principal, _ = PrincipalFrom(r.Context())
+        w.WriteHeader(http.StatusOK)
+    })
+
+    tests := []struct {
+        description    string
+        remoteAddr     string
+        method         string
+        user           string
+        groups         string
+        expectedStatus int
+        expectedRole   Role
+    }{
+        {"read-write group", "10.1.2.3:1234", http.MethodPost, "alice", "platform", http.StatusOK, RoleReadWrite},
+        {"read-only group reading", "192.168.1.1:1234", http.MethodGet, "bob", "developers", http.StatusOK, RoleReadOnly},
+        {"read-only group writing", "10.1.2.3:1234", http.MethodPost, "bob", "developers", http.StatusForbidden, ""},
+        {"no matching group", "10.1.2.3:1234", http.MethodGet, "carol", "finance", http.StatusForbidden, ""},
+        {"untrusted source", "172.16.0.1:1234", http.MethodGet, "alice", "platform", http.StatusForbidden, ""},
+        {"untrusted source groups only", "172.16.0.1:1234", http.MethodGet, "", "platform", http.StatusForbidden, ""},
+        {"no headers", "10.1.2.3:1234", http.MethodGet, "", "", http.StatusUnauthorized, ""},
+    }
+
+    for _, test := range tests {
+        t.Run(test.description, func(t *testing.T) {
+            principal = Principal{}
+            req := httptest.NewRequest(test.method, "/states/test", nil)
+            req.RemoteAddr = test.remoteAddr
+            if test.user != "" {
+                req.Header.Set("X-Forwarded-User", test.user)
+            }
+            if test.groups != "" {
+                req.Header.Set("X-Forwarded-Groups", test.groups)
+            }
+            rr := httptest.NewRecorder()
+
+            handler.ServeHTTP(rr, req)
+
+            if status := rr.Code; status != test.expectedStatus {
+                t.Errorf("Handler returned wrong status code: got %v want %v", status, test.expectedStatus)
+            }
+            if principal.Role != test.expectedRole {
+                t.Errorf("Handler saw wrong role: got %q want %q", principal.Role, test.expectedRole)
+            }
+            if test.expectedStatus == http.StatusOK && principal.Username != test.user {
+                t.Errorf("Handler saw wrong username: got %q want %q", principal.Username, test.user)
+            }
+        })
+    }
+}
+
+func TestProxyRoles(t *testing.T) {
+    os.Setenv("AUTH_PROXY_CIDRS", "10.0.0.0/8")
+    defer os.Unsetenv("AUTH_PROXY_CIDRS")
+
+    tests := []struct {
+        description     string
+        readWriteGroups string
+        readOnlyGroups  string
+        groups          string
+        expectedRole    Role
+    }{
+        {"no groups configured", "", "", "anyone", RoleReadWrite},
+        {"only read-only groups, member", "", "developers", "developers", RoleReadOnly},
+        {"only read-only groups, other user", "", "developers", "finance", ""},
+        {"member of both", "platform", "developers", "platform,developers", RoleReadOnly},
+        {"read-write member", "platform", "developers", "platform", RoleReadWrite},
+    }
+    for _, test := range tests {
+        os.Setenv("AUTH_PROXY_READWRITE_GROUPS", test.readWriteGroups)
+        os.Setenv("AUTH_PROXY_READONLY_GROUPS", test.readOnlyGroups)
+        proxy, err := loadProxySettings()
+        if err != nil {
+            t.Fatalf("loadProxySettings failed: %v", err)
+        }
+        req := httptest.NewRequest(http.MethodGet, "/states/test", nil)
+        req.RemoteAddr = "10.0.0.5:1234"
+        req.Header.Set("X-Forwarded-User", "alice")
+        req.Header.Set("X-Forwarded-Groups", test.groups)
+        if principal, _ := proxy.authenticate(req); principal.Role != test.expectedRole {
+            t.Errorf("%s: role = %q; want %q", test.description, principal.Role, test.expectedRole)
+        }
+    }
+    os.Unsetenv("AUTH_PROXY_READWRITE_GROUPS")
+    os.Unsetenv("AUTH_PROXY_READONLY_GROUPS")
+}
+
+func TestClientIPThroughTrustedProxy(t *testing.T) {
+    os.Setenv("AUTH_PROXY_CIDRS", "10.0.0.0/8")
+    defer os.Unsetenv("AUTH_PROXY_CIDRS")
+    proxy, err := loadProxySettings()
+    if err != nil {
+        t.Fatalf("loadProxySettings failed: %v", err)
+    }
+    current.Store(&settings{enabled: true, proxy: proxy})
+    defer current.Store(&settings{})
+
+    req := httptest.NewRequest(http.MethodGet, "/states/test", nil)
+    req.RemoteAddr = "10.0.0.5:1234"
+    req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.9")
+    if ip := ClientIP(req); ip != "198.51.100.7" {
+        t.Errorf("clientIP through trusted proxy = %q; want %q", ip, "198.51.100.7")
+    }
+
+    req.RemoteAddr = "203.0.113.1:1234"
+    if ip := ClientIP(req); ip != "203.0.113.1" {
+        t.Errorf("clientIP from untrusted source = %q; want %q", ip, "203.0.113.1")
+    }
+}
+
+func TestLoadProxySettingsInvalidCIDR(t *testing.T) {
+    os.Setenv("AUTH_PROXY_CIDRS", "10.0.0.0/99")
+    defer os.Unsetenv("AUTH_PROXY_CIDRS")
+
+    if _, err := loadProxySettings(); err == nil {
+        t.Errorf("loadProxySettings accepted an invalid CIDR")
+    }
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/tenants"
+)
+
+// settings is an immutable snapshot of the authentication configuration.
+// Reloads swap in a new snapshot, so in-flight requests keep the one they started with.
+type settings struct {
+    enabled             bool
+    username            string
+    password            string
+    readOnlyUsername    string
+    readOnlyPassword    string
+    readOnlyOutputsOnly bool
+    readSensitiveUsers  []string
+    proxy               proxySettings
+    tenants             *tenants.Registry
+}
+
+var current atomic.Pointer[settings]
+
+// secretKeys are the settings that may be read from a file named by <key>_FILE
+var secretKeys = []string{"AUTH_USERNAME", "AUTH_PASSWORD", "AUTH_READONLY_USERNAME", "AUTH_READONLY_PASSWORD"}
+
+// policyFiles are the environment variables naming files that are re-read on change
+var policyFiles = []string{"CONFIG_FILE", "TENANTS_FILE"}
+
+func active() *settings {
+    if s := current.Load(); s != nil {
+        return s
+    }
+    return &settings{}
+}
+
+// Reload re-reads the configuration file and the authentication settings and
+// swaps them in. On error the previous settings stay active.
+func Reload() error {
+    // Credentials may be set in the configuration file rather than the environment
+    if err := config.Load(config.GetEnv("CONFIG_FILE", "")); err != nil {
+        return err
+    }
+    s, err := loadSettings()
+    if err != nil {
+        return err
+    }
+    current.Store(s)
+    if s.readOnlyUsername != "" {
+        slog.Info("Read-only credentials enabled")
+    }
+    if s.tenants.Len() > 0 {
+        slog.Info("Loaded tenants", "count", s.tenants.Len())
+    }
+    if s.proxy.enabled() {
+        slog.Info("Trusting identity headers from proxies", "header", s.proxy.userHeader, "cidrs", len(s.proxy.cidrs))
+    }
+    if s.username != "" {
+        slog.Info("Basic authentication enabled")
+    } else if s.readOnlyUsername != "" {
+        slog.Info("Basic authentication enabled for read-only credentials only")
+    } else if !s.enabled {
+        slog.Warn("Basic authentication is disabled")
+    }
+    return nil
+}
+
+func loadSettings() (*settings, error) {
+    values := make(map[string]string)
+    for _, key := range secretKeys {
+        value, err := config.GetEnvOrFile(key, "")
+        if err != nil {
+            return nil, err
+        }
+        values[key] = value
+    }
+    s := &settings{
+        readOnlyOutputsOnly: config.GetEnv("AUTH_READONLY_OUTPUTS_ONLY", "false") == "true",
+        readSensitiveUsers:  splitList(config.GetEnv("AUTH_READ_SENSITIVE_USERS", "")),
+    }
+    if values["AUTH_USERNAME"] != "" && values["AUTH_PASSWORD"] != "" {
+        s.username, s.password = values["AUTH_USERNAME"], values["AUTH_PASSWORD"]
+    }
+    if values["AUTH_READONLY_USERNAME"] != "" && values["AUTH_READONLY_PASSWORD"] != "" {
+        s.readOnlyUsername, s.readOnlyPassword = values["AUTH_READONLY_USERNAME"], values["AUTH_READONLY_PASSWORD"]
+    }
+    proxy, err := loadProxySettings()
+    if err != nil {
+        return nil, err
+    }
+    s.proxy = proxy
+    if file := config.GetEnv("TENANTS_FILE", ""); file != "" {
+        if s.tenants, err = tenants.Load(file); err != nil {
+            return nil, err
+        }
+    }
+    s.enabled = s.username != "" || s.readOnlyUsername != "" || proxy.enabled() || s.tenants.Len() > 0
+    return s, nil
+}
+
+// authenticate resolves the principal for the credentials in authHeader. A
+// "<tenant>/<username>" username authenticates against that tenant's users, a
+// plain username against the requested tenant's users and then the global credentials.
+func (s *settings) authenticate(authHeader string, requested *tenants.Tenant) (Principal, bool) {
+    username, password, ok := basicCredentials(authHeader)
+    if !ok {
+        return Principal{}, false
+    }
+    if name, tenantUsername, found := strings.Cut(username, "/"); found {
+        tenant, ok := s.tenants.Lookup(name)
+        if !ok {
+            return Principal{}, false
+        }
+        return tenantPrincipal(tenant, tenantUsername, password)
+    }
+    if requested != nil {
+        if principal, ok := tenantPrincipal(requested, username, password); ok {
+            return principal, true
+        }
+    }
+    readSensitive := memberOf([]string{username}, s.readSensitiveUsers)
+    if s.username != "" && username == s.username && password == s.password {
+        return Principal{Username: username, Role: RoleReadWrite, Admin: true, ReadSensitive: readSensitive}, true
+    }
+    if s.readOnlyUsername != "" && username == s.readOnlyUsername && password == s.readOnlyPassword {
+        return Principal{Username: username, Role: RoleReadOnly, OutputsOnly: s.readOnlyOutputsOnly, ReadSensitive: readSensitive}, true
+    }
+    return Principal{}, false
+}
+
+func tenantPrincipal(tenant *tenants.Tenant, username, password string) (Principal, bool) {
+    user, ok := tenant.User(username)
+    if !ok || user.Password == "" || user.Password != password {
+        return Principal{}, false
+    }
+    role := RoleReadWrite
+    if user.Role == string(RoleReadOnly) {
+        role = RoleReadOnly
+    }
+    return Principal{
+        Username:      username,
+        Role:          role,
+        Tenant:        tenant.Name,
+        Admin:         tenant.IsAdmin(username),
+        ReadSensitive: user.Has(tenants.PermissionReadSensitive),
+    }, true
+}
+
+// requestedTenant returns the tenant named by the request's path prefix or
+// host name, or false when the path names a tenant that doesn't exist
+func (s *settings) requestedTenant(r *http.Request) (*tenants.Tenant, bool) {
+    if name := tenants.Requested(r); name != "" {
+        return s.tenants.Lookup(name)
+    }
+    tenant, _ := s.tenants.ForHost(r)
+    return tenant, true
+}
+
+// scope returns the tenant the principal's request is confined to. Tenant
+// principals never leave their own tenant, and only global admins may enter one.
+func (s *settings) scope(principal Principal, requested *tenants.Tenant) (*tenants.Tenant, bool) {
+    if principal.Tenant == "" {
+        return requested, requested == nil || principal.Admin
+    }
+    tenant, ok := s.tenants.Lookup(principal.Tenant)
+    if !ok || (requested != nil && requested.Name != tenant.Name) {
+        return nil, false
+    }
+    return tenant, true
+}
+
+// Watch reloads the settings whenever a file named by one of the <key>_FILE
+// variables, the configuration file or the tenants file changes, checking
+// every interval until ctx is done
+func Watch(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    last := watchedFiles()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            latest := watchedFiles()
+            if latest == last {
+                continue
+            }
+            last = latest
+            slog.Info("Authentication files changed, reloading")
+            if err := Reload(); err != nil {
+                slog.Error("Failed to reload authentication settings", "error", err)
+            }
+        }
+    }
+}
+
+// watchedFiles returns a fingerprint of the modification times of every secret and policy file in use
+func watchedFiles() string {
+    var fingerprint string
+    var files []string
+    for _, key := range secretKeys {
+        files = append(files, config.GetEnv(key+"_FILE", ""))
+    }
+    for _, key := range policyFiles {
+        files = append(files, config.GetEnv(key, ""))
+    }
+    for _, file := range files {
+        if file == "" {
+            continue
+        }
+        fingerprint += file + "@"
+        if info, err := os.Stat(file); err == nil {
+            fingerprint += info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+        }
+        fingerprint += ";"
+    }
+    return fingerprint
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+    "context"
+    "encoding/base64"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "terraform-http-backend/internal/config"
+)
+
+func TestReloadFromFiles(t *testing.T) {
+    dir := t.TempDir()
+    usernameFile := filepath.Join(dir, "username")
+    passwordFile := filepath.Join(dir, "password")
+    if err := os.WriteFile(usernameFile, []byte("fileuser\n"), 0600); err != nil {
+        t.Fatalf("Failed to write username file: %v", err)
+    }
+    if err := os.WriteFile(passwordFile, []byte("filepass\n"), 0600); err != nil {
+        t.Fatalf("Failed to write password file: %v", err)
+    }
+    os.Setenv("AUTH_USERNAME_FILE", usernameFile)
+    os.Setenv("AUTH_PASSWORD_FILE", passwordFile)
+    defer func() {
+        os.Unsetenv("AUTH_USERNAME_FILE")
+        os.Unsetenv("AUTH_PASSWORD_FILE")
+    }()
+
+    if err := Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+    if !checkAuth("Basic " + base64.StdEncoding.EncodeToString([]byte("fileuser:filepass"))) {
+        t.Errorf("checkAuth failed with credentials from files")
+    }
+
+    // a broken file keeps the previous settings active
+    os.Setenv("AUTH_PASSWORD_FILE", filepath.Join(dir, "missing"))
+    if err := Reload(); err == nil {
+        t.Errorf("Reload succeeded with a missing password file")
+    }
+    if !checkAuth("Basic " + base64.StdEncoding.EncodeToString([]byte("fileuser:filepass"))) {
+        t.Errorf("checkAuth failed with previous credentials after a failed reload")
+    }
+}
+
+func TestWatchReloadsChangedFiles(t *testing.T) {
+    dir := t.TempDir()
+    passwordFile := filepath.Join(dir, "password")
+    if err := os.WriteFile(passwordFile, []byte("oldpass"), 0600); err != nil {
+        t.Fatalf("Failed to write password file: %v", err)
+    }
+    os.Setenv("AUTH_USERNAME", "testuser")
+    os.Setenv("AUTH_PASSWORD_FILE", passwordFile)
+    defer func() {
+        os.Unsetenv("AUTH_USERNAME")
+        os.Unsetenv("AUTH_PASSWORD_FILE")
+    }()
+    if err := Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go Watch(ctx, 10*time.Millisecond)
+    time.Sleep(20 * time.Millisecond)
+
+    if err := os.WriteFile(passwordFile, []byte("rotatedpass"), 0600); err != nil {
+        t.Fatalf("Failed to rotate password file: %v", err)
+    }
+
+    rotated := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser:rotatedpass"))
+    deadline := time.Now().Add(2 * time.Second)
+    for !checkAuth(rotated) {
+        if time.Now().After(deadline) {
+            t.Fatalf("Rotated password was not picked up")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestReloadFromConfigFile(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(file, []byte("auth:\n  username: fileuser\n  password: oldpass\n"), 0600); err != nil {
+        t.Fatalf("Failed to write config file: %v", err)
+    }
+    t.Setenv("CONFIG_FILE", file)
+    defer config.Load("")
+    if err := Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+
+    // a rotated password in the configuration file is picked up
+    if err := os.WriteFile(file, []byte("auth:\n  username: fileuser\n  password: newpass\n"), 0600); err != nil {
+        t.Fatalf("Failed to rotate config file: %v", err)
+    }
+    if err := Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+    if !checkAuth("Basic " + base64.StdEncoding.EncodeToString([]byte("fileuser:newpass"))) {
+        t.Errorf("checkAuth failed with the rotated password from the config file")
+    }
+
+    // an invalid file keeps the previous settings active
+    if err := os.WriteFile(file, []byte("auth:\n  unknown: true\n"), 0600); err != nil {
+        t.Fatalf("Failed to write config file: %v", err)
+    }
+    if err := Reload(); err == nil {
+        t.Errorf("Reload succeeded with an invalid config file")
+    }
+    if !checkAuth("Basic " + base64.StdEncoding.EncodeToString([]byte("fileuser:newpass"))) {
+        t.Errorf("checkAuth failed with previous credentials after a failed reload")
+    }
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "terraform-http-backend/internal/tenants"
+)
+
+func TestWithAuthTenants(t *testing.T) {
+    registry, err := tenants.NewRegistry([]*tenants.Tenant{
+        {Name: "payments", Hosts: []string{"payments.example.com"}, Users: []tenants.User{{Username: "ci", Password: "paypass"}}},
+        {Name: "search", Users: []tenants.User{{Username: "ci", Password: "searchpass", Role: "readonly"}}},
+    })
+    if err != nil {
+        t.Fatalf("NewRegistry failed: %v", err)
+    }
+    current.Store(&settings{enabled: true, username: "admin", password: "adminpass", tenants: registry})
+    defer current.Store(&settings{})
+
+    var tenant string
+    var reached bool
+    plain := WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        reached = true
+        if t, ok := tenants.FromContext(r.Context()); ok {
+            tenant = t.Name
+        }
+        w.WriteHeader(http.StatusOK)
+    })
+    handler := tenants.StripPrefix(plain)
+
+    tests := []struct {
+        description    string
+        handler        http.Handler
+        path           string
+        host           string
+        credentials    string
+        expectedStatus int
+        expectedTenant string
+    }{
+        {"username prefix", plain, "/states/prod", "", "payments/ci:paypass", http.StatusOK, "payments"},
+        {"host name", plain, "/states/prod", "payments.example.com", "ci:paypass", http.StatusOK, "payments"},
+        {"path segment", handler, "/tenants/payments/states/prod", "", "ci:paypass", http.StatusOK, "payments"},
+        {"path segment with prefixed username", handler, "/tenants/payments/states/prod", "", "payments/ci:paypass", http.StatusOK, "payments"},
+        {"other tenant's path", handler, "/tenants/search/states/prod", "", "payments/ci:paypass", http.StatusForbidden, ""},
+        {"other tenant's host", plain, "/states/prod", "payments.example.com", "search/ci:searchpass", http.StatusForbidden, ""},
+        {"other tenant's password", handler, "/tenants/search/states/prod", "", "ci:paypass", http.StatusUnauthorized, ""},
+        {"unknown tenant", handler, "/tenants/unknown/states/prod", "", "admin:adminpass", http.StatusNotFound, ""},
+        {"global admin enters tenant", handler, "/tenants/search/states/prod", "", "admin:adminpass", http.StatusOK, "search"},
+        {"global admin global namespace", plain, "/states/prod", "", "admin:adminpass", http.StatusOK, ""},
+    }
+
+    for _, test := range tests {
+        t.Run(test.description, func(t *testing.T) {
+            reached, tenant = false, ""
+            req := httptest.NewRequest(http.MethodGet, test.path, nil)
+            if test.host != "" {
+                req.Host = test.host
+            }
+            req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(test.credentials)))
+            rr := httptest.NewRecorder()
+
+            test.handler.ServeHTTP(rr, req)
+
+            if status := rr.Code; status != test.expectedStatus {
+                t.Errorf("Handler returned wrong status code: got %v want %v", status, test.expectedStatus)
+            }
+            if reached != (test.expectedStatus == http.StatusOK) || tenant != test.expectedTenant {
+                t.Errorf("Handler reached %v with tenant %q; want tenant %q", reached, tenant, test.expectedTenant)
+            }
+        })
+    }
+}
+
+func TestReadSensitivePermission(t *testing.T) {
+    registry, err := tenants.NewRegistry([]*tenants.Tenant{
+        {Name: "payments", Users: []tenants.User{
+            {Username: "deploy", Password: "deploypass", Role: "readonly", Permissions: []string{tenants.PermissionReadSensitive}},
+            {Username: "ci", Password: "cipass"},
+        }},
+    })
+    if err != nil {
+        t.Fatalf("NewRegistry failed: %v", err)
+    }
+    current.Store(&settings{
+        enabled:            true,
+        username:           "admin",
+        password:           "adminpass",
+        readOnlyUsername:   "reader",
+        readOnlyPassword:   "readpass",
+        readSensitiveUsers: []string{"reader"},
+        tenants:            registry,
+    })
+    defer current.Store(&settings{})
+
+    var readSensitive bool
+    handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        readSensitive = CanReadSensitive(r)
+        w.WriteHeader(http.StatusOK)
+    })
+
+    tests := []struct {
+        credentials string
+        expected    bool
+    }{
+        {"admin:adminpass", false},
+        {"reader:readpass", true},
+        {"payments/deploy:deploypass", true},
+        {"payments/ci:cipass", false},
+    }
+    for _, test := range tests {
+        readSensitive = false
+        req := httptest.NewRequest(http.MethodGet, "/states/prod", nil)
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(test.credentials)))
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, req)
+        if status := rr.Code; status != http.StatusOK {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.credentials, status, http.StatusOK)
+        }
+        if readSensitive != test.expected {
+            t.Errorf("%s: CanReadSensitive = %v; want %v", test.credentials, readSensitive, test.expected)
+        }
+    }
+
+    _, err = tenants.NewRegistry([]*tenants.Tenant{
+        {Name: "payments", Users: []tenants.User{{Username: "ci", Password: "p", Permissions: []string{"read-everything"}}}},
+    })
+    if err == nil {
+        t.Errorf("NewRegistry accepted an unknown permission")
+    }
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// throttle tracks failed authentication attempts per key (client IP or
+// username) and locks a key out with exponential backoff once it passes
+// the allowed number of failures
+type throttle struct {
+    mu          sync.Mutex
+    now         func() time.Time
+    maxFailures int
+    baseDelay   time.Duration
+    maxDelay    time.Duration
+    entries     map[string]*failureEntry
+    lastPrune   time.Time
+}
+
+// pruneInterval spaces out sweeps for forgotten entries, so a burst of
+// failures doesn't scan every entry on each one
+const pruneInterval = time.Minute
+
+type failureEntry struct {
+    failures    int
+    lastFailure time.Time
+    lockedUntil time.Time
+}
+
+// FailureStats holds counters for rejected authentication attempts
+type FailureStats struct {
+    Failures  uint64
+    Throttled uint64
+}
+
+var failureCount atomic.Uint64
+var throttledCount atomic.Uint64
+
+// Failures returns the number of failed and throttled authentication attempts since startup
+func Failures() FailureStats {
+    return FailureStats{
+        Failures:  failureCount.Load(),
+        Throttled: throttledCount.Load(),
+    }
+}
+
+func newThrottle(maxFailures int, baseDelay, maxDelay time.Duration, now func() time.Time) *throttle {
+    return &throttle{
+        now:         now,
+        maxFailures: maxFailures,
+        baseDelay:   baseDelay,
+        maxDelay:    maxDelay,
+        entries:     make(map[string]*failureEntry),
+    }
+}
+
+// retryAfter returns how long the caller must wait before any of keys may attempt to authenticate again
+func (t *throttle) retryAfter(keys ...string) time.Duration {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    now := t.now()
+    var wait time.Duration
+    for _, key := range keys {
+        entry, ok := t.entries[key]
+        if !ok {
+            continue
+        }
+        if remaining := entry.lockedUntil.Sub(now); remaining > wait {
+            wait = remaining
+        }
+    }
+    return wait
+}
+
+// fail records a failed attempt against each key and extends its lockout once past maxFailures
+func (t *throttle) fail(keys ...string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    now := t.now()
+    if now.Sub(t.lastPrune) >= pruneInterval {
+        t.prune(now)
+        t.lastPrune = now
+    }
+    for _, key := range keys {
+        entry, ok := t.entries[key]
+        if !ok || now.Sub(entry.lastFailure) > t.maxDelay {
+            entry = &failureEntry{}
+            t.entries[key] = entry
+        }
+        entry.failures++
+        entry.lastFailure = now
+        if entry.failures >= t.maxFailures {
+            entry.lockedUntil = now.Add(t.delay(entry.failures - t.maxFailures))
+        }
+    }
+}
+
+// succeed clears the failure history for each key
+func (t *throttle) succeed(keys ...string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, key := range keys {
+        delete(t.entries, key)
+    }
+}
+
+func (t *throttle) delay(step int) time.Duration {
+    delay := t.baseDelay
+    for i := 0; i < step && delay < t.maxDelay; i++ {
+        delay *= 2
+    }
+    if delay > t.maxDelay {
+        return t.maxDelay
+    }
+    return delay
+}
+
+// prune drops entries that are no longer locked out and have had no recent failures
+func (t *throttle) prune(now time.Time) {
+    for key, entry := range t.entries {
+        if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > t.maxDelay {
+            delete(t.entries, key)
+        }
+    }
+}
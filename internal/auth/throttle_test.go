@@ -0,0 +1,143 @@
+package auth
+
+import (
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+type fakeClock struct {
+    current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+    return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+    c.current = c.current.Add(d)
+}
+
+func TestThrottleBackoff(t *testing.T) {
+    clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    th := newThrottle(3, time.Second, 10*time.Second, clock.now)
+
+    for i := 0; i < 2; i++ {
+        th.fail("ip:1.2.3.4")
+    }
+    if wait := th.retryAfter("ip:1.2.3.4"); wait != 0 {
+        t.Errorf("retryAfter below threshold = %s; want 0", wait)
+    }
+
+    expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+    for _, want := range expected {
+        th.fail("ip:1.2.3.4")
+        if wait := th.retryAfter("ip:1.2.3.4"); wait != want {
+            t.Errorf("retryAfter = %s; want %s", wait, want)
+        }
+    }
+
+    clock.advance(10 * time.Second)
+    if wait := th.retryAfter("ip:1.2.3.4"); wait != 0 {
+        t.Errorf("retryAfter after lockout expired = %s; want 0", wait)
+    }
+
+    th.succeed("ip:1.2.3.4")
+    th.fail("ip:1.2.3.4")
+    if wait := th.retryAfter("ip:1.2.3.4"); wait != 0 {
+        t.Errorf("retryAfter after success reset = %s; want 0", wait)
+    }
+}
+
+func TestThrottleForgetsOldFailures(t *testing.T) {
+    clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    th := newThrottle(2, time.Second, 10*time.Second, clock.now)
+
+    th.fail("user:admin")
+    clock.advance(11 * time.Second)
+    th.fail("user:admin")
+
+    if wait := th.retryAfter("user:admin"); wait != 0 {
+        t.Errorf("retryAfter with stale failure = %s; want 0", wait)
+    }
+}
+
+func TestThrottlePrunesPeriodically(t *testing.T) {
+    clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    th := newThrottle(3, time.Second, 10*time.Second, clock.now)
+
+    th.fail("user:a")
+    clock.advance(11 * time.Second)
+    th.fail("user:b")
+    if len(th.entries) != 2 {
+        t.Errorf("Entries between sweeps = %d; want 2", len(th.entries))
+    }
+    clock.advance(pruneInterval)
+    th.fail("user:c")
+    if _, ok := th.entries["user:c"]; len(th.entries) != 1 || !ok {
+        t.Errorf("Entries after a sweep = %v; want only user:c", th.entries)
+    }
+}
+
+func TestWithAuthThrottled(t *testing.T) {
+    clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    current.Store(&settings{enabled: true, username: "testuser", password: "testpass"})
+    failures = newThrottle(2, time.Minute, time.Hour, clock.now)
+    defer func() {
+        failures = newThrottle(5, time.Second, 15*time.Minute, time.Now)
+    }()
+
+    handler := WithAuth(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+
+    request := func(credentials, remoteAddr string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", "/", nil)
+        req.RemoteAddr = remoteAddr
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, req)
+        return rr
+    }
+
+    before := Failures()
+    for i := 0; i < 2; i++ {
+        if rr := request("testuser:wrongpass", "10.0.0.1:1234"); rr.Code != http.StatusUnauthorized {
+            t.Errorf("Handler returned wrong status code for bad password: got %v want %v", rr.Code, http.StatusUnauthorized)
+        }
+    }
+
+    rr := request("testuser:testpass", "10.0.0.1:1234")
+    if rr.Code != http.StatusTooManyRequests {
+        t.Errorf("Handler returned wrong status code while locked out: got %v want %v", rr.Code, http.StatusTooManyRequests)
+    }
+    if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "60" {
+        t.Errorf("Handler returned wrong Retry-After: got %q want %q", retryAfter, "60")
+    }
+
+    // the username is locked out from other addresses too
+    if rr := request("testuser:testpass", "10.0.0.2:1234"); rr.Code != http.StatusTooManyRequests {
+        t.Errorf("Handler returned wrong status code for locked username: got %v want %v", rr.Code, http.StatusTooManyRequests)
+    }
+
+    clock.advance(time.Minute)
+    if rr := request("testuser:testpass", "10.0.0.1:1234"); rr.Code != http.StatusOK {
+        t.Errorf("Handler returned wrong status code after lockout: got %v want %v", rr.Code, http.StatusOK)
+    }
+
+    // A good login forgives the username but not the client IP's failures
+    if wait := failures.retryAfter("user:testuser"); wait != 0 {
+        t.Errorf("Username still throttled after a good login: %s", wait)
+    }
+    request("otheruser:wrongpass", "10.0.0.1:1234")
+    if wait := failures.retryAfter("ip:10.0.0.1"); wait == 0 {
+        t.Errorf("Client IP's failures were cleared by a good login")
+    }
+
+    after := Failures()
+    if after.Failures-before.Failures != 3 || after.Throttled-before.Throttled != 2 {
+        t.Errorf("Unexpected failure counters: got %+v want 3 failures and 2 throttled", after)
+    }
+}
@@ -0,0 +1,102 @@
+package certs
+
+import (
+    "context"
+    "crypto/tls"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Certificate serves a TLS key pair that can be replaced while the server is running
+type Certificate struct {
+    certFile string
+    keyFile  string
+    current  atomic.Pointer[tls.Certificate]
+}
+
+// Load reads the key pair from certFile and keyFile
+func Load(certFile, keyFile string) (*Certificate, error) {
+    c := &Certificate{certFile: certFile, keyFile: keyFile}
+    if err := c.Reload(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// Reload reads the key pair again, keeping the previous one if it can't be loaded
+func (c *Certificate) Reload() error {
+    pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return err
+    }
+    c.current.Store(&pair)
+    return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    return c.current.Load(), nil
+}
+
+// Watch reloads the key pair whenever its files change, checking every interval
+// until ctx is done. Renewed certificates are picked up without a restart.
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    last := c.fingerprint()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            latest := c.fingerprint()
+            if latest == last {
+                continue
+            }
+            if err := c.Reload(); err != nil {
+                // cert and key may be mid-rotation, try again on the next tick
+                slog.Error("Failed to reload TLS certificate", "error", err)
+                continue
+            }
+            last = latest
+            slog.Info("Reloaded TLS certificate", "file", c.certFile)
+        }
+    }
+}
+
+// fingerprint returns the modification times and sizes of the key pair files
+func (c *Certificate) fingerprint() string {
+    var fingerprint string
+    for _, file := range []string{c.certFile, c.keyFile} {
+        fingerprint += file + "@"
+        if info, err := os.Stat(file); err == nil {
+            fingerprint += info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+        }
+        fingerprint += ";"
+    }
+    return fingerprint
+}
+
+// RedirectHandler redirects every request to the same URL over HTTPS on httpsPort
+func RedirectHandler(httpsPort string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host := strings.Trim(r.Host, "[]")
+        if h, _, err := net.SplitHostPort(r.Host); err == nil {
+            host = h
+        }
+        if httpsPort != "443" {
+            host = net.JoinHostPort(host, httpsPort)
+        } else if strings.Contains(host, ":") {
+            host = "[" + host + "]"
+        }
+        target := "https://" + host + r.URL.RequestURI()
+        // 308 keeps the method and body, so Terraform's POST and LOCK requests survive the redirect
+        http.Redirect(w, r, target, http.StatusPermanentRedirect)
+    })
+}
@@ -0,0 +1,140 @@
+package certs
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// writeKeyPair writes a self-signed certificate for commonName to certFile and keyFile
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatalf("Failed to generate key: %v", err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject:      pkix.Name{CommonName: commonName},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatalf("Failed to create certificate: %v", err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatalf("Failed to marshal key: %v", err)
+    }
+    if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+        t.Fatalf("Failed to write certificate: %v", err)
+    }
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+        t.Fatalf("Failed to write key: %v", err)
+    }
+}
+
+func commonName(t *testing.T, c *Certificate) string {
+    t.Helper()
+    pair, err := c.GetCertificate(nil)
+    if err != nil {
+        t.Fatalf("GetCertificate failed: %v", err)
+    }
+    leaf, err := x509.ParseCertificate(pair.Certificate[0])
+    if err != nil {
+        t.Fatalf("Failed to parse certificate: %v", err)
+    }
+    return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+    writeKeyPair(t, certFile, keyFile, "first")
+
+    cert, err := Load(certFile, keyFile)
+    if err != nil {
+        t.Fatalf("Load failed: %v", err)
+    }
+    if name := commonName(t, cert); name != "first" {
+        t.Errorf("Certificate = %q; want first", name)
+    }
+
+    os.WriteFile(keyFile, []byte("garbage"), 0600)
+    if err := cert.Reload(); err == nil {
+        t.Errorf("Reload accepted an invalid key")
+    }
+    if name := commonName(t, cert); name != "first" {
+        t.Errorf("Certificate after failed reload = %q; want first", name)
+    }
+
+    writeKeyPair(t, certFile, keyFile, "second")
+    if err := cert.Reload(); err != nil {
+        t.Fatalf("Reload failed: %v", err)
+    }
+    if name := commonName(t, cert); name != "second" {
+        t.Errorf("Certificate after reload = %q; want second", name)
+    }
+}
+
+func TestWatch(t *testing.T) {
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+    writeKeyPair(t, certFile, keyFile, "first")
+    cert, err := Load(certFile, keyFile)
+    if err != nil {
+        t.Fatalf("Load failed: %v", err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go cert.Watch(ctx, 10*time.Millisecond)
+    time.Sleep(50 * time.Millisecond)
+
+    writeKeyPair(t, certFile, keyFile, "renewed")
+    renewed := time.Now().Add(time.Minute)
+    os.Chtimes(certFile, renewed, renewed)
+    deadline := time.Now().Add(2 * time.Second)
+    for commonName(t, cert) != "renewed" {
+        if time.Now().After(deadline) {
+            t.Fatalf("Renewed certificate was not picked up")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestRedirectHandler(t *testing.T) {
+    tests := []struct {
+        host, port, want string
+    }{
+        {"example.com:8080", "8443", "https://example.com:8443/states/app?history"},
+        {"example.com", "443", "https://example.com/states/app?history"},
+        {"[::1]:8080", "443", "https://[::1]/states/app?history"},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodPost, "/states/app?history", nil)
+        req.Host = test.host
+        rr := httptest.NewRecorder()
+
+        RedirectHandler(test.port).ServeHTTP(rr, req)
+
+        if status := rr.Code; status != http.StatusPermanentRedirect {
+            t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusPermanentRedirect)
+        }
+        if location := rr.Header().Get("Location"); location != test.want {
+            t.Errorf("Redirect from %s = %q; want %q", test.host, location, test.want)
+        }
+    }
+}
@@ -1,12 +1,158 @@
 package config
 
-import "os"
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
 
-// GetEnv retrieves environment variables with a fallback default
+// GetEnv retrieves environment variables, then the loaded configuration file, with a fallback default
 func GetEnv(key string, fallback string) string {
     val := os.Getenv(key)
+    if val == "" {
+        val = fileValue(key)
+    }
     if val == "" {
         return fallback
     }
     return val
-}
\ No newline at end of file
+}
+
+// GetEnvInt retrieves an integer environment variable with a fallback default
+func GetEnvInt(key string, fallback int) int {
+    val := GetEnv(key, "")
+    if val == "" {
+        return fallback
+    }
+    i, err := strconv.Atoi(val)
+    if err != nil {
+        slog.Warn("Invalid integer, using default", "key", key, "value", val, "default", fallback)
+        return fallback
+    }
+    return i
+}
+
+// GetEnvFloat retrieves a floating point environment variable with a fallback default
+func GetEnvFloat(key string, fallback float64) float64 {
+    val := GetEnv(key, "")
+    if val == "" {
+        return fallback
+    }
+    f, err := strconv.ParseFloat(val, 64)
+    if err != nil {
+        slog.Warn("Invalid number, using default", "key", key, "value", val, "default", fallback)
+        return fallback
+    }
+    return f
+}
+
+// GetEnvSize retrieves a size in bytes (e.g. "1024", "64MiB", "1GB") with a fallback default
+func GetEnvSize(key string, fallback int64) int64 {
+    val := GetEnv(key, "")
+    if val == "" {
+        return fallback
+    }
+    size, err := ParseSize(val)
+    if err != nil {
+        slog.Warn("Invalid size, using default", "key", key, "value", val, "default", fallback)
+        return fallback
+    }
+    return size
+}
+
+// sizeUnits are the suffixes accepted by ParseSize, longest first so "MiB" isn't read as "B"
+var sizeUnits = []struct {
+    suffix string
+    bytes  int64
+}{
+    {"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+    {"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+    {"B", 1},
+}
+
+// ParseSize parses a non-negative size in bytes with an optional unit suffix
+func ParseSize(value string) (int64, error) {
+    number, multiplier := strings.TrimSpace(value), int64(1)
+    for _, unit := range sizeUnits {
+        if trimmed, ok := strings.CutSuffix(number, unit.suffix); ok {
+            number, multiplier = strings.TrimSpace(trimmed), unit.bytes
+            break
+        }
+    }
+    n, err := strconv.ParseInt(number, 10, 64)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("invalid size %q, e.g. 1048576, 512KiB or 64MiB", value)
+    }
+    return n * multiplier, nil
+}
+
+// GetEnvDuration retrieves a duration environment variable (e.g. "30s", "5m") with a fallback default
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+    val := GetEnv(key, "")
+    if val == "" {
+        return fallback
+    }
+    d, err := time.ParseDuration(val)
+    if err != nil {
+        slog.Warn("Invalid duration, using default", "key", key, "value", val, "default", fallback.String())
+        return fallback
+    }
+    return d
+}
+
+// GetEnvOrFile retrieves key from the file named by key_FILE when set (as used for
+// Docker and Kubernetes secrets), otherwise from the environment with a fallback default
+func GetEnvOrFile(key string, fallback string) (string, error) {
+    if file := GetEnv(key+"_FILE", ""); file != "" {
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return "", err
+        }
+        return strings.TrimRight(string(data), "\r\n"), nil
+    }
+    return GetEnv(key, fallback), nil
+}
+
+// PathSettings are the settings that may be overridden per state path prefix
+type PathSettings struct {
+    LockTTL           time.Duration
+    RetentionVersions int
+    RetentionMaxAge   time.Duration
+}
+
+// ForPath returns the settings for the state at path, applying the override
+// with the longest matching prefix from the configuration file
+func ForPath(path string) PathSettings {
+    settings := PathSettings{
+        LockTTL:           GetEnvDuration("LOCK_TTL", 0),
+        RetentionVersions: GetEnvInt("RETENTION_VERSIONS", 0),
+        RetentionMaxAge:   GetEnvDuration("RETENTION_MAX_AGE", 0),
+    }
+    cfg := loaded.Load()
+    if cfg == nil {
+        return settings
+    }
+    path = strings.TrimPrefix(path, "/")
+    var match *Override
+    for i, override := range cfg.overrides {
+        if strings.HasPrefix(path, override.Prefix) && (match == nil || len(override.Prefix) > len(match.Prefix)) {
+            match = &cfg.overrides[i]
+        }
+    }
+    if match == nil {
+        return settings
+    }
+    if match.LockTTL != nil {
+        settings.LockTTL = *match.LockTTL
+    }
+    if match.RetentionVersions != nil {
+        settings.RetentionVersions = *match.RetentionVersions
+    }
+    if match.RetentionMaxAge != nil {
+        settings.RetentionMaxAge = *match.RetentionMaxAge
+    }
+    return settings
+}
@@ -2,7 +2,9 @@ package config
 
 import (
     "os"
+    "path/filepath"
     "testing"
+    "time"
 )
 
 func TestGetEnv(t *testing.T) {
@@ -52,4 +54,86 @@ func TestGetEnv(t *testing.T) {
             }
         })
     }
-}
\ No newline at end of file
+}
+
+func TestGetEnvInt(t *testing.T) {
+    os.Setenv("INT_KEY", "42")
+    os.Setenv("BAD_INT_KEY", "forty-two")
+    defer func() {
+        os.Unsetenv("INT_KEY")
+        os.Unsetenv("BAD_INT_KEY")
+    }()
+
+    if result := GetEnvInt("INT_KEY", 7); result != 42 {
+        t.Errorf("GetEnvInt(INT_KEY) = %d; want 42", result)
+    }
+    if result := GetEnvInt("BAD_INT_KEY", 7); result != 7 {
+        t.Errorf("GetEnvInt(BAD_INT_KEY) = %d; want 7", result)
+    }
+    if result := GetEnvInt("MISSING_INT_KEY", 7); result != 7 {
+        t.Errorf("GetEnvInt(MISSING_INT_KEY) = %d; want 7", result)
+    }
+}
+
+func TestGetEnvDuration(t *testing.T) {
+    os.Setenv("DURATION_KEY", "90s")
+    os.Setenv("BAD_DURATION_KEY", "soon")
+    defer func() {
+        os.Unsetenv("DURATION_KEY")
+        os.Unsetenv("BAD_DURATION_KEY")
+    }()
+
+    if result := GetEnvDuration("DURATION_KEY", time.Second); result != 90*time.Second {
+        t.Errorf("GetEnvDuration(DURATION_KEY) = %s; want 1m30s", result)
+    }
+    if result := GetEnvDuration("BAD_DURATION_KEY", time.Second); result != time.Second {
+        t.Errorf("GetEnvDuration(BAD_DURATION_KEY) = %s; want 1s", result)
+    }
+}
+
+func TestGetEnvOrFile(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "secret")
+    if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+        t.Fatalf("Failed to write secret file: %v", err)
+    }
+    os.Setenv("SECRET_KEY", "from-env")
+    defer os.Unsetenv("SECRET_KEY")
+
+    if result, err := GetEnvOrFile("SECRET_KEY", "default"); err != nil || result != "from-env" {
+        t.Errorf("GetEnvOrFile(SECRET_KEY) = %q, %v; want %q", result, err, "from-env")
+    }
+
+    os.Setenv("SECRET_KEY_FILE", file)
+    defer os.Unsetenv("SECRET_KEY_FILE")
+
+    if result, err := GetEnvOrFile("SECRET_KEY", "default"); err != nil || result != "from-file" {
+        t.Errorf("GetEnvOrFile(SECRET_KEY) with _FILE = %q, %v; want %q", result, err, "from-file")
+    }
+
+    os.Setenv("SECRET_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+    if _, err := GetEnvOrFile("SECRET_KEY", "default"); err == nil {
+        t.Errorf("GetEnvOrFile(SECRET_KEY) with missing _FILE returned no error")
+    }
+}
+
+func TestParseSize(t *testing.T) {
+    tests := map[string]int64{
+        "0":       0,
+        "1048576": 1 << 20,
+        "512KiB":  512 << 10,
+        "64MiB":   64 << 20,
+        "1 GiB":   1 << 30,
+        "2MB":     2000000,
+        "10B":     10,
+    }
+    for value, want := range tests {
+        if got, err := ParseSize(value); err != nil || got != want {
+            t.Errorf("ParseSize(%q) = %d, %v; want %d", value, got, err, want)
+        }
+    }
+    for _, value := range []string{"", "-1", "12XB", "MiB"} {
+        if _, err := ParseSize(value); err == nil {
+            t.Errorf("ParseSize(%q) succeeded; want an error", value)
+        }
+    }
+}
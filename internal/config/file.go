@@ -0,0 +1,597 @@
+package config
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// field maps a setting in the configuration file to the environment variable
+// that overrides it
+type field struct {
+    path  string
+    key   string
+    check func(string) error
+}
+
+var fields = []field{
+    {"listeners.host", "HOST", nil},
+    {"listeners.port", "PORT", checkPort},
+    {"listeners.redirect_port", "HTTP_REDIRECT_PORT", checkPort},
+    {"listeners.shutdown_timeout", "SHUTDOWN_TIMEOUT", checkPositiveDuration},
+    {"listeners.shutdown_delay", "SHUTDOWN_DELAY", checkDuration},
+    {"listeners.read_header_timeout", "READ_HEADER_TIMEOUT", checkDuration},
+    {"listeners.read_timeout", "READ_TIMEOUT", checkDuration},
+    {"listeners.write_timeout", "WRITE_TIMEOUT", checkDuration},
+    {"listeners.idle_timeout", "IDLE_TIMEOUT", checkDuration},
+    {"listeners.body_read_timeout", "BODY_READ_TIMEOUT", checkDuration},
+    {"tls.cert_file", "TLS_CERT_FILE", checkFile},
+    {"tls.key_file", "TLS_KEY_FILE", checkFile},
+    {"tls.min_version", "TLS_MIN_VERSION", checkTLSVersion},
+    {"tls.cipher_suites", "TLS_CIPHER_SUITES", checkCipherSuites},
+    {"tls.reload_interval", "TLS_RELOAD_INTERVAL", checkPositiveDuration},
+    {"auth.username", "AUTH_USERNAME", nil},
+    {"auth.username_file", "AUTH_USERNAME_FILE", checkFile},
+    {"auth.password", "AUTH_PASSWORD", nil},
+    {"auth.password_file", "AUTH_PASSWORD_FILE", checkFile},
+    {"auth.readonly.username", "AUTH_READONLY_USERNAME", nil},
+    {"auth.readonly.username_file", "AUTH_READONLY_USERNAME_FILE", checkFile},
+    {"auth.readonly.password", "AUTH_READONLY_PASSWORD", nil},
+    {"auth.readonly.password_file", "AUTH_READONLY_PASSWORD_FILE", checkFile},
+    {"auth.readonly.outputs_only", "AUTH_READONLY_OUTPUTS_ONLY", checkBool},
+    {"auth.read_sensitive_users", "AUTH_READ_SENSITIVE_USERS", nil},
+    {"auth.max_failures", "AUTH_MAX_FAILURES", checkPositiveInt},
+    {"auth.lockout_base", "AUTH_LOCKOUT_BASE", checkDuration},
+    {"auth.lockout_max", "AUTH_LOCKOUT_MAX", checkDuration},
+    {"auth.reload_interval", "AUTH_RELOAD_INTERVAL", checkPositiveDuration},
+    {"auth.tenants_file", "TENANTS_FILE", checkFile},
+    {"auth.proxy.cidrs", "AUTH_PROXY_CIDRS", checkCIDRs},
+    {"auth.proxy.user_header", "AUTH_PROXY_USER_HEADER", nil},
+    {"auth.proxy.groups_header", "AUTH_PROXY_GROUPS_HEADER", nil},
+    {"auth.proxy.readwrite_groups", "AUTH_PROXY_READWRITE_GROUPS", nil},
+    {"auth.proxy.readonly_groups", "AUTH_PROXY_READONLY_GROUPS", nil},
+    {"auth.proxy.read_sensitive_groups", "AUTH_PROXY_READ_SENSITIVE_GROUPS", nil},
+    {"storage.driver", "STORAGE_DRIVER", checkDriver},
+    {"storage.data_dir", "DATA_DIR", nil},
+    {"storage.index", "INDEX_ENABLED", checkBool},
+    {"storage.index_sync_interval", "INDEX_SYNC_INTERVAL", checkDuration},
+    {"storage.move_tombstone_ttl", "MOVE_TOMBSTONE_TTL", checkDuration},
+    {"storage.move_alias_mode", "MOVE_ALIAS_MODE", checkAliasMode},
+    {"storage.trash_retention", "TRASH_RETENTION", checkDuration},
+    {"limits.max_state_size", "MAX_STATE_SIZE", checkSize},
+    {"limits.max_lock_size", "MAX_LOCK_SIZE", checkSize},
+    {"rate_limits.reads", "RATE_LIMIT_READS", checkRate},
+    {"rate_limits.writes", "RATE_LIMIT_WRITES", checkRate},
+    {"rate_limits.locks", "RATE_LIMIT_LOCKS", checkRate},
+    {"rate_limits.burst", "RATE_LIMIT_BURST", checkPositiveInt},
+    {"retention.versions", "RETENTION_VERSIONS", checkNonNegativeInt},
+    {"retention.max_age", "RETENTION_MAX_AGE", checkDuration},
+    {"locks.ttl", "LOCK_TTL", checkDuration},
+    {"logging.level", "LOG_LEVEL", checkLogLevel},
+    {"logging.format", "LOG_FORMAT", checkLogFormat},
+    {"tracing.exporter", "TRACING_EXPORTER", checkTracingExporter},
+    {"tracing.file", "TRACING_FILE", nil},
+    {"tracing.sample_ratio", "TRACING_SAMPLE_RATIO", checkRatio},
+    {"metrics.auth", "METRICS_AUTH", checkBool},
+    {"metrics.prefix_depth", "METRICS_PREFIX_DEPTH", checkPositiveInt},
+    {"metrics.max_prefixes", "METRICS_MAX_PREFIXES", checkPositiveInt},
+}
+
+// Override replaces settings for states whose path starts with Prefix
+type Override struct {
+    Prefix            string
+    LockTTL           *time.Duration
+    RetentionVersions *int
+    RetentionMaxAge   *time.Duration
+}
+
+// Error is a configuration problem, located by file and line or by environment variable
+type Error struct {
+    File    string
+    Line    int
+    Field   string
+    Message string
+}
+
+func (e Error) Error() string {
+    if e.File == "" {
+        return fmt.Sprintf("environment variable %s: %s", e.Field, e.Message)
+    }
+    return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Message)
+}
+
+// Errors is every problem found validating a configuration
+type Errors []Error
+
+func (errs Errors) Error() string {
+    messages := make([]string, len(errs))
+    for i, err := range errs {
+        messages[i] = err.Error()
+    }
+    return strings.Join(messages, "\n")
+}
+
+// setting is a value read from the configuration file or environment
+type setting struct {
+    value string
+    file  string
+    line  int
+}
+
+type fileConfig struct {
+    values    map[string]string
+    overrides []Override
+}
+
+var loaded atomic.Pointer[fileConfig]
+
+// Load validates the configuration file, when given, together with the
+// environment, and makes the file the source of every setting the environment
+// leaves unset
+func Load(file string) error {
+    cfg, err := parse(file)
+    if err != nil {
+        return err
+    }
+    loaded.Store(cfg)
+    return nil
+}
+
+// Check validates the configuration file and environment without applying them
+func Check(file string) error {
+    _, err := parse(file)
+    return err
+}
+
+// fileValue returns the value of key from the loaded configuration file
+func fileValue(key string) string {
+    if cfg := loaded.Load(); cfg != nil {
+        return cfg.values[key]
+    }
+    return ""
+}
+
+func parse(file string) (*fileConfig, error) {
+    var errs Errors
+    settings := make(map[string]setting)
+    var overrides []Override
+    if file != "" {
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return nil, err
+        }
+        var root yaml.Node
+        if err := yaml.Unmarshal(data, &root); err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+        if len(root.Content) > 0 {
+            p := &parser{file: file, settings: settings}
+            p.mapping(root.Content[0], "")
+            errs = append(errs, p.errs...)
+            overrides = p.overrides
+        }
+    }
+
+    fileValues := make(map[string]string)
+    for key, s := range settings {
+        fileValues[key] = s.value
+    }
+    for _, f := range fields {
+        if value := os.Getenv(f.key); value != "" {
+            settings[f.key] = setting{value: value}
+        }
+    }
+    for _, f := range fields {
+        s, ok := settings[f.key]
+        if !ok || f.check == nil {
+            continue
+        }
+        if err := f.check(s.value); err != nil {
+            errs = append(errs, s.error(f, err.Error()))
+        }
+    }
+    errs = append(errs, checkPairs(settings)...)
+
+    if len(errs) > 0 {
+        sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+        return nil, errs
+    }
+    return &fileConfig{values: fileValues, overrides: overrides}, nil
+}
+
+func (s setting) error(f field, message string) Error {
+    if s.file == "" {
+        return Error{Field: f.key, Message: message}
+    }
+    return Error{File: s.file, Line: s.line, Field: f.path, Message: message}
+}
+
+// checkPairs validates settings that are only meaningful together
+func checkPairs(settings map[string]setting) Errors {
+    var errs Errors
+    pairs := [][2]string{
+        {"TLS_CERT_FILE", "TLS_KEY_FILE"},
+    }
+    for _, pair := range pairs {
+        first, firstOK := settings[pair[0]]
+        second, secondOK := settings[pair[1]]
+        if firstOK && !secondOK {
+            errs = append(errs, first.error(fieldFor(pair[0]), fmt.Sprintf("requires %s", fieldFor(pair[1]).path)))
+        } else if secondOK && !firstOK {
+            errs = append(errs, second.error(fieldFor(pair[1]), fmt.Sprintf("requires %s", fieldFor(pair[0]).path)))
+        }
+    }
+    if redirect, ok := settings["HTTP_REDIRECT_PORT"]; ok {
+        if _, tlsOK := settings["TLS_CERT_FILE"]; !tlsOK {
+            errs = append(errs, redirect.error(fieldFor("HTTP_REDIRECT_PORT"), "requires tls.cert_file and tls.key_file"))
+        }
+    }
+    credentials := [][2]string{
+        {"AUTH_USERNAME", "AUTH_PASSWORD"},
+        {"AUTH_READONLY_USERNAME", "AUTH_READONLY_PASSWORD"},
+    }
+    for _, pair := range credentials {
+        username, usernameOK := firstSetting(settings, pair[0], pair[0]+"_FILE")
+        password, passwordOK := firstSetting(settings, pair[1], pair[1]+"_FILE")
+        if usernameOK && !passwordOK {
+            errs = append(errs, username.error(fieldFor(pair[0]), "username set without a password"))
+        } else if passwordOK && !usernameOK {
+            errs = append(errs, password.error(fieldFor(pair[1]), "password set without a username"))
+        }
+    }
+    return errs
+}
+
+func firstSetting(settings map[string]setting, keys ...string) (setting, bool) {
+    for _, key := range keys {
+        if s, ok := settings[key]; ok {
+            return s, true
+        }
+    }
+    return setting{}, false
+}
+
+func fieldFor(key string) field {
+    for _, f := range fields {
+        if f.key == key {
+            return f
+        }
+    }
+    return field{path: key, key: key}
+}
+
+// parser walks the YAML document recording settings with the line they were set on
+type parser struct {
+    file      string
+    settings  map[string]setting
+    overrides []Override
+    errs      Errors
+}
+
+func (p *parser) fail(node *yaml.Node, path, format string, args ...interface{}) {
+    p.errs = append(p.errs, Error{File: p.file, Line: node.Line, Field: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) mapping(node *yaml.Node, prefix string) {
+    if node.Kind != yaml.MappingNode {
+        p.fail(node, strings.TrimSuffix(prefix, "."), "expected a mapping")
+        return
+    }
+    seen := make(map[string]bool)
+    for i := 0; i+1 < len(node.Content); i += 2 {
+        keyNode, valueNode := node.Content[i], node.Content[i+1]
+        path := prefix + keyNode.Value
+        if seen[keyNode.Value] {
+            p.fail(keyNode, path, "duplicate key")
+            continue
+        }
+        seen[keyNode.Value] = true
+        if path == "overrides" {
+            p.parseOverrides(valueNode)
+            continue
+        }
+        if f, ok := fieldAt(path); ok {
+            if value, ok := p.scalar(valueNode, path); ok {
+                p.settings[f.key] = setting{value: value, file: p.file, line: valueNode.Line}
+            }
+            continue
+        }
+        if isSection(path) {
+            p.mapping(valueNode, path+".")
+            continue
+        }
+        p.fail(keyNode, path, "unknown setting")
+    }
+}
+
+// scalar returns a setting's value, joining a sequence of scalars with commas
+func (p *parser) scalar(node *yaml.Node, path string) (string, bool) {
+    switch node.Kind {
+    case yaml.ScalarNode:
+        return node.Value, true
+    case yaml.SequenceNode:
+        items := make([]string, 0, len(node.Content))
+        for _, item := range node.Content {
+            if item.Kind != yaml.ScalarNode {
+                p.fail(item, path, "expected a list of values")
+                return "", false
+            }
+            items = append(items, item.Value)
+        }
+        return strings.Join(items, ","), true
+    default:
+        p.fail(node, path, "expected a value")
+        return "", false
+    }
+}
+
+func (p *parser) parseOverrides(node *yaml.Node) {
+    if node.Kind != yaml.SequenceNode {
+        p.fail(node, "overrides", "expected a list")
+        return
+    }
+    prefixes := make(map[string]bool)
+    for i, item := range node.Content {
+        path := fmt.Sprintf("overrides[%d]", i)
+        if item.Kind != yaml.MappingNode {
+            p.fail(item, path, "expected a mapping")
+            continue
+        }
+        var override Override
+        values := make(map[string]*yaml.Node)
+        for j := 0; j+1 < len(item.Content); j += 2 {
+            keyNode, valueNode := item.Content[j], item.Content[j+1]
+            switch keyNode.Value {
+            case "prefix", "lock_ttl":
+                values[keyNode.Value] = valueNode
+            case "retention":
+                if valueNode.Kind != yaml.MappingNode {
+                    p.fail(valueNode, path+".retention", "expected a mapping")
+                    continue
+                }
+                for k := 0; k+1 < len(valueNode.Content); k += 2 {
+                    switch name := valueNode.Content[k].Value; name {
+                    case "versions", "max_age":
+                        values["retention."+name] = valueNode.Content[k+1]
+                    default:
+                        p.fail(valueNode.Content[k], path+".retention."+name, "unknown setting")
+                    }
+                }
+            default:
+                p.fail(keyNode, path+"."+keyNode.Value, "unknown setting")
+            }
+        }
+        if prefixNode, ok := values["prefix"]; !ok || prefixNode.Value == "" {
+            p.fail(item, path+".prefix", "required")
+        } else if prefixes[prefixNode.Value] {
+            p.fail(prefixNode, path+".prefix", "duplicate prefix %q", prefixNode.Value)
+        } else {
+            override.Prefix = strings.TrimPrefix(prefixNode.Value, "/")
+            prefixes[prefixNode.Value] = true
+        }
+        if n, ok := values["lock_ttl"]; ok {
+            if d, err := parseDuration(n.Value); err != nil {
+                p.fail(n, path+".lock_ttl", "%v", err)
+            } else {
+                override.LockTTL = &d
+            }
+        }
+        if n, ok := values["retention.versions"]; ok {
+            if v, err := parseNonNegativeInt(n.Value); err != nil {
+                p.fail(n, path+".retention.versions", "%v", err)
+            } else {
+                override.RetentionVersions = &v
+            }
+        }
+        if n, ok := values["retention.max_age"]; ok {
+            if d, err := parseDuration(n.Value); err != nil {
+                p.fail(n, path+".retention.max_age", "%v", err)
+            } else {
+                override.RetentionMaxAge = &d
+            }
+        }
+        p.overrides = append(p.overrides, override)
+    }
+}
+
+func fieldAt(path string) (field, bool) {
+    for _, f := range fields {
+        if f.path == path {
+            return f, true
+        }
+    }
+    return field{}, false
+}
+
+func isSection(path string) bool {
+    for _, f := range fields {
+        if strings.HasPrefix(f.path, path+".") {
+            return true
+        }
+    }
+    return false
+}
+
+func checkPort(value string) error {
+    port, err := strconv.Atoi(value)
+    if err != nil || port < 1 || port > 65535 {
+        return fmt.Errorf("invalid port %q, must be between 1 and 65535", value)
+    }
+    return nil
+}
+
+func checkFile(value string) error {
+    if _, err := os.Stat(value); err != nil {
+        return fmt.Errorf("cannot read file: %v", err)
+    }
+    return nil
+}
+
+// TLSVersions are the accepted values for the minimum TLS version
+var TLSVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+func checkTLSVersion(value string) error {
+    if _, ok := TLSVersions[value]; !ok {
+        return fmt.Errorf("invalid TLS version %q, must be one of 1.0, 1.1, 1.2, 1.3", value)
+    }
+    return nil
+}
+
+func checkCipherSuites(value string) error {
+    _, err := CipherSuites(value)
+    return err
+}
+
+// CipherSuites returns the IDs of a comma-separated list of cipher suite names,
+// nil when the list is empty. Only the suites Go considers secure are accepted.
+func CipherSuites(value string) ([]uint16, error) {
+    known := make(map[string]uint16)
+    for _, suite := range tls.CipherSuites() {
+        known[suite.Name] = suite.ID
+    }
+    var ids []uint16
+    for _, name := range strings.Split(value, ",") {
+        if name = strings.TrimSpace(name); name == "" {
+            continue
+        }
+        id, ok := known[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+        }
+        ids = append(ids, id)
+    }
+    return ids, nil
+}
+
+func checkLogLevel(value string) error {
+    switch value {
+    case "debug", "info", "warn", "error":
+        return nil
+    }
+    return fmt.Errorf("invalid log level %q, must be one of debug, info, warn, error", value)
+}
+
+func checkLogFormat(value string) error {
+    if value != "json" && value != "text" {
+        return fmt.Errorf("invalid log format %q, must be json or text", value)
+    }
+    return nil
+}
+
+func checkAliasMode(value string) error {
+    switch value {
+    case "none", "redirect", "forward":
+        return nil
+    }
+    return fmt.Errorf("invalid alias mode %q, must be none, redirect or forward", value)
+}
+
+func checkTracingExporter(value string) error {
+    switch value {
+    case "none", "stdout", "file", "otlp":
+        return nil
+    }
+    return fmt.Errorf("unknown tracing exporter %q, must be one of none, stdout, file, otlp", value)
+}
+
+func checkRatio(value string) error {
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil || f < 0 || f > 1 {
+        return fmt.Errorf("invalid ratio %q, must be between 0 and 1", value)
+    }
+    return nil
+}
+
+func checkRate(value string) error {
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil || f < 0 {
+        return fmt.Errorf("invalid rate %q, must be a non-negative number of requests per minute", value)
+    }
+    return nil
+}
+
+func checkSize(value string) error {
+    _, err := ParseSize(value)
+    return err
+}
+
+func checkBool(value string) error {
+    if value != "true" && value != "false" {
+        return fmt.Errorf("invalid boolean %q, must be true or false", value)
+    }
+    return nil
+}
+
+func checkPositiveInt(value string) error {
+    i, err := strconv.Atoi(value)
+    if err != nil || i < 1 {
+        return fmt.Errorf("invalid value %q, must be a positive integer", value)
+    }
+    return nil
+}
+
+func checkNonNegativeInt(value string) error {
+    _, err := parseNonNegativeInt(value)
+    return err
+}
+
+func parseNonNegativeInt(value string) (int, error) {
+    i, err := strconv.Atoi(value)
+    if err != nil || i < 0 {
+        return 0, fmt.Errorf("invalid value %q, must be zero or a positive integer", value)
+    }
+    return i, nil
+}
+
+func checkDuration(value string) error {
+    _, err := parseDuration(value)
+    return err
+}
+
+func parseDuration(value string) (time.Duration, error) {
+    d, err := time.ParseDuration(value)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("invalid duration %q, e.g. 30s, 15m or 2h", value)
+    }
+    return d, nil
+}
+
+func checkPositiveDuration(value string) error {
+    if d, err := parseDuration(value); err != nil || d == 0 {
+        return fmt.Errorf("invalid duration %q, must be greater than zero", value)
+    }
+    return nil
+}
+
+func checkCIDRs(value string) error {
+    for _, cidr := range strings.Split(value, ",") {
+        cidr = strings.TrimSpace(cidr)
+        if cidr == "" || net.ParseIP(cidr) != nil {
+            continue
+        }
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            return fmt.Errorf("invalid CIDR %q", cidr)
+        }
+    }
+    return nil
+}
+
+func checkDriver(value string) error {
+    if value != "filesystem" {
+        return fmt.Errorf("unknown storage driver %q, must be filesystem", value)
+    }
+    return nil
+}
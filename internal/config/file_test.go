@@ -0,0 +1,145 @@
+package config
+
+import (
+    "crypto/tls"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+    t.Helper()
+    file := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+        t.Fatalf("Failed to write config file: %v", err)
+    }
+    return file
+}
+
+func TestLoadFile(t *testing.T) {
+    file := writeConfig(t, `
+listeners:
+  port: 9955
+storage:
+  driver: filesystem
+  data_dir: /srv/states
+auth:
+  username: admin
+  password: secret
+  proxy:
+    cidrs: [10.0.0.0/8, 192.168.0.1]
+retention:
+  versions: 5
+locks:
+  ttl: 1h
+overrides:
+  - prefix: prod/
+    lock_ttl: 4h
+    retention:
+      versions: 50
+  - prefix: prod/network/
+    retention:
+      max_age: 720h
+`)
+    os.Setenv("PORT", "9966")
+    defer os.Unsetenv("PORT")
+    defer loaded.Store(nil)
+
+    if err := Load(file); err != nil {
+        t.Fatalf("Load failed: %v", err)
+    }
+
+    if port := GetEnv("PORT", "9944"); port != "9966" {
+        t.Errorf("GetEnv(PORT) = %q; want environment override 9966", port)
+    }
+    if dataDir := GetEnv("DATA_DIR", "./data"); dataDir != "/srv/states" {
+        t.Errorf("GetEnv(DATA_DIR) = %q; want /srv/states", dataDir)
+    }
+    if cidrs := GetEnv("AUTH_PROXY_CIDRS", ""); cidrs != "10.0.0.0/8,192.168.0.1" {
+        t.Errorf("GetEnv(AUTH_PROXY_CIDRS) = %q; want joined list", cidrs)
+    }
+
+    tests := []struct {
+        path     string
+        expected PathSettings
+    }{
+        {"/dev/app", PathSettings{LockTTL: time.Hour, RetentionVersions: 5}},
+        {"/prod/app", PathSettings{LockTTL: 4 * time.Hour, RetentionVersions: 50}},
+        {"/prod/network/vpc", PathSettings{LockTTL: time.Hour, RetentionVersions: 5, RetentionMaxAge: 720 * time.Hour}},
+    }
+    for _, test := range tests {
+        if settings := ForPath(test.path); settings != test.expected {
+            t.Errorf("ForPath(%q) = %+v; want %+v", test.path, settings, test.expected)
+        }
+    }
+}
+
+func TestCheckFileErrors(t *testing.T) {
+    file := writeConfig(t, `listeners:
+  port: 99999
+tls:
+  cert_file: /missing/cert.pem
+  min_version: "1.5"
+storage:
+  driver: s3
+  bucket: states
+auth:
+  username: admin
+locks:
+  ttl: forever
+overrides:
+  - lock_ttl: 1h
+`)
+
+    err := Check(file)
+    var errs Errors
+    if !errors.As(err, &errs) {
+        t.Fatalf("Check returned %v; want Errors", err)
+    }
+
+    expected := []string{
+        file + ":2: listeners.port: invalid port",
+        file + ":4: tls.cert_file: cannot read file",
+        file + ":4: tls.cert_file: requires tls.key_file",
+        file + ":5: tls.min_version: invalid TLS version",
+        file + ":7: storage.driver: unknown storage driver",
+        file + ":8: storage.bucket: unknown setting",
+        file + ":10: auth.username: username set without a password",
+        file + ":12: locks.ttl: invalid duration",
+        file + ":14: overrides[0].prefix: required",
+    }
+    if len(errs) != len(expected) {
+        t.Fatalf("Check returned %d errors; want %d:\n%v", len(errs), len(expected), err)
+    }
+    for i, prefix := range expected {
+        if !strings.HasPrefix(errs[i].Error(), prefix) {
+            t.Errorf("Error %d = %q; want prefix %q", i, errs[i].Error(), prefix)
+        }
+    }
+}
+
+func TestCheckEnvironmentErrors(t *testing.T) {
+    os.Setenv("LOCK_TTL", "soon")
+    defer os.Unsetenv("LOCK_TTL")
+
+    err := Check("")
+    if err == nil || err.Error() != `environment variable LOCK_TTL: invalid duration "soon", e.g. 30s, 15m or 2h` {
+        t.Errorf("Check returned %v; want LOCK_TTL error", err)
+    }
+}
+
+func TestCipherSuites(t *testing.T) {
+    ids, err := CipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+    if err != nil || len(ids) != 2 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+        t.Errorf("CipherSuites returned %v, %v; want both suites", ids, err)
+    }
+    if ids, err := CipherSuites(""); err != nil || ids != nil {
+        t.Errorf("CipherSuites of an empty list returned %v, %v; want nil", ids, err)
+    }
+    if _, err := CipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+        t.Errorf("CipherSuites accepted an insecure suite")
+    }
+}
@@ -0,0 +1,142 @@
+package health
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "sync/atomic"
+    "time"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/utils"
+)
+
+// Version is the version of the server, set at build time with
+// -ldflags "-X terraform-http-backend/internal/health.Version=..."
+var Version = "dev"
+
+var started = time.Now()
+
+var draining atomic.Bool
+
+// SetDraining marks the server as shutting down, failing readiness so no new traffic is routed to it
+func SetDraining() {
+    draining.Store(true)
+}
+
+// Check is the result of one readiness check
+type Check struct {
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+}
+
+// HandleHealthz reports that the process is alive
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        utils.MethodNotAllowed(w, r)
+        return
+    }
+    utils.WriteJSON(w, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz reports whether the server can serve states from dataDir,
+// with the result of each check. Any failing check returns 503.
+func HandleReadyz(w http.ResponseWriter, r *http.Request, dataDir string) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        utils.MethodNotAllowed(w, r)
+        return
+    }
+    checks := map[string]Check{
+        "storage":  result(checkStorage(dataDir)),
+        "writable": result(checkWritable(dataDir)),
+        "draining": result(checkDraining()),
+    }
+    status, code := "ok", http.StatusOK
+    for _, check := range checks {
+        if check.Status != "ok" {
+            status, code = "unavailable", http.StatusServiceUnavailable
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
+
+func result(err error) Check {
+    if err != nil {
+        return Check{Status: "failed", Error: err.Error()}
+    }
+    return Check{Status: "ok"}
+}
+
+// checkStorage verifies the storage driver can reach its data
+func checkStorage(dataDir string) error {
+    if driver := config.GetEnv("STORAGE_DRIVER", "filesystem"); driver != "filesystem" {
+        return fmt.Errorf("unsupported storage driver %q", driver)
+    }
+    info, err := os.Stat(dataDir)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        return fmt.Errorf("%s is not a directory", dataDir)
+    }
+    return nil
+}
+
+// checkWritable writes, syncs and removes a file in dataDir
+func checkWritable(dataDir string) error {
+    file, err := os.CreateTemp(dataDir, ".readyz-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+    defer file.Close()
+    if _, err := file.Write([]byte("ok")); err != nil {
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        return fmt.Errorf("fsync: %v", err)
+    }
+    return file.Close()
+}
+
+func checkDraining() error {
+    if draining.Load() {
+        return fmt.Errorf("server is shutting down")
+    }
+    return nil
+}
+
+// HandleStatus reports the version, uptime and a summary of the configuration
+func HandleStatus(w http.ResponseWriter, r *http.Request, dataDir string) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        utils.MethodNotAllowed(w, r)
+        return
+    }
+    utils.WriteJSON(w, map[string]interface{}{
+        "version": Version,
+        "started": started.UTC(),
+        "uptime":  time.Since(started).Round(time.Second).String(),
+        "config":  summary(dataDir),
+    })
+}
+
+// summary describes the effective configuration, without any secrets
+func summary(dataDir string) map[string]interface{} {
+    return map[string]interface{}{
+        "config_file":        config.GetEnv("CONFIG_FILE", ""),
+        "port":               config.GetEnv("PORT", "9944"),
+        "tls":                config.GetEnv("TLS_CERT_FILE", "") != "" && config.GetEnv("TLS_KEY_FILE", "") != "",
+        "storage_driver":     config.GetEnv("STORAGE_DRIVER", "filesystem"),
+        "data_dir":           dataDir,
+        "auth":               config.GetEnv("AUTH_USERNAME", "") != "" || config.GetEnv("AUTH_USERNAME_FILE", "") != "",
+        "readonly_auth":      config.GetEnv("AUTH_READONLY_USERNAME", "") != "" || config.GetEnv("AUTH_READONLY_USERNAME_FILE", "") != "",
+        "proxy_auth":         config.GetEnv("AUTH_PROXY_CIDRS", "") != "",
+        "tenants_file":       config.GetEnv("TENANTS_FILE", ""),
+        "retention_versions": config.GetEnvInt("RETENTION_VERSIONS", 0),
+        "retention_max_age":  config.GetEnvDuration("RETENTION_MAX_AGE", 0).String(),
+        "lock_ttl":           config.GetEnvDuration("LOCK_TTL", 0).String(),
+    }
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestHandleHealthz(t *testing.T) {
+    req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+    rr := httptest.NewRecorder()
+
+    HandleHealthz(rr, req)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+}
+
+func readyz(t *testing.T, dataDir string) (int, map[string]Check) {
+    t.Helper()
+    req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+    rr := httptest.NewRecorder()
+
+    HandleReadyz(rr, req, dataDir)
+
+    var response struct {
+        Checks map[string]Check `json:"checks"`
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+        t.Fatalf("Failed to decode response %q: %v", rr.Body.String(), err)
+    }
+    return rr.Code, response.Checks
+}
+
+func TestHandleReadyz(t *testing.T) {
+    dataDir := t.TempDir()
+
+    status, checks := readyz(t, dataDir)
+    if status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v: %v", status, http.StatusOK, checks)
+    }
+    for _, name := range []string{"storage", "writable", "draining"} {
+        if checks[name].Status != "ok" {
+            t.Errorf("Check %s = %+v; want ok", name, checks[name])
+        }
+    }
+    if leftovers, _ := filepath.Glob(filepath.Join(dataDir, ".readyz-*")); len(leftovers) != 0 {
+        t.Errorf("Readiness check left files behind: %v", leftovers)
+    }
+}
+
+func TestHandleReadyzMissingDataDir(t *testing.T) {
+    status, checks := readyz(t, filepath.Join(t.TempDir(), "missing"))
+    if status != http.StatusServiceUnavailable {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+    }
+    if checks["storage"].Status != "failed" || checks["writable"].Status != "failed" {
+        t.Errorf("Checks = %+v; want storage and writable failed", checks)
+    }
+}
+
+func TestHandleReadyzDraining(t *testing.T) {
+    SetDraining()
+    defer draining.Store(false)
+
+    status, checks := readyz(t, t.TempDir())
+    if status != http.StatusServiceUnavailable {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+    }
+    if checks["draining"].Status != "failed" {
+        t.Errorf("Check draining = %+v; want failed", checks["draining"])
+    }
+}
+
+func TestHandleStatus(t *testing.T) {
+    os.Setenv("AUTH_PASSWORD", "secret")
+    defer os.Unsetenv("AUTH_PASSWORD")
+    req := httptest.NewRequest(http.MethodGet, "/status", nil)
+    rr := httptest.NewRecorder()
+
+    HandleStatus(rr, req, "/data")
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    var response struct {
+        Version string                 `json:"version"`
+        Uptime  string                 `json:"uptime"`
+        Config  map[string]interface{} `json:"config"`
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+        t.Fatalf("Failed to decode response: %v", err)
+    }
+    if response.Version != Version || response.Uptime == "" || response.Config["data_dir"] != "/data" {
+        t.Errorf("Unexpected status %s", rr.Body.String())
+    }
+    if strings.Contains(rr.Body.String(), "secret") {
+        t.Errorf("Status exposes a secret: %s", rr.Body.String())
+    }
+}
@@ -0,0 +1,193 @@
+package history
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// idFormat names versions by the UTC time they were replaced, so they sort chronologically
+const idFormat = "20060102T150405.000000000Z"
+
+const suffix = ".tfstate"
+
+// changeSuffix names the file recording the Change that replaced a version
+const changeSuffix = ".json"
+
+var validID = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+
+// Version is a previous copy of a state, kept when the state was replaced
+type Version struct {
+    ID       string    `json:"id"`
+    Size     int64     `json:"size"`
+    Replaced time.Time `json:"replaced"`
+    // Change is set when the version was replaced by an edit made through the
+    // API rather than by terraform
+    Change *Change `json:"change,omitempty"`
+}
+
+// Change describes an edit of a state made through the API, such as removing a resource
+type Change struct {
+    Operation string `json:"operation"`
+    Operator  string `json:"operator"`
+    Reason    string `json:"reason"`
+}
+
+// Dir returns the directory holding the versions of the state at statePath
+func Dir(dataDir, statePath string) string {
+    return filepath.Join(dataDir, "history", filepath.Clean("/"+statePath))
+}
+
+// Save copies the file at statefilePath into the history of the state at statePath
+func Save(dataDir, statePath, statefilePath string, now time.Time) error {
+    src, err := os.Open(statefilePath)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    dir := Dir(dataDir, statePath)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    dst, err := os.OpenFile(filepath.Join(dir, now.UTC().Format(idFormat)+suffix), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(dst, src); err != nil {
+        dst.Close()
+        return err
+    }
+    return dst.Close()
+}
+
+// SaveChange saves the file at statefilePath into the history like Save,
+// recording the change that is replacing it
+func SaveChange(dataDir, statePath, statefilePath string, now time.Time, change Change) error {
+    if err := Save(dataDir, statePath, statefilePath, now); err != nil {
+        return err
+    }
+    data, err := json.Marshal(change)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(Dir(dataDir, statePath), now.UTC().Format(idFormat)+changeSuffix), data, 0644)
+}
+
+// List returns the versions of the state at statePath, newest first
+func List(dataDir, statePath string) ([]Version, error) {
+    entries, err := os.ReadDir(Dir(dataDir, statePath))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    var versions []Version
+    for _, entry := range entries {
+        id := strings.TrimSuffix(entry.Name(), suffix)
+        if entry.IsDir() || !validID.MatchString(id) {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            return nil, err
+        }
+        replaced, _ := time.Parse(idFormat, id)
+        version := Version{ID: id, Size: info.Size(), Replaced: replaced}
+        if data, err := os.ReadFile(filepath.Join(Dir(dataDir, statePath), id+changeSuffix)); err == nil {
+            var change Change
+            if json.Unmarshal(data, &change) == nil {
+                version.Change = &change
+            }
+        }
+        versions = append(versions, version)
+    }
+    sort.Slice(versions, func(i, j int) bool { return versions[i].ID > versions[j].ID })
+    return versions, nil
+}
+
+// Read returns the contents of a version of the state at statePath
+func Read(dataDir, statePath, id string) ([]byte, error) {
+    if !validID.MatchString(id) {
+        return nil, fmt.Errorf("invalid version %q: %w", id, os.ErrNotExist)
+    }
+    return os.ReadFile(filepath.Join(Dir(dataDir, statePath), id+suffix))
+}
+
+// Prune removes versions of the state at statePath beyond the newest keep,
+// and versions replaced longer than maxAge ago when maxAge is set
+func Prune(dataDir, statePath string, keep int, maxAge time.Duration, now time.Time) error {
+    versions, err := List(dataDir, statePath)
+    if err != nil {
+        return err
+    }
+    dir := Dir(dataDir, statePath)
+    for i, version := range versions {
+        if i < keep && (maxAge == 0 || now.Sub(version.Replaced) <= maxAge) {
+            continue
+        }
+        if err := os.Remove(filepath.Join(dir, version.ID+suffix)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        if err := os.Remove(filepath.Join(dir, version.ID+changeSuffix)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return nil
+}
+
+// Move moves the versions of the state at src into the history of the state at dst
+func Move(dataDir, src, dst string) error {
+    return transfer(dataDir, src, dst, os.Rename)
+}
+
+// Copy copies the versions of the state at src into the history of the state at dst
+func Copy(dataDir, src, dst string) error {
+    return transfer(dataDir, src, dst, copyFile)
+}
+
+func transfer(dataDir, src, dst string, fn func(from, to string) error) error {
+    srcDir, dstDir := Dir(dataDir, src), Dir(dataDir, dst)
+    entries, err := os.ReadDir(srcDir)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(dstDir, 0755); err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if !entry.Type().IsRegular() {
+            continue
+        }
+        if err := fn(filepath.Join(srcDir, entry.Name()), filepath.Join(dstDir, entry.Name())); err != nil {
+            return err
+        }
+    }
+    // Only removed once empty, which it isn't when states are stored below src
+    os.Remove(srcDir)
+    return nil
+}
+
+func copyFile(from, to string) error {
+    src, err := os.Open(from)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(dst, src); err != nil {
+        dst.Close()
+        return err
+    }
+    return dst.Close()
+}
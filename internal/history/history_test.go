@@ -0,0 +1,79 @@
+package history
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestSaveListPrune(t *testing.T) {
+    dataDir := t.TempDir()
+    statefilePath := filepath.Join(dataDir, "states", "prod", "app")
+    os.MkdirAll(filepath.Dir(statefilePath), 0755)
+
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i := 0; i < 4; i++ {
+        if err := os.WriteFile(statefilePath, []byte{byte('a' + i)}, 0644); err != nil {
+            t.Fatalf("Failed to write state: %v", err)
+        }
+        if err := Save(dataDir, "/prod/app", statefilePath, start.Add(time.Duration(i)*time.Hour)); err != nil {
+            t.Fatalf("Save failed: %v", err)
+        }
+    }
+
+    versions, err := List(dataDir, "/prod/app")
+    if err != nil {
+        t.Fatalf("List failed: %v", err)
+    }
+    if len(versions) != 4 || versions[0].ID != "20240101T030000.000000000Z" || !versions[0].Replaced.Equal(start.Add(3*time.Hour)) {
+        t.Fatalf("List returned %+v; want 4 versions newest first", versions)
+    }
+
+    data, err := Read(dataDir, "/prod/app", versions[3].ID)
+    if err != nil || string(data) != "a" {
+        t.Errorf("Read(oldest) = %q, %v; want %q", data, err, "a")
+    }
+    if _, err := Read(dataDir, "/prod/app", "../../states/prod/app"); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Read accepted a crafted version id: %v", err)
+    }
+
+    if err := Prune(dataDir, "/prod/app", 3, 150*time.Minute, start.Add(4*time.Hour)); err != nil {
+        t.Fatalf("Prune failed: %v", err)
+    }
+    versions, _ = List(dataDir, "/prod/app")
+    if len(versions) != 2 || versions[1].ID != "20240101T020000.000000000Z" {
+        t.Errorf("Prune kept %+v; want the 2 versions within max age", versions)
+    }
+}
+
+func TestListMissing(t *testing.T) {
+    versions, err := List(t.TempDir(), "/missing")
+    if err != nil || len(versions) != 0 {
+        t.Errorf("List of missing state = %v, %v; want none", versions, err)
+    }
+}
+
+func TestSaveChange(t *testing.T) {
+    dataDir := t.TempDir()
+    statefilePath := filepath.Join(dataDir, "states", "app")
+    os.MkdirAll(filepath.Dir(statefilePath), 0755)
+    os.WriteFile(statefilePath, []byte("a"), 0644)
+
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    change := Change{Operation: "rm aws_instance.web", Operator: "alice", Reason: "decommissioned"}
+    if err := SaveChange(dataDir, "/app", statefilePath, start, change); err != nil {
+        t.Fatalf("SaveChange failed: %v", err)
+    }
+    Save(dataDir, "/app", statefilePath, start.Add(time.Hour))
+
+    versions, _ := List(dataDir, "/app")
+    if len(versions) != 2 || versions[0].Change != nil || versions[1].Change == nil || *versions[1].Change != change {
+        t.Fatalf("List returned %+v; want the older version with its change", versions)
+    }
+    Prune(dataDir, "/app", 1, 0, start.Add(2*time.Hour))
+    if _, err := os.Stat(filepath.Join(Dir(dataDir, "/app"), versions[1].ID+changeSuffix)); !os.IsNotExist(err) {
+        t.Errorf("Prune kept the change of a removed version: %v", err)
+    }
+}
@@ -0,0 +1,308 @@
+package index
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// FileName is the index's file in DATA_DIR
+const FileName = "index.db"
+
+var (
+    statesBucket = []byte("states")
+    metaBucket   = []byte("meta")
+    versionKey   = []byte("version")
+)
+
+// formatVersion changes whenever what the index records about states does, so
+// indexes written before are read afresh
+const formatVersion = "2"
+
+// ErrNotIndexed is returned for storage roots outside the index's data directory
+var ErrNotIndexed = errors.New("storage root is not indexed")
+
+// ErrInUse is returned by Open when another process, such as a running server, holds the index
+var ErrInUse = errors.New("index is in use by another process")
+
+// Summary is what the index records about a state
+type Summary struct {
+    Path             string    `json:"path"`
+    Size             int64     `json:"size"`
+    Modified         time.Time `json:"modified"`
+    Serial           int64     `json:"serial"`
+    Lineage          string    `json:"lineage,omitempty"`
+    TerraformVersion string    `json:"terraform_version,omitempty"`
+    // Resources counts managed resource instances
+    Resources        int       `json:"resources"`
+    ResourceTypes    []string  `json:"resource_types,omitempty"`
+    Providers        []string  `json:"providers,omitempty"`
+    Outputs          []string  `json:"outputs,omitempty"`
+    Locked           bool      `json:"locked"`
+}
+
+// Index is an embedded database of state summaries for every storage root
+// (DATA_DIR and each tenant's) under a data directory, keyed by root and state path
+type Index struct {
+    db      *bolt.DB
+    dataDir string
+    // stale is set when the index was written in an older format, so the next Sync reads every state afresh
+    stale bool
+}
+
+// Open opens the index of dataDir, creating it when missing
+func Open(dataDir string) (*Index, error) {
+    db, err := bolt.Open(filepath.Join(dataDir, FileName), 0644, &bolt.Options{Timeout: time.Second})
+    if err == bolt.ErrTimeout {
+        return nil, ErrInUse
+    } else if err != nil {
+        return nil, err
+    }
+    ix := &Index{db: db, dataDir: dataDir}
+    err = db.Update(func(tx *bolt.Tx) error {
+        ix.stale = tx.Bucket(statesBucket) != nil && version(tx) != formatVersion
+        for _, name := range [][]byte{statesBucket, termsBucket, postingsBucket, metaBucket} {
+            if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return ix, nil
+}
+
+// version returns the format the index was last synced in, "" for indexes
+// older than versioning
+func version(tx *bolt.Tx) string {
+    if meta := tx.Bucket(metaBucket); meta != nil {
+        return string(meta.Get(versionKey))
+    }
+    return ""
+}
+
+// Close closes the index's database
+func (ix *Index) Close() error {
+    return ix.db.Close()
+}
+
+var active atomic.Pointer[Index]
+
+// Activate makes ix the index kept up to date by Refresh and used for listings,
+// nil disables indexing
+func Activate(ix *Index) {
+    active.Store(ix)
+}
+
+// Active returns the active index, nil when indexing is disabled
+func Active() *Index {
+    return active.Load()
+}
+
+// Refresh updates the active index after the state or lock file at file
+// changed. Failures are only logged, as the drift check repairs them.
+func Refresh(file string) {
+    ix := Active()
+    if ix == nil {
+        return
+    }
+    if err := ix.Refresh(file); err != nil {
+        slog.Error("Error updating index", "file", file, "error", err)
+    }
+}
+
+// Refresh re-reads the state that the state or lock file at file belongs to,
+// removing it from the index when the state no longer exists
+func (ix *Index) Refresh(file string) error {
+    root, statePath, ok := ix.locate(file)
+    if !ok {
+        return fmt.Errorf("%s is not a state or lock file under %s", file, ix.dataDir)
+    }
+    summary, data, err := read(filepath.Join(ix.dataDir, root), statePath)
+    if err != nil {
+        return err
+    }
+    return ix.db.Update(func(tx *bolt.Tx) error {
+        if err := put(tx.Bucket(statesBucket), root, statePath, summary); err != nil {
+            return err
+        }
+        return putTerms(tx, root, statePath, data)
+    })
+}
+
+// List returns the summaries of the states stored under root (DATA_DIR or a
+// tenant's root) whose path starts with prefix, sorted by path
+func (ix *Index) List(root, prefix string) ([]Summary, error) {
+    rootKey, ok := ix.rootKey(root)
+    if !ok {
+        return nil, ErrNotIndexed
+    }
+    seek := key(rootKey, strings.TrimPrefix(prefix, "/"))
+    var summaries []Summary
+    err := ix.db.View(func(tx *bolt.Tx) error {
+        c := tx.Bucket(statesBucket).Cursor()
+        for k, v := c.Seek(seek); k != nil && bytes.HasPrefix(k, seek); k, v = c.Next() {
+            var summary Summary
+            if err := json.Unmarshal(v, &summary); err != nil {
+                return err
+            }
+            summaries = append(summaries, summary)
+        }
+        return nil
+    })
+    return summaries, err
+}
+
+// Read summarizes the state at statePath under root from disk, nil when it doesn't exist
+func Read(root, statePath string) (*Summary, error) {
+    summary, _, err := read(root, statePath)
+    return summary, err
+}
+
+// read summarizes the state at statePath under root, also returning its contents
+func read(root, statePath string) (*Summary, []byte, error) {
+    statePath = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+statePath)), "/")
+    stateFile := filepath.Join(root, "states", filepath.FromSlash(statePath))
+    info, err := os.Stat(stateFile)
+    if os.IsNotExist(err) {
+        return nil, nil, nil
+    } else if err != nil {
+        return nil, nil, err
+    }
+    data, err := os.ReadFile(stateFile)
+    if os.IsNotExist(err) {
+        return nil, nil, nil
+    } else if err != nil {
+        return nil, nil, err
+    }
+    summary := Summarize(data)
+    summary.Path = statePath
+    summary.Size = info.Size()
+    summary.Modified = info.ModTime().UTC()
+    if _, err := os.Stat(filepath.Join(root, "locks", filepath.FromSlash(statePath))); err == nil {
+        summary.Locked = true
+    }
+    return summary, data, nil
+}
+
+// Summarize parses a state's serial, lineage, resources and outputs, counting
+// managed resource instances like a state's summary does. States that aren't
+// valid JSON get an empty summary.
+func Summarize(data []byte) *Summary {
+    var state struct {
+        Serial           int64  `json:"serial"`
+        Lineage          string `json:"lineage"`
+        TerraformVersion string `json:"terraform_version"`
+        Resources        []struct {
+            Mode      string            `json:"mode"`
+            Type      string            `json:"type"`
+            Provider  string            `json:"provider"`
+            Instances []json.RawMessage `json:"instances"`
+        } `json:"resources"`
+        Outputs map[string]json.RawMessage `json:"outputs"`
+    }
+    summary := &Summary{}
+    if json.Unmarshal(data, &state) != nil {
+        return summary
+    }
+    summary.Serial = state.Serial
+    summary.Lineage = state.Lineage
+    summary.TerraformVersion = state.TerraformVersion
+    types, providers := map[string]bool{}, map[string]bool{}
+    for _, resource := range state.Resources {
+        types[resource.Type] = true
+        providers[ProviderName(resource.Provider)] = true
+        if resource.Mode != "data" {
+            summary.Resources += len(resource.Instances)
+        }
+    }
+    summary.ResourceTypes = sortedKeys(types)
+    summary.Providers = sortedKeys(providers)
+    for name := range state.Outputs {
+        summary.Outputs = append(summary.Outputs, name)
+    }
+    sort.Strings(summary.Outputs)
+    return summary
+}
+
+// ProviderName turns a resource's provider such as
+// provider["registry.terraform.io/hashicorp/aws"].east into registry.terraform.io/hashicorp/aws
+func ProviderName(provider string) string {
+    if start := strings.Index(provider, `["`); start >= 0 {
+        if end := strings.Index(provider[start:], `"]`); end >= 0 {
+            return provider[start+2 : start+end]
+        }
+    }
+    return provider
+}
+
+func sortedKeys(set map[string]bool) []string {
+    var keys []string
+    for k := range set {
+        if k != "" {
+            keys = append(keys, k)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// locate splits a state or lock file under the data directory into its
+// root's key and its state path
+func (ix *Index) locate(file string) (string, string, bool) {
+    rel, err := filepath.Rel(ix.dataDir, file)
+    if err != nil {
+        return "", "", false
+    }
+    parts := strings.Split(filepath.ToSlash(rel), "/")
+    var root string
+    if len(parts) > 2 && parts[0] == "tenants" {
+        root, parts = "tenants/"+parts[1], parts[2:]
+    }
+    if len(parts) < 2 || (parts[0] != "states" && parts[0] != "locks") {
+        return "", "", false
+    }
+    return root, strings.Join(parts[1:], "/"), true
+}
+
+// rootKey returns the key of a storage root: "" for the data directory
+// itself and tenants/<name> for a tenant's
+func (ix *Index) rootKey(root string) (string, bool) {
+    rel, err := filepath.Rel(ix.dataDir, root)
+    if err != nil || strings.HasPrefix(rel, "..") {
+        return "", false
+    }
+    if rel == "." {
+        return "", true
+    }
+    return filepath.ToSlash(rel), true
+}
+
+func key(rootKey, statePath string) []byte {
+    return []byte(rootKey + "\x00" + statePath)
+}
+
+// put stores summary, or removes the state when it's nil
+func put(bucket *bolt.Bucket, rootKey, statePath string, summary *Summary) error {
+    if summary == nil {
+        return bucket.Delete(key(rootKey, statePath))
+    }
+    data, err := json.Marshal(summary)
+    if err != nil {
+        return err
+    }
+    return bucket.Put(key(rootKey, statePath), data)
+}
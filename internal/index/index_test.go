@@ -0,0 +1,118 @@
+package index
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+const testState = `{
+  "version": 4,
+  "terraform_version": "1.9.0",
+  "serial": 7,
+  "lineage": "abc",
+  "outputs": {"vpc_id": {"value": "vpc-1"}, "cidr": {"value": "10.0.0.0/16"}},
+  "resources": [
+    {"mode": "managed", "type": "aws_vpc", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "instances": [{}]},
+    {"mode": "managed", "type": "aws_subnet", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"].east", "instances": [{"index_key": 0}, {"index_key": 1}]},
+    {"mode": "data", "type": "aws_ami", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "instances": [{}]}
+  ]
+}`
+
+// writeFile creates a file under dataDir, along with its directories
+func writeFile(t *testing.T, dataDir, path, data string) string {
+    t.Helper()
+    file := filepath.Join(dataDir, filepath.FromSlash(path))
+    os.MkdirAll(filepath.Dir(file), 0755)
+    if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+    return file
+}
+
+func openTemp(t *testing.T) (*Index, string) {
+    t.Helper()
+    dataDir, err := ioutil.TempDir("", "index")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    ix, err := Open(dataDir)
+    if err != nil {
+        t.Fatalf("Open failed: %v", err)
+    }
+    t.Cleanup(func() {
+        ix.Close()
+        os.RemoveAll(dataDir)
+    })
+    return ix, dataDir
+}
+
+func TestSummarize(t *testing.T) {
+    summary := Summarize([]byte(testState))
+    expected := &Summary{
+        Serial:           7,
+        Lineage:          "abc",
+        TerraformVersion: "1.9.0",
+        Resources:        3,
+        ResourceTypes:    []string{"aws_ami", "aws_subnet", "aws_vpc"},
+        Providers:        []string{"registry.terraform.io/hashicorp/aws"},
+        Outputs:          []string{"cidr", "vpc_id"},
+    }
+    if !reflect.DeepEqual(summary, expected) {
+        t.Errorf("Summarize = %+v; want %+v", summary, expected)
+    }
+    if summary := Summarize([]byte("not json")); !reflect.DeepEqual(summary, &Summary{}) {
+        t.Errorf("Summarize of invalid JSON = %+v; want an empty summary", summary)
+    }
+}
+
+func TestRefresh(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    stateFile := writeFile(t, dataDir, "states/prod/app", testState)
+    tenantFile := writeFile(t, dataDir, "tenants/payments/states/prod/app", `{"serial": 1}`)
+    for _, file := range []string{stateFile, tenantFile} {
+        if err := ix.Refresh(file); err != nil {
+            t.Fatalf("Refresh failed: %v", err)
+        }
+    }
+
+    summaries, err := ix.List(dataDir, "prod/")
+    if err != nil {
+        t.Fatalf("List failed: %v", err)
+    }
+    if len(summaries) != 1 || summaries[0].Path != "prod/app" || summaries[0].Serial != 7 || summaries[0].Locked {
+        t.Fatalf("List = %+v; want prod/app at serial 7, unlocked", summaries)
+    }
+    summaries, err = ix.List(filepath.Join(dataDir, "tenants", "payments"), "")
+    if err != nil || len(summaries) != 1 || summaries[0].Serial != 1 {
+        t.Errorf("Tenant List = %+v, %v; want its own prod/app", summaries, err)
+    }
+
+    lockFile := writeFile(t, dataDir, "locks/prod/app", `{"ID": "abc"}`)
+    ix.Refresh(lockFile)
+    if summaries, _ := ix.List(dataDir, ""); len(summaries) != 1 || !summaries[0].Locked {
+        t.Errorf("List after locking = %+v; want prod/app locked", summaries)
+    }
+
+    os.Remove(stateFile)
+    ix.Refresh(stateFile)
+    if summaries, _ := ix.List(dataDir, ""); len(summaries) != 0 {
+        t.Errorf("List after deleting = %+v; want nothing", summaries)
+    }
+
+    if err := ix.Refresh(filepath.Join(dataDir, "history", "prod", "app")); err == nil {
+        t.Errorf("Refresh accepted a file that isn't a state or lock")
+    }
+    if _, err := ix.List(os.TempDir(), ""); err != ErrNotIndexed {
+        t.Errorf("List outside the data directory = %v; want ErrNotIndexed", err)
+    }
+}
+
+func TestOpenInUse(t *testing.T) {
+    _, dataDir := openTemp(t)
+    if _, err := Open(dataDir); err != ErrInUse {
+        t.Errorf("Second Open = %v; want ErrInUse", err)
+    }
+}
@@ -0,0 +1,225 @@
+package index
+
+import (
+    "bytes"
+    "encoding/json"
+    "sort"
+    "strconv"
+    "strings"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+var (
+    // termsBucket maps term\x00root\x00path\x00address to nothing, one key per resource instance a term matches
+    termsBucket = []byte("terms")
+    // postingsBucket maps root\x00path to the term keys written for the state, so they can be replaced
+    postingsBucket = []byte("postings")
+)
+
+// maxTermValue is the longest attribute value indexed, longer ones such as policy documents aren't searchable
+const maxTermValue = 1024
+
+// Query selects resource instances by their type, name and a top-level attribute's value
+type Query struct {
+    Type      string
+    Name      string
+    Attribute string
+    Value     string
+    // Limit caps the matches returned, 0 for no limit
+    Limit int
+}
+
+// Match is a resource instance found by Search
+type Match struct {
+    Path    string `json:"path"`
+    Address string `json:"address"`
+}
+
+// typeTerm, nameTerm and attributeTerm are what a resource instance is indexed under
+func typeTerm(resourceType string) string {
+    return "type:" + resourceType
+}
+
+func nameTerm(name string) string {
+    return "name:" + name
+}
+
+func attributeTerm(name, value string) string {
+    return "attr:" + name + "=" + value
+}
+
+// Address formats a resource instance's address the way terraform does,
+// e.g. module.vpc.aws_subnet.private[0]
+func Address(module, mode, resourceType, name string, key interface{}) string {
+    var b strings.Builder
+    if module != "" {
+        b.WriteString(module + ".")
+    }
+    if mode == "data" {
+        b.WriteString("data.")
+    }
+    b.WriteString(resourceType + "." + name)
+    switch key := key.(type) {
+    case json.Number:
+        b.WriteString("[" + key.String() + "]")
+    case string:
+        b.WriteString("[" + strconv.Quote(key) + "]")
+    }
+    return b.String()
+}
+
+// instanceTerms returns the terms of each managed resource instance in a state,
+// keyed by address. Sensitive attributes aren't indexed, nor are values that
+// aren't strings, numbers or booleans.
+func instanceTerms(data []byte) map[string][]string {
+    var state struct {
+        Resources []struct {
+            Module    string `json:"module"`
+            Mode      string `json:"mode"`
+            Type      string `json:"type"`
+            Name      string `json:"name"`
+            Instances []struct {
+                IndexKey            interface{}                `json:"index_key"`
+                Attributes          map[string]interface{}     `json:"attributes"`
+                SensitiveAttributes [][]map[string]interface{} `json:"sensitive_attributes"`
+            } `json:"instances"`
+        } `json:"resources"`
+    }
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if decoder.Decode(&state) != nil {
+        return nil
+    }
+    terms := map[string][]string{}
+    for _, resource := range state.Resources {
+        if resource.Mode == "data" {
+            continue
+        }
+        for _, instance := range resource.Instances {
+            sensitive := map[string]bool{}
+            for _, path := range instance.SensitiveAttributes {
+                if len(path) > 0 {
+                    if name, ok := path[0]["value"].(string); ok {
+                        sensitive[name] = true
+                    }
+                }
+            }
+            indexed := []string{typeTerm(resource.Type), nameTerm(resource.Name)}
+            for name, value := range instance.Attributes {
+                var s string
+                switch value := value.(type) {
+                case string:
+                    s = value
+                case json.Number:
+                    s = value.String()
+                case bool:
+                    s = strconv.FormatBool(value)
+                default:
+                    continue
+                }
+                if sensitive[name] || s == "" || len(s) > maxTermValue || strings.Contains(s, "\x00") {
+                    continue
+                }
+                indexed = append(indexed, attributeTerm(name, s))
+            }
+            address := Address(resource.Module, resource.Mode, resource.Type, resource.Name, instance.IndexKey)
+            terms[address] = append(terms[address], indexed...)
+        }
+    }
+    return terms
+}
+
+// putTerms replaces the terms indexed for a state with those of data, or
+// removes them when data is nil
+func putTerms(tx *bolt.Tx, rootKey, statePath string, data []byte) error {
+    terms, postings := tx.Bucket(termsBucket), tx.Bucket(postingsBucket)
+    stateKey := key(rootKey, statePath)
+    if old := postings.Get(stateKey); old != nil {
+        var keys []string
+        if err := json.Unmarshal(old, &keys); err == nil {
+            for _, k := range keys {
+                if err := terms.Delete([]byte(k)); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    if data == nil {
+        return postings.Delete(stateKey)
+    }
+    var keys []string
+    for address, indexed := range instanceTerms(data) {
+        for _, term := range indexed {
+            k := term + "\x00" + string(stateKey) + "\x00" + address
+            if err := terms.Put([]byte(k), nil); err != nil {
+                return err
+            }
+            keys = append(keys, k)
+        }
+    }
+    encoded, err := json.Marshal(keys)
+    if err != nil {
+        return err
+    }
+    return postings.Put(stateKey, encoded)
+}
+
+// Search returns the resource instances under root (DATA_DIR or a tenant's
+// root) matching every criterion of query, sorted by path and address
+func (ix *Index) Search(root string, query Query) ([]Match, error) {
+    rootKey, ok := ix.rootKey(root)
+    if !ok {
+        return nil, ErrNotIndexed
+    }
+    var terms []string
+    if query.Type != "" {
+        terms = append(terms, typeTerm(query.Type))
+    }
+    if query.Name != "" {
+        terms = append(terms, nameTerm(query.Name))
+    }
+    if query.Attribute != "" {
+        terms = append(terms, attributeTerm(query.Attribute, query.Value))
+    }
+    if len(terms) == 0 {
+        return []Match{}, nil
+    }
+
+    var found map[Match]bool
+    err := ix.db.View(func(tx *bolt.Tx) error {
+        c := tx.Bucket(termsBucket).Cursor()
+        for _, term := range terms {
+            seek := []byte(term + "\x00" + rootKey + "\x00")
+            matched := map[Match]bool{}
+            for k, _ := c.Seek(seek); k != nil && bytes.HasPrefix(k, seek); k, _ = c.Next() {
+                statePath, address, _ := strings.Cut(string(k[len(seek):]), "\x00")
+                match := Match{Path: statePath, Address: address}
+                if found == nil || found[match] {
+                    matched[match] = true
+                }
+            }
+            if found = matched; len(found) == 0 {
+                break
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    matches := make([]Match, 0, len(found))
+    for match := range found {
+        matches = append(matches, match)
+    }
+    sort.Slice(matches, func(i, j int) bool {
+        if matches[i].Path != matches[j].Path {
+            return matches[i].Path < matches[j].Path
+        }
+        return matches[i].Address < matches[j].Address
+    })
+    if query.Limit > 0 && len(matches) > query.Limit {
+        matches = matches[:query.Limit]
+    }
+    return matches, nil
+}
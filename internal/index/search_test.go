@@ -0,0 +1,136 @@
+package index
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+const searchState = `{
+  "resources": [
+    {"mode": "managed", "type": "aws_security_group", "name": "web", "instances": [{"attributes": {"id": "sg-1", "name": "web", "ingress": [{"from_port": 443}]}}]},
+    {"module": "module.db", "mode": "managed", "type": "aws_db_instance", "name": "main", "instances": [{"index_key": "primary", "attributes": {"id": "db-1", "port": 5432, "password": "hunter2"},
+      "sensitive_attributes": [[{"type": "get_attr", "value": "password"}]]}]},
+    {"mode": "data", "type": "aws_security_group", "name": "default", "instances": [{"attributes": {"id": "sg-0"}}]}
+  ]
+}`
+
+func TestSearch(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    stateFile := writeFile(t, dataDir, "states/prod/app", searchState)
+    otherFile := writeFile(t, dataDir, "states/staging/app", searchState)
+    tenantFile := writeFile(t, dataDir, "tenants/payments/states/app", searchState)
+    for _, file := range []string{stateFile, otherFile, tenantFile} {
+        if err := ix.Refresh(file); err != nil {
+            t.Fatalf("Refresh failed: %v", err)
+        }
+    }
+
+    tests := []struct {
+        query   Query
+        matches []Match
+    }{
+        {Query{Attribute: "id", Value: "sg-1"}, []Match{{"prod/app", "aws_security_group.web"}, {"staging/app", "aws_security_group.web"}}},
+        {Query{Attribute: "port", Value: "5432", Limit: 1}, []Match{{"prod/app", `module.db.aws_db_instance.main["primary"]`}}},
+        {Query{Type: "aws_security_group", Name: "web"}, []Match{{"prod/app", "aws_security_group.web"}, {"staging/app", "aws_security_group.web"}}},
+        {Query{Type: "aws_db_instance", Name: "web"}, []Match{}},
+        // data sources, sensitive and nested attributes aren't indexed
+        {Query{Attribute: "id", Value: "sg-0"}, []Match{}},
+        {Query{Attribute: "password", Value: "hunter2"}, []Match{}},
+        {Query{Attribute: "ingress", Value: "443"}, []Match{}},
+    }
+    for _, test := range tests {
+        matches, err := ix.Search(dataDir, test.query)
+        if err != nil {
+            t.Fatalf("%+v: Search failed: %v", test.query, err)
+        }
+        if !reflect.DeepEqual(matches, test.matches) {
+            t.Errorf("%+v: Search = %v; want %v", test.query, matches, test.matches)
+        }
+    }
+
+    matches, _ := ix.Search(filepath.Join(dataDir, "tenants", "payments"), Query{Attribute: "id", Value: "sg-1"})
+    if !reflect.DeepEqual(matches, []Match{{"app", "aws_security_group.web"}}) {
+        t.Errorf("Tenant Search = %v; want only the tenant's state", matches)
+    }
+
+    // Rewritten and deleted states drop their old terms
+    writeFile(t, dataDir, "states/prod/app", `{"resources": [{"mode": "managed", "type": "aws_security_group", "name": "web", "instances": [{"attributes": {"id": "sg-2"}}]}]}`)
+    ix.Refresh(stateFile)
+    os.Remove(otherFile)
+    ix.Refresh(otherFile)
+    if matches, _ := ix.Search(dataDir, Query{Attribute: "id", Value: "sg-1"}); len(matches) != 0 {
+        t.Errorf("Search after changes = %v; want nothing", matches)
+    }
+    if matches, _ := ix.Search(dataDir, Query{Attribute: "id", Value: "sg-2"}); len(matches) != 1 {
+        t.Errorf("Search after rewrite = %v; want prod/app", matches)
+    }
+}
+
+func TestSearchAfterUpgrade(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    writeFile(t, dataDir, "states/prod/app", searchState)
+    if _, err := ix.Sync(); err != nil {
+        t.Fatalf("Sync failed: %v", err)
+    }
+    // An index from before search only has the states bucket
+    ix.Close()
+    db, err := bolt.Open(filepath.Join(dataDir, FileName), 0644, nil)
+    if err != nil {
+        t.Fatalf("Failed to open index: %v", err)
+    }
+    db.Update(func(tx *bolt.Tx) error {
+        tx.DeleteBucket(termsBucket)
+        tx.DeleteBucket(metaBucket)
+        return tx.DeleteBucket(postingsBucket)
+    })
+    db.Close()
+
+    ix, err = Open(dataDir)
+    if err != nil {
+        t.Fatalf("Open failed: %v", err)
+    }
+    defer ix.Close()
+    if drift, err := ix.Sync(); err != nil || drift != (Drift{Added: 1}) {
+        t.Errorf("Sync after upgrade = %+v, %v; want the state read afresh", drift, err)
+    }
+    if matches, _ := ix.Search(dataDir, Query{Attribute: "id", Value: "sg-1"}); len(matches) != 1 {
+        t.Errorf("Search after upgrade = %v; want prod/app", matches)
+    }
+}
+
+func TestSyncAfterFormatChange(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    writeFile(t, dataDir, "states/prod/app", testState)
+    if _, err := ix.Sync(); err != nil {
+        t.Fatalf("Sync failed: %v", err)
+    }
+    // An index from an older format has every bucket but no version
+    ix.Close()
+    db, err := bolt.Open(filepath.Join(dataDir, FileName), 0644, nil)
+    if err != nil {
+        t.Fatalf("Failed to open index: %v", err)
+    }
+    db.Update(func(tx *bolt.Tx) error {
+        return tx.DeleteBucket(metaBucket)
+    })
+    db.Close()
+
+    ix, err = Open(dataDir)
+    if err != nil {
+        t.Fatalf("Open failed: %v", err)
+    }
+    defer ix.Close()
+    if drift, err := ix.Sync(); err != nil || drift != (Drift{Added: 1}) {
+        t.Errorf("Sync after format change = %+v, %v; want the state read afresh", drift, err)
+    }
+    if summaries, _ := ix.List(dataDir, ""); len(summaries) != 1 || summaries[0].Resources != 3 {
+        t.Errorf("List after format change = %+v; want prod/app with 3 resource instances", summaries)
+    }
+    if drift, _ := ix.Sync(); drift.Total() != 0 {
+        t.Errorf("Second Sync = %+v; want no drift", drift)
+    }
+}
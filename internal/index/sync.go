@@ -0,0 +1,248 @@
+package index
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+// Drift counts the states the index disagreed with the files about
+type Drift struct {
+    Added   int `json:"added"`
+    Updated int `json:"updated"`
+    Removed int `json:"removed"`
+}
+
+// Total is the number of states that drifted
+func (d Drift) Total() int {
+    return d.Added + d.Updated + d.Removed
+}
+
+// Sync compares the index with the states and locks on disk, re-reading every
+// state whose file or lock changed behind its back and dropping states that
+// are gone
+func (ix *Index) Sync() (Drift, error) {
+    drift, err := ix.sync(ix.stale)
+    if err == nil {
+        ix.stale = false
+    }
+    return drift, err
+}
+
+// Watch syncs ix with the files every interval until ctx is done, picking up
+// changes made without going through the server, such as by the command line
+func Watch(ctx context.Context, ix *Index, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        drift, err := ix.Sync()
+        if err != nil {
+            slog.Error("Error syncing index", "error", err)
+        } else if drift.Total() > 0 {
+            slog.Info("Index was out of date with storage, updated it",
+                "added", drift.Added, "updated", drift.Updated, "removed", drift.Removed)
+        }
+    }
+}
+
+// Rebuild empties the index and reads every state afresh
+func (ix *Index) Rebuild() (Drift, error) {
+    return ix.sync(true)
+}
+
+// syncBatch is how many changed states sync writes per transaction, so writes
+// refreshing the index meanwhile are never held up for long
+const syncBatch = 100
+
+// change is a state sync found out of date, with a nil summary when it's gone
+type change struct {
+    rootKey, statePath string
+    summary            *Summary
+    data               []byte
+}
+
+// sync walks and reads the states outside of any transaction, as the index
+// has a single writer, then applies what changed in short batches
+func (ix *Index) sync(rebuild bool) (Drift, error) {
+    if rebuild {
+        err := ix.db.Update(func(tx *bolt.Tx) error {
+            for _, name := range [][]byte{statesBucket, termsBucket, postingsBucket} {
+                if err := tx.DeleteBucket(name); err != nil {
+                    return err
+                }
+                if _, err := tx.CreateBucket(name); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return Drift{}, err
+        }
+    }
+    indexed := map[string][]byte{}
+    err := ix.db.View(func(tx *bolt.Tx) error {
+        return tx.Bucket(statesBucket).ForEach(func(k, v []byte) error {
+            indexed[string(k)] = append([]byte(nil), v...)
+            return nil
+        })
+    })
+    if err != nil {
+        return Drift{}, err
+    }
+
+    var drift Drift
+    var batch []change
+    flush := func() error {
+        err := ix.apply(batch, indexed, &drift)
+        batch = batch[:0]
+        return err
+    }
+    seen := map[string]bool{}
+    roots, err := ix.roots()
+    if err != nil {
+        return drift, err
+    }
+    for _, rootKey := range roots {
+        root := filepath.Join(ix.dataDir, filepath.FromSlash(rootKey))
+        err := walkStates(root, func(statePath string, info fs.FileInfo) error {
+            k := string(key(rootKey, statePath))
+            seen[k] = true
+            _, lockErr := os.Stat(filepath.Join(root, "locks", filepath.FromSlash(statePath)))
+            var old Summary
+            if v, ok := indexed[k]; ok && json.Unmarshal(v, &old) == nil &&
+                old.Size == info.Size() && old.Modified.Equal(info.ModTime().UTC()) && old.Locked == (lockErr == nil) {
+                return nil
+            }
+            summary, data, err := read(root, statePath)
+            if err != nil || summary == nil {
+                return err
+            }
+            if batch = append(batch, change{rootKey, statePath, summary, data}); len(batch) == syncBatch {
+                return flush()
+            }
+            return nil
+        })
+        if err != nil {
+            return drift, err
+        }
+    }
+    for k := range indexed {
+        if seen[k] {
+            continue
+        }
+        rootKey, statePath, _ := strings.Cut(k, "\x00")
+        if batch = append(batch, change{rootKey: rootKey, statePath: statePath}); len(batch) == syncBatch {
+            if err := flush(); err != nil {
+                return drift, err
+            }
+        }
+    }
+    if err := flush(); err != nil {
+        return drift, err
+    }
+    err = ix.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(metaBucket).Put(versionKey, []byte(formatVersion))
+    })
+    return drift, err
+}
+
+// apply writes a batch of changes found by sync in one transaction. States
+// refreshed since sync looked at the index are skipped, as the index already
+// has them afresh.
+func (ix *Index) apply(batch []change, indexed map[string][]byte, drift *Drift) error {
+    if len(batch) == 0 {
+        return nil
+    }
+    var applied Drift
+    err := ix.db.Update(func(tx *bolt.Tx) error {
+        applied = Drift{}
+        bucket := tx.Bucket(statesBucket)
+        for _, c := range batch {
+            k := key(c.rootKey, c.statePath)
+            old, ok := indexed[string(k)]
+            if !bytes.Equal(bucket.Get(k), old) {
+                continue
+            }
+            if err := put(bucket, c.rootKey, c.statePath, c.summary); err != nil {
+                return err
+            }
+            if err := putTerms(tx, c.rootKey, c.statePath, c.data); err != nil {
+                return err
+            }
+            switch {
+            case c.summary == nil:
+                applied.Removed++
+            case ok:
+                applied.Updated++
+            default:
+                applied.Added++
+            }
+        }
+        return nil
+    })
+    if err == nil {
+        drift.Added += applied.Added
+        drift.Updated += applied.Updated
+        drift.Removed += applied.Removed
+    }
+    return err
+}
+
+// roots returns the keys of the data directory and every tenant's root
+func (ix *Index) roots() ([]string, error) {
+    roots := []string{""}
+    entries, err := os.ReadDir(filepath.Join(ix.dataDir, "tenants"))
+    if os.IsNotExist(err) {
+        return roots, nil
+    } else if err != nil {
+        return nil, err
+    }
+    for _, entry := range entries {
+        if entry.IsDir() {
+            roots = append(roots, "tenants/"+entry.Name())
+        }
+    }
+    return roots, nil
+}
+
+// walkStates calls fn for each state stored under root, skipping the
+// temporary files of uploads in progress
+func walkStates(root string, fn func(statePath string, info fs.FileInfo) error) error {
+    statesDir := filepath.Join(root, "states")
+    return filepath.WalkDir(statesDir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+            return nil
+        }
+        rel, err := filepath.Rel(statesDir, path)
+        if err != nil {
+            return err
+        }
+        info, err := d.Info()
+        if os.IsNotExist(err) {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        return fn(filepath.ToSlash(rel), info)
+    })
+}
@@ -0,0 +1,109 @@
+package index
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "testing"
+    "time"
+)
+
+func TestSync(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    kept := writeFile(t, dataDir, "states/prod/app", testState)
+    changed := writeFile(t, dataDir, "states/prod/db", `{"serial": 1}`)
+    removed := writeFile(t, dataDir, "states/prod/old", `{"serial": 1}`)
+    writeFile(t, dataDir, "states/prod/.tfstate-123", `{"serial": 1}`)
+
+    drift, err := ix.Sync()
+    if err != nil {
+        t.Fatalf("Sync failed: %v", err)
+    }
+    if drift != (Drift{Added: 3}) {
+        t.Errorf("First Sync = %+v; want 3 added", drift)
+    }
+
+    // Changes made while the index wasn't being kept up to date
+    writeFile(t, dataDir, "states/prod/db", `{"serial": 2, "lineage": "changed"}`)
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(changed, later, later)
+    os.Remove(removed)
+    writeFile(t, dataDir, "tenants/payments/states/app", `{"serial": 1}`)
+    writeFile(t, dataDir, "locks/prod/app", `{"ID": "abc"}`)
+
+    drift, err = ix.Sync()
+    if err != nil {
+        t.Fatalf("Sync failed: %v", err)
+    }
+    if drift != (Drift{Added: 1, Updated: 2, Removed: 1}) {
+        t.Errorf("Sync after changes = %+v; want 1 added, 2 updated, 1 removed", drift)
+    }
+    summaries, _ := ix.List(dataDir, "")
+    if len(summaries) != 2 || summaries[0].Path != "prod/app" || !summaries[0].Locked || summaries[1].Lineage != "changed" {
+        t.Errorf("List after Sync = %+v; want prod/app locked and prod/db changed", summaries)
+    }
+
+    if drift, _ := ix.Sync(); drift.Total() != 0 {
+        t.Errorf("Sync without changes = %+v; want no drift", drift)
+    }
+    if drift, err := ix.Rebuild(); err != nil || drift != (Drift{Added: 3}) {
+        t.Errorf("Rebuild = %+v, %v; want 3 added", drift, err)
+    }
+    if _, err := os.Stat(kept); err != nil {
+        t.Errorf("Sync touched a state: %v", err)
+    }
+}
+
+func TestSyncInBatches(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    for i := 0; i <= syncBatch; i++ {
+        writeFile(t, dataDir, fmt.Sprintf("states/prod/app%d", i), `{"serial": 1}`)
+    }
+    if drift, err := ix.Sync(); err != nil || drift != (Drift{Added: syncBatch + 1}) {
+        t.Errorf("Sync = %+v, %v; want %d added", drift, err, syncBatch+1)
+    }
+    if summaries, _ := ix.List(dataDir, ""); len(summaries) != syncBatch+1 {
+        t.Errorf("Listed %d states; want %d", len(summaries), syncBatch+1)
+    }
+}
+
+func TestSyncKeepsRefreshedStates(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    stateFile := writeFile(t, dataDir, "states/prod/app", `{"serial": 1}`)
+    // Sync read the state before the index knew it, then a write refreshed it
+    summary, data, err := read(dataDir, "prod/app")
+    if err != nil {
+        t.Fatalf("read failed: %v", err)
+    }
+    writeFile(t, dataDir, "states/prod/app", `{"serial": 2}`)
+    ix.Refresh(stateFile)
+
+    var drift Drift
+    batch := []change{{rootKey: "", statePath: "prod/app", summary: summary, data: data}}
+    if err := ix.apply(batch, map[string][]byte{}, &drift); err != nil || drift.Total() != 0 {
+        t.Errorf("apply = %+v, %v; want the refreshed state skipped", drift, err)
+    }
+    if summaries, _ := ix.List(dataDir, ""); len(summaries) != 1 || summaries[0].Serial != 2 {
+        t.Errorf("List = %+v; want the refreshed serial 2", summaries)
+    }
+}
+
+func TestWatch(t *testing.T) {
+    ix, dataDir := openTemp(t)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go Watch(ctx, ix, 10*time.Millisecond)
+
+    // A state written beside the server, e.g. by the command line
+    writeFile(t, dataDir, "states/prod/app", testState)
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        if summaries, _ := ix.List(dataDir, ""); len(summaries) == 1 {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("State written beside the index was not picked up")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
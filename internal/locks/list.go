@@ -0,0 +1,73 @@
+package locks
+
+import (
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/utils"
+)
+
+// Entry describes a held lock. Error is set instead of Lock when the lock file can't be parsed.
+type Entry struct {
+    Path     string    `json:"path"`
+    Lock     *LockInfo `json:"lock,omitempty"`
+    Acquired time.Time `json:"acquired"`
+    Error    string    `json:"error,omitempty"`
+}
+
+// List returns the locks held under dataDir on paths starting with prefix, sorted by path
+func List(dataDir, prefix string) ([]Entry, error) {
+    root := filepath.Join(dataDir, "locks")
+    prefix = strings.TrimPrefix(prefix, "/")
+    var entries []Entry
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if d.IsDir() {
+            return nil
+        }
+        rel, err := filepath.Rel(root, path)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if !strings.HasPrefix(rel, prefix) {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return err
+        }
+        entry := Entry{Path: rel, Acquired: info.ModTime().UTC()}
+        if data, err := os.ReadFile(path); err != nil {
+            entry.Error = err.Error()
+        } else if lockInfo, err := parseLockData(data); err != nil {
+            entry.Error = err.Error()
+        } else {
+            entry.Lock = &lockInfo
+        }
+        entries = append(entries, entry)
+        return nil
+    })
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+    return entries, err
+}
+
+// ForceUnlock removes the lock on statePath regardless of its ID
+func ForceUnlock(dataDir, statePath string) error {
+    lockfilePath, _ := utils.GetFilePaths("/locks/"+statePath, dataDir)
+    if err := os.Remove(lockfilePath); err != nil {
+        return err
+    }
+    index.Refresh(lockfilePath)
+    return nil
+}
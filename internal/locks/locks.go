@@ -2,10 +2,21 @@ package locks
 
 import (
     "encoding/json"
-    "log"
+    "log/slog"
     "net/http"
     "os"
+    "strings"
+    "time"
 
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+
+    "terraform-http-backend/internal/aliases"
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tombstones"
+    "terraform-http-backend/internal/tracing"
     "terraform-http-backend/internal/utils"
 )
 
@@ -22,98 +33,176 @@ type LockInfo struct {
 
 // HandleLocks processes lock-related HTTP requests
 func HandleLocks(w http.ResponseWriter, r *http.Request, dataDir string) {
+    r, handled := aliases.Apply(w, r, dataDir)
+    if handled {
+        return
+    }
     lockfilePath, lockDir := utils.GetFilePaths(r.URL.Path, dataDir)
+    _, statePath := utils.SplitPath(r.URL.Path)
+    ctx, span := tracing.Start(r.Context(), "locks "+r.Method, attribute.String("state.path", statePath))
+    defer span.End()
+    r = r.WithContext(ctx)
+    if !strings.HasSuffix(r.URL.Path, "/") && tombstones.Check(w, r, dataDir, statePath) {
+        return
+    }
     switch r.Method {
     case "LOCK", http.MethodPost, http.MethodPut:
-        acquireLock(w, r, lockfilePath, lockDir)
+        acquireLock(w, r, lockfilePath, lockDir, config.ForPath(statePath).LockTTL)
     case "UNLOCK", http.MethodDelete:
-        releaseLock(w, r, lockfilePath)
+        if r.URL.Query().Get("force") == "true" {
+            forceUnlock(w, r, lockfilePath)
+        } else {
+            releaseLock(w, r, lockfilePath)
+        }
+    case http.MethodGet:
+        if strings.HasSuffix(r.URL.Path, "/") {
+            listLocks(w, r, dataDir)
+        } else {
+            utils.MethodNotAllowed(w, r)
+        }
     default:
         utils.MethodNotAllowed(w, r)
     }
 }
 
-func acquireLock(w http.ResponseWriter, r *http.Request, lockfilePath, lockDir string) {
-    if lockExists(w, lockfilePath) {
+func acquireLock(w http.ResponseWriter, r *http.Request, lockfilePath, lockDir string, ttl time.Duration) {
+    if lockExists(w, r, lockfilePath, ttl) {
         return
     }
-    lockInfo, err := decodeLockInfo(r)
-    if err != nil {
-        utils.HTTPError(w, "Error decoding lock info", err)
+    lockInfo, err := decodeLockInfo(w, r)
+    if utils.BodyTooLarge(w, r, err) {
+        return
+    } else if err != nil {
+        utils.HTTPError(w, r, "Error decoding lock info", err)
         return
     }
-    writeLock(w, lockfilePath, lockDir, lockInfo)
+    writeLock(w, r, lockfilePath, lockDir, lockInfo)
 }
 
 func releaseLock(w http.ResponseWriter, r *http.Request, lockfilePath string) {
+    _, span := tracing.Start(r.Context(), "storage.read_lock")
     lockData, err := os.ReadFile(lockfilePath)
+    tracing.End(span, err)
     if err != nil {
         utils.HandleFileError(w, r, lockfilePath, err)
         return
     }
     existingLockInfo, err := parseLockData(lockData)
     if err != nil {
-        utils.HTTPError(w, "Error unmarshaling lock data", err)
+        utils.HTTPError(w, r, "Error unmarshaling lock data", err)
         return
     }
-    unlockInfo, err := decodeLockInfo(r)
-    if err != nil {
-        utils.HTTPError(w, "Error decoding unlock info", err)
+    unlockInfo, err := decodeLockInfo(w, r)
+    if utils.BodyTooLarge(w, r, err) {
+        return
+    } else if err != nil {
+        utils.HTTPError(w, r, "Error decoding unlock info", err)
         return
     }
     if unlockInfo.ID != existingLockInfo.ID {
+        trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("lock.conflict", true))
         httpConflict(w, lockData)
         return
     }
-    removeLock(w, lockfilePath, unlockInfo)
+    removeLock(w, r, lockfilePath, unlockInfo)
+}
+
+func listLocks(w http.ResponseWriter, r *http.Request, dataDir string) {
+    entries, err := List(dataDir, r.URL.Query().Get("prefix"))
+    if err != nil {
+        utils.HTTPError(w, r, "Error listing locks", err)
+        return
+    }
+    utils.WriteJSON(w, map[string]interface{}{"locks": entries})
 }
 
-func lockExists(w http.ResponseWriter, lockfilePath string) bool {
-    if _, err := os.Stat(lockfilePath); err == nil {
+// forceUnlock removes a lock regardless of its ID, which only admins may do
+func forceUnlock(w http.ResponseWriter, r *http.Request, lockfilePath string) {
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && !principal.Admin {
+        http.Error(w, "Forbidden", http.StatusForbidden)
+        slog.WarnContext(r.Context(), "Forbidden force unlock", "path", lockfilePath, "principal", principal.Username)
+        return
+    }
+    if err := os.Remove(lockfilePath); err != nil {
+        utils.HandleFileError(w, r, lockfilePath, err)
+        return
+    }
+    index.Refresh(lockfilePath)
+    w.WriteHeader(http.StatusOK)
+    slog.InfoContext(r.Context(), "Lock force released", "path", lockfilePath)
+}
+
+// lockExists writes the held lock to w, unless it is older than ttl (when set),
+// in which case the expired lock is removed
+func lockExists(w http.ResponseWriter, r *http.Request, lockfilePath string, ttl time.Duration) bool {
+    if info, err := os.Stat(lockfilePath); err == nil {
+        if ttl > 0 && time.Since(info.ModTime()) > ttl {
+            if err := os.Remove(lockfilePath); err != nil && !os.IsNotExist(err) {
+                utils.HTTPError(w, r, "Error removing expired lock file", err)
+                return true
+            }
+            index.Refresh(lockfilePath)
+            slog.InfoContext(r.Context(), "Removed expired lock", "path", lockfilePath, "ttl", ttl.String())
+            return false
+        }
         lockData, _ := os.ReadFile(lockfilePath)
+        trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("lock.conflict", true))
         w.Header().Set("Content-Type", "application/json")
         w.WriteHeader(http.StatusLocked)
         w.Write(lockData)
-        log.Printf("Lock already held for %s", lockfilePath)
+        slog.InfoContext(r.Context(), "Lock already held", "path", lockfilePath)
         return true
     } else if !os.IsNotExist(err) {
-        utils.HTTPError(w, "Error checking lock file", err)
+        utils.HTTPError(w, r, "Error checking lock file", err)
         return true
     }
     return false
 }
 
-func decodeLockInfo(r *http.Request) (LockInfo, error) {
+// decodeLockInfo reads the lock info in the request body, of at most MAX_LOCK_SIZE bytes
+func decodeLockInfo(w http.ResponseWriter, r *http.Request) (LockInfo, error) {
     var lockInfo LockInfo
-    err := json.NewDecoder(r.Body).Decode(&lockInfo)
+    body := r.Body
+    if max := config.GetEnvSize("MAX_LOCK_SIZE", 64<<10); max > 0 {
+        body = http.MaxBytesReader(w, r.Body, max)
+    }
+    err := json.NewDecoder(body).Decode(&lockInfo)
     return lockInfo, err
 }
 
-func writeLock(w http.ResponseWriter, lockfilePath, lockDir string, lockInfo LockInfo) {
+func writeLock(w http.ResponseWriter, r *http.Request, lockfilePath, lockDir string, lockInfo LockInfo) {
     if err := os.MkdirAll(lockDir, 0755); err != nil {
-        utils.HTTPError(w, "Error creating lock directory", err)
+        utils.HTTPError(w, r, "Error creating lock directory", err)
         return
     }
     lockData, err := json.Marshal(lockInfo)
     if err != nil {
-        utils.HTTPError(w, "Error marshaling lock info", err)
+        utils.HTTPError(w, r, "Error marshaling lock info", err)
         return
     }
-    if err := os.WriteFile(lockfilePath, lockData, 0644); err != nil {
-        utils.HTTPError(w, "Error writing lock file", err)
+    _, span := tracing.Start(r.Context(), "storage.write_lock")
+    err = os.WriteFile(lockfilePath, lockData, 0644)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error writing lock file", err)
         return
     }
+    index.Refresh(lockfilePath)
     w.WriteHeader(http.StatusOK)
-    log.Printf("Lock acquired for %s by %s", lockfilePath, lockInfo.Who)
+    slog.InfoContext(r.Context(), "Lock acquired", "path", lockfilePath, "who", lockInfo.Who)
 }
 
-func removeLock(w http.ResponseWriter, lockfilePath string, unlockInfo LockInfo) {
-    if err := os.Remove(lockfilePath); err != nil {
-        utils.HTTPError(w, "Error removing lock file", err)
+func removeLock(w http.ResponseWriter, r *http.Request, lockfilePath string, unlockInfo LockInfo) {
+    _, span := tracing.Start(r.Context(), "storage.remove_lock")
+    err := os.Remove(lockfilePath)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error removing lock file", err)
         return
     }
+    index.Refresh(lockfilePath)
     w.WriteHeader(http.StatusOK)
-    log.Printf("Lock released for %s by %s", lockfilePath, unlockInfo.Who)
+    slog.InfoContext(r.Context(), "Lock released", "path", lockfilePath, "who", unlockInfo.Who)
 }
 
 func parseLockData(lockData []byte) (LockInfo, error) {
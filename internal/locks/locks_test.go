@@ -9,6 +9,11 @@ import (
     "os"
     "path/filepath"
     "testing"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/aliases"
+    "terraform-http-backend/internal/tombstones"
 )
 
 func TestHandleLocksAcquire(t *testing.T) {
@@ -240,4 +245,130 @@ func TestHandleLocksMethodNotAllowed(t *testing.T) {
     if status := rr.Code; status != http.StatusMethodNotAllowed {
         t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
     }
-}
\ No newline at end of file
+}
+
+func TestHandleLocksAcquireExpired(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "locktest")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.Setenv("LOCK_TTL", "1h")
+    defer os.Unsetenv("LOCK_TTL")
+
+    lockFilePath := filepath.Join(tempDir, "/test-lock")
+    err = ioutil.WriteFile(lockFilePath, []byte(`{"ID":"stale-lock-id"}`), 0644)
+    if err != nil {
+        t.Fatalf("Failed to write lock file: %v", err)
+    }
+    stale := time.Now().Add(-2 * time.Hour)
+    if err := os.Chtimes(lockFilePath, stale, stale); err != nil {
+        t.Fatalf("Failed to age lock file: %v", err)
+    }
+
+    lockData, err := json.Marshal(LockInfo{ID: "new-lock-id", Who: "tester"})
+    if err != nil {
+        t.Fatalf("Failed to marshal lock info: %v", err)
+    }
+    req := httptest.NewRequest("LOCK", "/test-lock", bytes.NewReader(lockData))
+    rr := httptest.NewRecorder()
+
+    HandleLocks(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    data, err := ioutil.ReadFile(lockFilePath)
+    if err != nil {
+        t.Fatalf("Failed to read lock file: %v", err)
+    }
+    var storedLockInfo LockInfo
+    if err := json.Unmarshal(data, &storedLockInfo); err != nil || storedLockInfo.ID != "new-lock-id" {
+        t.Errorf("Expired lock was not replaced: got %s", string(data))
+    }
+}
+
+func TestHandleLocksForceUnlock(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "locktest")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+
+    lockFilePath := filepath.Join(tempDir, "locks", "test-lock")
+    os.MkdirAll(filepath.Dir(lockFilePath), 0755)
+    if err := ioutil.WriteFile(lockFilePath, []byte(`{"ID":"other-lock-id"}`), 0644); err != nil {
+        t.Fatalf("Failed to write lock file: %v", err)
+    }
+
+    req := httptest.NewRequest("UNLOCK", "/locks/test-lock?force=true", nil)
+    req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "ci", Role: auth.RoleReadWrite}))
+    rr := httptest.NewRecorder()
+    HandleLocks(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusForbidden {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/locks/", nil)
+    rr = httptest.NewRecorder()
+    HandleLocks(rr, req, tempDir)
+    if !bytes.Contains(rr.Body.Bytes(), []byte(`"ID":"other-lock-id"`)) {
+        t.Errorf("Listing doesn't contain the lock: %s", rr.Body.String())
+    }
+
+    req = httptest.NewRequest("UNLOCK", "/locks/test-lock?force=true", nil)
+    req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "admin", Role: auth.RoleReadWrite, Admin: true}))
+    rr = httptest.NewRecorder()
+    HandleLocks(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if _, err := os.Stat(lockFilePath); !os.IsNotExist(err) {
+        t.Errorf("Lock file still exists after force unlock")
+    }
+}
+
+func TestHandleLocksMovedState(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "locktest")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    tombstones.Write(tempDir, "/prod/old", "/prod/new", time.Now(), time.Hour)
+
+    req := httptest.NewRequest("LOCK", "/locks/prod/old", bytes.NewReader([]byte(`{"ID": "abc"}`)))
+    rr := httptest.NewRecorder()
+    HandleLocks(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusGone {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusGone)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "locks", "prod", "old")); !os.IsNotExist(err) {
+        t.Errorf("Lock was acquired on a moved state")
+    }
+}
+
+func TestHandleLocksAliasedState(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "locktest")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.MkdirAll(filepath.Join(tempDir, "states", "prod"), 0755)
+    ioutil.WriteFile(filepath.Join(tempDir, "states", "prod", "new"), []byte(`{}`), 0644)
+    aliases.Write(tempDir, aliases.Alias{Path: "/prod/old", Target: "/prod/new", Mode: aliases.Forward, Created: time.Now()})
+
+    req := httptest.NewRequest("LOCK", "/locks/prod/old", bytes.NewReader([]byte(`{"ID": "abc"}`)))
+    rr := httptest.NewRecorder()
+    HandleLocks(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "locks", "prod", "new")); err != nil {
+        t.Errorf("Lock was not acquired on the new path: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "locks", "prod", "old")); !os.IsNotExist(err) {
+        t.Errorf("Lock was acquired on the old path")
+    }
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "go.opentelemetry.io/otel/trace"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/utils"
+)
+
+// Levels are the accepted values of LOG_LEVEL
+var Levels = map[string]slog.Level{
+    "debug": slog.LevelDebug,
+    "info":  slog.LevelInfo,
+    "warn":  slog.LevelWarn,
+    "error": slog.LevelError,
+}
+
+// Initialize replaces the default logger with one configured by LOG_LEVEL and
+// LOG_FORMAT, which adds the request ID to every line logged with a request's context.
+// Output of the standard log package goes through it too.
+func Initialize() {
+    slog.SetDefault(New(os.Stderr, config.GetEnv("LOG_FORMAT", "json"), Levels[config.GetEnv("LOG_LEVEL", "info")]))
+}
+
+// New returns a logger writing to w in format ("json" or "text") from level upwards
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+    options := &slog.HandlerOptions{Level: level}
+    var handler slog.Handler = slog.NewJSONHandler(w, options)
+    if format == "text" {
+        handler = slog.NewTextHandler(w, options)
+    }
+    return slog.New(contextHandler{handler})
+}
+
+// contextHandler adds the request ID and trace from the context to each record
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+    if entry, ok := ctx.Value(entryKey{}).(*entry); ok {
+        record.AddAttrs(slog.String("request_id", entry.id))
+    }
+    if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+        record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+    }
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+    return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// entry collects what the access log line reports about a request
+type entry struct {
+    id        string
+    principal string
+}
+
+type entryKey struct{}
+
+// RequestID returns the ID assigned to the request ctx belongs to
+func RequestID(ctx context.Context) string {
+    if entry, ok := ctx.Value(entryKey{}).(*entry); ok {
+        return entry.id
+    }
+    return ""
+}
+
+// SetPrincipal records who made the request ctx belongs to, for its access log line
+func SetPrincipal(ctx context.Context, username string) {
+    if entry, ok := ctx.Value(entryKey{}).(*entry); ok {
+        entry.principal = username
+    }
+}
+
+// Middleware assigns each request an ID, taken from a well-formed X-Request-ID
+// header or generated, echoes it in the response and logs one access line per request
+func Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        id := r.Header.Get("X-Request-ID")
+        if !validRequestID(id) {
+            id = newRequestID()
+        }
+        w.Header().Set("X-Request-ID", id)
+        entry := &entry{id: id}
+        ctx := context.WithValue(r.Context(), entryKey{}, entry)
+        recorder := utils.NewResponseRecorder(w)
+
+        next.ServeHTTP(recorder, r.WithContext(ctx))
+
+        slog.LogAttrs(ctx, slog.LevelInfo, "request",
+            slog.String("principal", entry.principal),
+            slog.String("method", r.Method),
+            slog.String("path", r.URL.Path),
+            slog.Int("status", recorder.Status()),
+            slog.Int64("bytes", recorder.Bytes),
+            slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+            slog.String("remote", r.RemoteAddr),
+        )
+    })
+}
+
+// validRequestID accepts IDs from upstream proxies that are safe to log and echo
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    return strings.IndexFunc(id, func(c rune) bool {
+        return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c))
+    }) < 0
+}
+
+func newRequestID() string {
+    id := make([]byte, 16)
+    rand.Read(id)
+    return hex.EncodeToString(id)
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// captureLogs sends the default logger's JSON output to a buffer for the duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+    t.Helper()
+    var buf bytes.Buffer
+    previous := slog.Default()
+    slog.SetDefault(New(&buf, "json", slog.LevelDebug))
+    t.Cleanup(func() { slog.SetDefault(previous) })
+    return &buf
+}
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+    t.Helper()
+    var lines []map[string]interface{}
+    for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+        var fields map[string]interface{}
+        if err := json.Unmarshal([]byte(line), &fields); err != nil {
+            t.Fatalf("Log line %q isn't JSON: %v", line, err)
+        }
+        lines = append(lines, fields)
+    }
+    return lines
+}
+
+func TestMiddleware(t *testing.T) {
+    buf := captureLogs(t)
+    handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        SetPrincipal(r.Context(), "alice")
+        slog.InfoContext(r.Context(), "Updated state", "path", "/states/app")
+        w.WriteHeader(http.StatusCreated)
+        w.Write([]byte("hello"))
+    }))
+
+    req := httptest.NewRequest(http.MethodPost, "/states/app", nil)
+    rr := httptest.NewRecorder()
+    handler.ServeHTTP(rr, req)
+
+    id := rr.Header().Get("X-Request-ID")
+    if len(id) != 32 {
+        t.Fatalf("X-Request-ID = %q; want a generated ID", id)
+    }
+    lines := decodeLines(t, buf)
+    if len(lines) != 2 {
+        t.Fatalf("Got %d log lines; want 2:\n%s", len(lines), buf.String())
+    }
+    if lines[0]["request_id"] != id {
+        t.Errorf("Handler log line has request_id %v; want %s", lines[0]["request_id"], id)
+    }
+    access := lines[1]
+    expected := map[string]interface{}{
+        "msg":        "request",
+        "request_id": id,
+        "principal":  "alice",
+        "method":     "POST",
+        "path":       "/states/app",
+        "status":     float64(http.StatusCreated),
+        "bytes":      float64(5),
+    }
+    for key, want := range expected {
+        if access[key] != want {
+            t.Errorf("Access log %s = %v; want %v", key, access[key], want)
+        }
+    }
+    if _, ok := access["duration_ms"]; !ok {
+        t.Errorf("Access log has no duration_ms")
+    }
+}
+
+func TestMiddlewareRequestIDHeader(t *testing.T) {
+    captureLogs(t)
+    handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(RequestID(r.Context())))
+    }))
+
+    tests := []struct {
+        header string
+        kept   bool
+    }{
+        {"upstream-id_1.2:3", true},
+        {"bad id\nwith newline", false},
+        {strings.Repeat("a", 129), false},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+        req.Header.Set("X-Request-ID", test.header)
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, req)
+
+        id := rr.Header().Get("X-Request-ID")
+        if (id == test.header) != test.kept {
+            t.Errorf("X-Request-ID %q became %q; kept = %v, want %v", test.header, id, id == test.header, test.kept)
+        }
+        if rr.Body.String() != id {
+            t.Errorf("Request context ID = %q; want %q", rr.Body.String(), id)
+        }
+    }
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/locks"
+    "terraform-http-backend/internal/ratelimit"
+    "terraform-http-backend/internal/states"
+    "terraform-http-backend/internal/utils"
+)
+
+var (
+    statesDesc = prometheus.NewDesc(namespace+"_states",
+        "Number of stored states.", []string{"tenant", "prefix"}, nil)
+    stateSizeDesc = prometheus.NewDesc(namespace+"_state_size_bytes",
+        "Sizes of stored states.", []string{"tenant", "prefix"}, nil)
+    locksDesc = prometheus.NewDesc(namespace+"_locks_held",
+        "Number of currently held locks.", []string{"tenant", "prefix"}, nil)
+    lockAgeDesc = prometheus.NewDesc(namespace+"_lock_age_seconds_max",
+        "Age of the oldest currently held lock.", []string{"tenant", "prefix"}, nil)
+    rateLimitedDesc = prometheus.NewDesc(namespace+"_rate_limited_total",
+        "Requests rejected by rate limiting, by class and the kind of key that ran out.", []string{"class", "key"}, nil)
+    rateLimitKeysDesc = prometheus.NewDesc(namespace+"_rate_limit_tracked_keys",
+        "Principals, client IPs and state paths whose allowance hasn't fully refilled.", []string{"class"}, nil)
+)
+
+// stateSizeBuckets range from 1KiB to 64MiB
+var stateSizeBuckets = prometheus.ExponentialBuckets(1024, 4, 9)
+
+// listCacheFor is how long states listed from disk, without an index, are
+// reused across scrapes
+const listCacheFor = time.Minute
+
+// storageCollector reports the states and locks stored in a data directory,
+// taking the states from the index when one is active
+type storageCollector struct {
+    dataDir string
+    now     func() time.Time
+
+    mu     sync.Mutex
+    listed map[string]listing
+}
+
+// listing is the states of a root as last listed from disk
+type listing struct {
+    entries []states.Entry
+    at      time.Time
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- statesDesc
+    ch <- stateSizeDesc
+    ch <- locksDesc
+    ch <- lockAgeDesc
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+    roots := map[string]string{"": c.dataDir}
+    if entries, err := os.ReadDir(filepath.Join(c.dataDir, "tenants")); err == nil {
+        for _, entry := range entries {
+            if entry.IsDir() {
+                roots[entry.Name()] = filepath.Join(c.dataDir, "tenants", entry.Name())
+            }
+        }
+    }
+    for tenant, root := range roots {
+        c.collectStates(ch, tenant, root)
+        c.collectLocks(ch, tenant, root)
+    }
+}
+
+type sizes struct {
+    count   uint64
+    sum     float64
+    buckets map[float64]uint64
+}
+
+// listStates lists the states under root from the index, or from disk at
+// most once every listCacheFor when there is no index
+func (c *storageCollector) listStates(root string) ([]states.Entry, error) {
+    if ix := index.Active(); ix != nil {
+        summaries, err := ix.List(root, "")
+        if err == nil {
+            entries := make([]states.Entry, len(summaries))
+            for i, summary := range summaries {
+                entries[i] = states.Entry{Path: summary.Path, Size: summary.Size}
+            }
+            return entries, nil
+        } else if err != index.ErrNotIndexed {
+            return nil, err
+        }
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if cached, ok := c.listed[root]; ok && c.now().Sub(cached.at) < listCacheFor {
+        return cached.entries, nil
+    }
+    entries, err := states.List(root, "")
+    if err != nil {
+        return nil, err
+    }
+    if c.listed == nil {
+        c.listed = map[string]listing{}
+    }
+    c.listed[root] = listing{entries: entries, at: c.now()}
+    return entries, nil
+}
+
+func (c *storageCollector) collectStates(ch chan<- prometheus.Metric, tenant, root string) {
+    entries, err := c.listStates(root)
+    if err != nil {
+        slog.Error("Error listing states for metrics", "error", err)
+        return
+    }
+    byPrefix := map[string]*sizes{}
+    for _, entry := range entries {
+        prefix := Prefix(entry.Path)
+        s, ok := byPrefix[prefix]
+        if !ok {
+            s = &sizes{buckets: map[float64]uint64{}}
+            byPrefix[prefix] = s
+        }
+        s.count++
+        s.sum += float64(entry.Size)
+        for _, bound := range stateSizeBuckets {
+            if float64(entry.Size) <= bound {
+                s.buckets[bound]++
+            }
+        }
+    }
+    for prefix, s := range byPrefix {
+        ch <- prometheus.MustNewConstMetric(statesDesc, prometheus.GaugeValue, float64(s.count), tenant, prefix)
+        ch <- prometheus.MustNewConstHistogram(stateSizeDesc, s.count, s.sum, s.buckets, tenant, prefix)
+    }
+}
+
+func (c *storageCollector) collectLocks(ch chan<- prometheus.Metric, tenant, root string) {
+    entries, err := locks.List(root, "")
+    if err != nil {
+        slog.Error("Error listing locks for metrics", "error", err)
+        return
+    }
+    held := map[string]float64{}
+    oldest := map[string]time.Duration{}
+    for _, entry := range entries {
+        prefix := Prefix(entry.Path)
+        held[prefix]++
+        if age := c.now().Sub(entry.Acquired); age > oldest[prefix] {
+            oldest[prefix] = age
+        }
+    }
+    for prefix, count := range held {
+        ch <- prometheus.MustNewConstMetric(locksDesc, prometheus.GaugeValue, count, tenant, prefix)
+        ch <- prometheus.MustNewConstMetric(lockAgeDesc, prometheus.GaugeValue, oldest[prefix].Seconds(), tenant, prefix)
+    }
+}
+
+// rateLimitCollector reports the state of the rate limiters
+type rateLimitCollector struct{}
+
+func (rateLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- rateLimitedDesc
+    ch <- rateLimitKeysDesc
+}
+
+func (rateLimitCollector) Collect(ch chan<- prometheus.Metric) {
+    for _, stats := range ratelimit.Snapshot() {
+        ch <- prometheus.MustNewConstMetric(rateLimitKeysDesc, prometheus.GaugeValue, float64(stats.Tracked), string(stats.Class))
+        for _, kind := range []string{"principal", "ip", "path"} {
+            ch <- prometheus.MustNewConstMetric(rateLimitedDesc, prometheus.CounterValue, float64(stats.Rejected[kind]), string(stats.Class), kind)
+        }
+    }
+}
+
+// Handler serves the metrics of the server storing its data in dataDir
+func Handler(dataDir string) http.Handler {
+    registry := prometheus.NewRegistry()
+    registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+        requests,
+        requestDuration,
+        lockConflicts,
+        &storageCollector{dataDir: dataDir, now: time.Now},
+        rateLimitCollector{},
+        prometheus.NewCounterFunc(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "auth_failures_total",
+            Help:      "Rejected authentication attempts.",
+        }, func() float64 { return float64(auth.Failures().Failures) }),
+        prometheus.NewCounterFunc(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "auth_throttled_total",
+            Help:      "Authentication attempts refused while the client was locked out.",
+        }, func() float64 { return float64(auth.Failures().Throttled) }),
+        prometheus.NewCounterFunc(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name:      "storage_errors_total",
+            Help:      "Requests that failed reading or writing files.",
+        }, func() float64 { return float64(utils.StorageErrors()) }),
+    )
+    return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/utils"
+)
+
+const namespace = "tfbackend"
+
+var (
+    requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "http_requests_total",
+        Help:      "HTTP requests by route, method, status and state path prefix.",
+    }, []string{"route", "method", "status", "prefix"})
+
+    requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "http_request_duration_seconds",
+        Help:      "HTTP request latency by route, method and state path prefix.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"route", "method", "prefix"})
+
+    lockConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "lock_conflicts_total",
+        Help:      "Requests refused with 423 Locked because another client holds the lock.",
+    }, []string{"prefix"})
+)
+
+// routes are the values of the route label, any other path is "other"
+var routes = map[string]bool{
+    "states":  true,
+    "locks":   true,
+    "search":  true,
+    "aliases": true,
+    "healthz": true,
+    "readyz":  true,
+    "status":  true,
+    "metrics": true,
+}
+
+// methods are the values of the method label, any other method is "OTHER"
+var methods = map[string]bool{
+    http.MethodGet:    true,
+    http.MethodHead:   true,
+    http.MethodPost:   true,
+    http.MethodPut:    true,
+    http.MethodDelete: true,
+    "LOCK":            true,
+    "UNLOCK":          true,
+}
+
+// prefixLabels bounds the cardinality of the prefix label: state paths are
+// cut to their first depth directories, and once max distinct prefixes have
+// been seen any new one is reported as "other"
+type prefixLabels struct {
+    mu    sync.Mutex
+    depth int
+    max   int
+    seen  map[string]bool
+}
+
+var prefixes = &prefixLabels{depth: 1, max: 100, seen: map[string]bool{}}
+
+// Initialize configures the prefix label from METRICS_PREFIX_DEPTH and METRICS_MAX_PREFIXES
+func Initialize() {
+    prefixes.mu.Lock()
+    defer prefixes.mu.Unlock()
+    prefixes.depth = config.GetEnvInt("METRICS_PREFIX_DEPTH", 1)
+    prefixes.max = config.GetEnvInt("METRICS_MAX_PREFIXES", 100)
+    prefixes.seen = map[string]bool{}
+}
+
+// Prefix returns the prefix label for a state path such as prod/network/terraform.tfstate
+func Prefix(statePath string) string {
+    return prefixes.label(statePath)
+}
+
+func (p *prefixLabels) label(statePath string) string {
+    segments := strings.Split(strings.Trim(statePath, "/"), "/")
+    // the last segment names the state itself and is never part of the prefix
+    segments = segments[:len(segments)-1]
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if len(segments) > p.depth {
+        segments = segments[:p.depth]
+    }
+    prefix := "/"
+    if len(segments) > 0 {
+        prefix = strings.Join(segments, "/") + "/"
+    }
+    if !p.seen[prefix] {
+        if len(p.seen) >= p.max {
+            return "other"
+        }
+        p.seen[prefix] = true
+    }
+    return prefix
+}
+
+// Instrument counts and times the requests served by next
+func Instrument(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        recorder := utils.NewResponseRecorder(w)
+        next.ServeHTTP(recorder, r)
+
+        route, prefix := labels(r.URL.Path)
+        method := r.Method
+        if !methods[method] {
+            method = "OTHER"
+        }
+        status := recorder.Status()
+        requests.WithLabelValues(route, method, strconv.Itoa(status), prefix).Inc()
+        requestDuration.WithLabelValues(route, method, prefix).Observe(time.Since(start).Seconds())
+        if status == http.StatusLocked {
+            lockConflicts.WithLabelValues(prefix).Inc()
+        }
+    })
+}
+
+// labels returns the route and prefix labels of a request path
+func labels(path string) (string, string) {
+    if rest, ok := strings.CutPrefix(path, "/tenants/"); ok {
+        _, path, _ = strings.Cut(rest, "/")
+    }
+    route, statePath := utils.SplitPath(path)
+    if !routes[route] {
+        return "other", ""
+    }
+    if route != "states" && route != "locks" || statePath == "/" {
+        return route, ""
+    }
+    return route, Prefix(statePath)
+}
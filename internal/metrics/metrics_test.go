@@ -0,0 +1,154 @@
+package metrics
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+
+    "terraform-http-backend/internal/index"
+)
+
+func TestPrefix(t *testing.T) {
+    labels := &prefixLabels{depth: 2, max: 3, seen: map[string]bool{}}
+    tests := []struct {
+        path string
+        want string
+    }{
+        {"app.tfstate", "/"},
+        {"/prod/app.tfstate", "prod/"},
+        {"prod/network/vpc/terraform.tfstate", "prod/network/"},
+        {"staging/app.tfstate", "other"},
+        {"prod/app.tfstate", "prod/"},
+    }
+    for _, test := range tests {
+        if got := labels.label(test.path); got != test.want {
+            t.Errorf("label(%q) = %q; want %q", test.path, got, test.want)
+        }
+    }
+}
+
+func TestInstrument(t *testing.T) {
+    Initialize()
+    handler := Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == "LOCK" {
+            http.Error(w, "Locked", http.StatusLocked)
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+
+    before := testutil.ToFloat64(requests.WithLabelValues("states", "GET", "200", "instrument/"))
+    req := httptest.NewRequest(http.MethodGet, "/tenants/payments/states/instrument/app.tfstate", nil)
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+    if got := testutil.ToFloat64(requests.WithLabelValues("states", "GET", "200", "instrument/")); got != before+1 {
+        t.Errorf("Request count = %v; want %v", got, before+1)
+    }
+
+    conflicts := testutil.ToFloat64(lockConflicts.WithLabelValues("instrument/"))
+    req = httptest.NewRequest("LOCK", "/locks/instrument/app.tfstate", nil)
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+    if got := testutil.ToFloat64(lockConflicts.WithLabelValues("instrument/")); got != conflicts+1 {
+        t.Errorf("Lock conflicts = %v; want %v", got, conflicts+1)
+    }
+
+    req = httptest.NewRequest("PROPFIND", "/anything", nil)
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+    if got := testutil.ToFloat64(requests.WithLabelValues("other", "OTHER", "200", "")); got < 1 {
+        t.Errorf("Unknown routes and methods were not counted as other")
+    }
+}
+
+func TestHandler(t *testing.T) {
+    Initialize()
+    dataDir, err := ioutil.TempDir("", "metrics")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dataDir)
+
+    for _, path := range []string{"states/prod/a.tfstate", "states/prod/b.tfstate", "tenants/payments/states/app.tfstate"} {
+        os.MkdirAll(filepath.Join(dataDir, filepath.Dir(path)), 0755)
+        ioutil.WriteFile(filepath.Join(dataDir, path), []byte(`{"version": 4}`), 0644)
+    }
+    lockFile := filepath.Join(dataDir, "locks", "prod", "a.tfstate")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    ioutil.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+    acquired := time.Now().Add(-time.Hour)
+    os.Chtimes(lockFile, acquired, acquired)
+
+    rr := httptest.NewRecorder()
+    Handler(dataDir).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    body := rr.Body.String()
+    for _, want := range []string{
+        `tfbackend_states{prefix="prod/",tenant=""} 2`,
+        `tfbackend_states{prefix="/",tenant="payments"} 1`,
+        `tfbackend_state_size_bytes_count{prefix="prod/",tenant=""} 2`,
+        `tfbackend_locks_held{prefix="prod/",tenant=""} 1`,
+        `tfbackend_auth_failures_total`,
+        `tfbackend_storage_errors_total`,
+    } {
+        if !strings.Contains(body, want) {
+            t.Errorf("Metrics don't contain %q", want)
+        }
+    }
+    if !strings.Contains(body, `tfbackend_lock_age_seconds_max{prefix="prod/",tenant=""} 36`) {
+        t.Errorf("Lock age isn't about an hour:\n%s", body)
+    }
+}
+
+func TestStorageCollectorAvoidsWalking(t *testing.T) {
+    dataDir := t.TempDir()
+    stateFile := filepath.Join(dataDir, "states", "prod", "a.tfstate")
+    os.MkdirAll(filepath.Dir(stateFile), 0755)
+    ioutil.WriteFile(stateFile, []byte(`{"version": 4}`), 0644)
+    now := time.Now()
+    c := &storageCollector{dataDir: dataDir, now: func() time.Time { return now }}
+    count := func() int {
+        entries, err := c.listStates(dataDir)
+        if err != nil {
+            t.Fatalf("listStates failed: %v", err)
+        }
+        return len(entries)
+    }
+
+    // Without an index, a listing is reused until it is a minute old
+    if got := count(); got != 1 {
+        t.Fatalf("Listed %d states; want 1", got)
+    }
+    other := filepath.Join(dataDir, "states", "prod", "b.tfstate")
+    ioutil.WriteFile(other, []byte(`{"version": 4}`), 0644)
+    if got := count(); got != 1 {
+        t.Errorf("Listed %d states on the next scrape; want the cached 1", got)
+    }
+    now = now.Add(listCacheFor)
+    if got := count(); got != 2 {
+        t.Errorf("Listed %d states once the cache expired; want 2", got)
+    }
+
+    // With one, states come from the index rather than the files
+    ix, err := index.Open(dataDir)
+    if err != nil {
+        t.Fatalf("Failed to open index: %v", err)
+    }
+    defer ix.Close()
+    if _, err := ix.Sync(); err != nil {
+        t.Fatalf("Failed to sync index: %v", err)
+    }
+    index.Activate(ix)
+    defer index.Activate(nil)
+    os.Remove(other)
+    if got := count(); got != 2 {
+        t.Errorf("Listed %d states with an index; want the indexed 2", got)
+    }
+}
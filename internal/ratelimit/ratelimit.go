@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+    "log/slog"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/utils"
+)
+
+// Class is a kind of request limited separately from the others
+type Class string
+
+const (
+    // Reads are GET and HEAD requests for states
+    Reads Class = "read"
+    // Writes are requests that update or delete states
+    Writes Class = "write"
+    // Locks are lock attempts, unlocking is never limited
+    Locks Class = "lock"
+)
+
+// Classes lists every class, in the order they're reported
+var Classes = []Class{Reads, Writes, Locks}
+
+// limiters holds the limiter of each enabled class
+var limiters = map[Class]*limiter{}
+
+// Initialize configures the per-minute rates of each class from RATE_LIMIT_READS,
+// RATE_LIMIT_WRITES and RATE_LIMIT_LOCKS, allowing bursts of RATE_LIMIT_BURST.
+// A rate of 0 leaves the class unlimited.
+func Initialize() {
+    burst := config.GetEnvInt("RATE_LIMIT_BURST", 10)
+    rates := map[Class]float64{
+        Reads:  config.GetEnvFloat("RATE_LIMIT_READS", 0),
+        Writes: config.GetEnvFloat("RATE_LIMIT_WRITES", 0),
+        Locks:  config.GetEnvFloat("RATE_LIMIT_LOCKS", 0),
+    }
+    limiters = map[Class]*limiter{}
+    for class, rate := range rates {
+        if rate > 0 {
+            limiters[class] = newLimiter(rate/60, float64(burst), time.Now)
+        }
+    }
+}
+
+// Limit is a middleware that rejects requests with 429 once the principal,
+// client IP or state path making them has used up its allowance
+func Limit(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        class := classify(r)
+        l, ok := limiters[class]
+        if !ok {
+            next(w, r)
+            return
+        }
+        if key, wait := l.take(keys(r)...); wait > 0 {
+            kind, _, _ := strings.Cut(key, ":")
+            trace.SpanFromContext(r.Context()).SetAttributes(
+                attribute.String("ratelimit.class", string(class)),
+                attribute.String("ratelimit.key", kind),
+            )
+            w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            http.Error(w, "Too many requests", http.StatusTooManyRequests)
+            slog.WarnContext(r.Context(), "Rate limited", "class", class, "key", key, "path", r.URL.Path)
+            return
+        }
+        next(w, r)
+    }
+}
+
+// classify returns the class a request for /states/... or /locks/... counts
+// against, "" for requests that are never limited. Releasing a lock is one of
+// those: refusing an UNLOCK after an apply would leave the state locked.
+func classify(r *http.Request) Class {
+    read := r.Method == http.MethodGet || r.Method == http.MethodHead
+    if route, _ := utils.SplitPath(r.URL.Path); route == "locks" {
+        switch {
+        case r.Method == "LOCK":
+            return Locks
+        case read:
+            return Reads
+        }
+        return ""
+    }
+    if read {
+        return Reads
+    }
+    return Writes
+}
+
+// keys returns the buckets a request takes a token from: its principal when
+// authenticated, its client IP and the state path it's for
+func keys(r *http.Request) []string {
+    var tenant string
+    if t, ok := tenants.FromContext(r.Context()); ok {
+        tenant = t.Name
+    }
+    _, statePath := utils.SplitPath(r.URL.Path)
+    keys := []string{"ip:" + auth.ClientIP(r), "path:" + tenant + "/" + statePath}
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+        keys = append(keys, "principal:"+principal.Tenant+"/"+principal.Username)
+    }
+    return keys
+}
+
+// Stats describes the limiter of one class of requests
+type Stats struct {
+    Class Class
+    // Tracked is the number of keys whose allowance hasn't fully refilled
+    Tracked int
+    // Rejected counts rejected requests by the kind of key that ran out: principal, ip or path
+    Rejected map[string]uint64
+}
+
+// Snapshot returns the state of the limiter of each enabled class
+func Snapshot() []Stats {
+    var stats []Stats
+    for _, class := range Classes {
+        if l, ok := limiters[class]; ok {
+            stats = append(stats, l.stats(class))
+        }
+    }
+    return stats
+}
+
+// limiter keeps a token bucket per key, each refilled at rate tokens per
+// second up to burst
+type limiter struct {
+    mu       sync.Mutex
+    now      func() time.Time
+    rate     float64
+    burst    float64
+    buckets  map[string]*bucket
+    rejected map[string]uint64
+    pruned   time.Time
+}
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+}
+
+func newLimiter(rate, burst float64, now func() time.Time) *limiter {
+    return &limiter{
+        now:      now,
+        rate:     rate,
+        burst:    burst,
+        buckets:  make(map[string]*bucket),
+        rejected: make(map[string]uint64),
+    }
+}
+
+// take takes a token from the bucket of every key, or from none of them when
+// any is empty, in which case it returns that key and how long until it refills
+func (l *limiter) take(keys ...string) (string, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := l.now()
+    if now.Sub(l.pruned) > time.Minute {
+        l.prune(now)
+    }
+    var limited string
+    var wait time.Duration
+    for _, key := range keys {
+        b := l.refill(key, now)
+        if b.tokens >= 1 {
+            continue
+        }
+        if remaining := time.Duration((1 - b.tokens) / l.rate * float64(time.Second)); remaining > wait {
+            limited, wait = key, remaining
+        }
+    }
+    if wait > 0 {
+        kind, _, _ := strings.Cut(limited, ":")
+        l.rejected[kind]++
+        return limited, wait
+    }
+    for _, key := range keys {
+        l.buckets[key].tokens--
+    }
+    return "", 0
+}
+
+// refill returns key's bucket topped up for the time since it was last used
+func (l *limiter) refill(key string, now time.Time) *bucket {
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: l.burst, last: now}
+        l.buckets[key] = b
+        return b
+    }
+    b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+    b.last = now
+    return b
+}
+
+// prune drops buckets that have refilled, which behave the same as new ones
+func (l *limiter) prune(now time.Time) {
+    for key, b := range l.buckets {
+        if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+            delete(l.buckets, key)
+        }
+    }
+    l.pruned = now
+}
+
+func (l *limiter) stats(class Class) Stats {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.prune(l.now())
+    rejected := make(map[string]uint64, len(l.rejected))
+    for kind, count := range l.rejected {
+        rejected[kind] = count
+    }
+    return Stats{Class: class, Tracked: len(l.buckets), Rejected: rejected}
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+type fakeClock struct {
+    current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+    return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+    c.current = c.current.Add(d)
+}
+
+func TestLimiterTake(t *testing.T) {
+    clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    l := newLimiter(1, 3, clock.now)
+
+    for i := 0; i < 3; i++ {
+        if key, wait := l.take("ip:1.2.3.4", "path:/app"); wait != 0 {
+            t.Fatalf("Request %d within the burst was limited by %s", i, key)
+        }
+    }
+    key, wait := l.take("ip:1.2.3.4", "path:/app")
+    if wait != time.Second {
+        t.Errorf("Wait after the burst = %s; want 1s", wait)
+    }
+    if key != "ip:1.2.3.4" {
+        t.Errorf("Limited key = %q; want %q", key, "ip:1.2.3.4")
+    }
+
+    // An exhausted path limits every client, but rejected requests take no tokens from their other keys
+    if _, wait := l.take("ip:5.6.7.8", "path:/app"); wait == 0 {
+        t.Errorf("Exhausted path was not limited for another client")
+    }
+    for i := 0; i < 3; i++ {
+        if _, wait := l.take("ip:5.6.7.8", fmt.Sprintf("path:/other%d", i)); wait != 0 {
+            t.Errorf("Client was charged for a rejected request: waited %s", wait)
+        }
+    }
+
+    clock.advance(time.Second)
+    if key, wait := l.take("ip:1.2.3.4", "path:/app"); wait != 0 {
+        t.Errorf("Request after refilling was limited by %s for %s", key, wait)
+    }
+
+    stats := l.stats(Reads)
+    if stats.Rejected["ip"] != 1 || stats.Rejected["path"] != 1 {
+        t.Errorf("Rejected = %v; want one by ip and one by path", stats.Rejected)
+    }
+    clock.advance(time.Minute)
+    if stats := l.stats(Reads); stats.Tracked != 0 {
+        t.Errorf("Tracked = %d after every bucket refilled; want 0", stats.Tracked)
+    }
+}
+
+func TestLimit(t *testing.T) {
+    t.Setenv("RATE_LIMIT_WRITES", "60")
+    t.Setenv("RATE_LIMIT_BURST", "2")
+    Initialize()
+    defer func() { limiters = map[Class]*limiter{} }()
+
+    handler := Limit(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    serve := func(method, path string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, nil)
+        rr := httptest.NewRecorder()
+        handler(rr, req)
+        return rr
+    }
+
+    for i := 0; i < 2; i++ {
+        if status := serve(http.MethodPost, "/states/app").Code; status != http.StatusOK {
+            t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+        }
+    }
+    rr := serve(http.MethodPost, "/states/app")
+    if status := rr.Code; status != http.StatusTooManyRequests {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+    }
+    if retry := rr.Header().Get("Retry-After"); retry != "1" {
+        t.Errorf("Retry-After = %q; want %q", retry, "1")
+    }
+
+    // Reads and lock attempts have their own, here unlimited, allowance
+    if status := serve(http.MethodGet, "/states/app").Code; status != http.StatusOK {
+        t.Errorf("Read was limited by writes: got %v want %v", status, http.StatusOK)
+    }
+    if status := serve("LOCK", "/locks/app").Code; status != http.StatusOK {
+        t.Errorf("Lock was limited by writes: got %v want %v", status, http.StatusOK)
+    }
+
+    stats := Snapshot()
+    if len(stats) != 1 || stats[0].Class != Writes || stats[0].Rejected["ip"]+stats[0].Rejected["path"] != 1 {
+        t.Errorf("Snapshot = %+v; want one rejected write", stats)
+    }
+}
+
+func TestLimitNeverRefusesUnlock(t *testing.T) {
+    t.Setenv("RATE_LIMIT_LOCKS", "60")
+    t.Setenv("RATE_LIMIT_BURST", "1")
+    Initialize()
+    defer func() { limiters = map[Class]*limiter{} }()
+
+    handler := Limit(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+    for i, test := range []struct {
+        method         string
+        expectedStatus int
+    }{
+        {"LOCK", http.StatusOK},
+        {"LOCK", http.StatusTooManyRequests},
+        {"UNLOCK", http.StatusOK},
+        {"UNLOCK", http.StatusOK},
+    } {
+        rr := httptest.NewRecorder()
+        handler(rr, httptest.NewRequest(test.method, "/locks/app", nil))
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("Request %d (%s): Handler returned wrong status code: got %v want %v", i, test.method, status, test.expectedStatus)
+        }
+    }
+}
@@ -0,0 +1,95 @@
+package search
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/utils"
+)
+
+const (
+    defaultLimit = 100
+    maxLimit     = 1000
+)
+
+// Result is the response of GET /search
+type Result struct {
+    Matches []index.Match `json:"matches"`
+    // Truncated is set when more instances matched than the limit
+    Truncated bool `json:"truncated"`
+}
+
+// ParseQuery reads a search from the attr and value, type, name and limit
+// query parameters. At least one criterion is required.
+func ParseQuery(values url.Values) (index.Query, error) {
+    query := index.Query{
+        Type:      values.Get("type"),
+        Name:      values.Get("name"),
+        Attribute: values.Get("attr"),
+        Value:     values.Get("value"),
+        Limit:     defaultLimit,
+    }
+    if (query.Attribute == "") != (query.Value == "") {
+        return query, errors.New("attr and value must be given together")
+    }
+    if query.Type == "" && query.Name == "" && query.Attribute == "" {
+        return query, errors.New("search needs attr and value, type or name")
+    }
+    if value := values.Get("limit"); value != "" {
+        limit, err := strconv.Atoi(value)
+        if err != nil || limit < 1 || limit > maxLimit {
+            return query, fmt.Errorf("invalid limit %q, must be between 1 and %d", value, maxLimit)
+        }
+        query.Limit = limit
+    }
+    return query, nil
+}
+
+// HandleSearch finds the resource instances matching the query among every
+// state stored under dataDir, the caller's storage root
+func HandleSearch(w http.ResponseWriter, r *http.Request, dataDir string) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        utils.MethodNotAllowed(w, r)
+        return
+    }
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && principal.OutputsOnly {
+        http.Error(w, "Resources are not readable with outputs-only access", http.StatusForbidden)
+        return
+    }
+    query, err := ParseQuery(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    ix := index.Active()
+    if ix == nil {
+        http.Error(w, "Search needs the index, which is disabled", http.StatusServiceUnavailable)
+        return
+    }
+
+    // Ask for one more than the limit to tell whether there are more
+    limit := query.Limit
+    query.Limit++
+    _, span := tracing.Start(r.Context(), "index.search",
+        attribute.String("search.type", query.Type), attribute.String("search.attr", query.Attribute))
+    matches, err := ix.Search(dataDir, query)
+    span.SetAttributes(attribute.Int("search.matches", len(matches)))
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error searching index", err)
+        return
+    }
+    result := Result{Matches: matches}
+    if len(matches) > limit {
+        result.Matches, result.Truncated = matches[:limit], true
+    }
+    utils.WriteJSON(w, result)
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/index"
+)
+
+func TestHandleSearch(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    for _, path := range []string{"prod/network", "staging/network"} {
+        file := filepath.Join(tempDir, "states", filepath.FromSlash(path))
+        os.MkdirAll(filepath.Dir(file), 0755)
+        state := `{"resources": [{"mode": "managed", "type": "aws_security_group", "name": "web", "instances": [{"attributes": {"id": "sg-123"}}]}]}`
+        if err := ioutil.WriteFile(file, []byte(state), 0644); err != nil {
+            t.Fatalf("Failed to write test file: %v", err)
+        }
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/search?attr=id&value=sg-123", nil)
+    rr := httptest.NewRecorder()
+    HandleSearch(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusServiceUnavailable {
+        t.Errorf("Handler returned wrong status code without index: got %v want %v", status, http.StatusServiceUnavailable)
+    }
+
+    ix, err := index.Open(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to open index: %v", err)
+    }
+    defer ix.Close()
+    if _, err := ix.Sync(); err != nil {
+        t.Fatalf("Sync failed: %v", err)
+    }
+    index.Activate(ix)
+    defer index.Activate(nil)
+
+    tests := []struct {
+        description    string
+        query          string
+        expectedStatus int
+        expected       Result
+    }{
+        {"by attribute", "attr=id&value=sg-123", http.StatusOK, Result{Matches: []index.Match{{Path: "prod/network", Address: "aws_security_group.web"}, {Path: "staging/network", Address: "aws_security_group.web"}}}},
+        {"by type and name", "type=aws_security_group&name=web&limit=1", http.StatusOK, Result{Matches: []index.Match{{Path: "prod/network", Address: "aws_security_group.web"}}, Truncated: true}},
+        {"no match", "type=aws_s3_bucket", http.StatusOK, Result{Matches: []index.Match{}}},
+        {"no criteria", "", http.StatusBadRequest, Result{}},
+        {"attr without value", "attr=id", http.StatusBadRequest, Result{}},
+        {"bad limit", "type=aws_s3_bucket&limit=0", http.StatusBadRequest, Result{}},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/search?"+test.query, nil)
+        rr := httptest.NewRecorder()
+        HandleSearch(rr, req, tempDir)
+
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.description, status, test.expectedStatus)
+        }
+        if test.expectedStatus != http.StatusOK {
+            continue
+        }
+        var result Result
+        if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+            t.Fatalf("%s: Response isn't JSON: %s", test.description, rr.Body.String())
+        }
+        if !reflect.DeepEqual(result, test.expected) {
+            t.Errorf("%s: Search = %+v; want %+v", test.description, result, test.expected)
+        }
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/search?attr=id&value=sg-123", nil)
+    req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "ci", Role: auth.RoleReadOnly, OutputsOnly: true}))
+    rr = httptest.NewRecorder()
+    HandleSearch(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusForbidden {
+        t.Errorf("Handler returned wrong status code for outputs only: got %v want %v", status, http.StatusForbidden)
+    }
+}
@@ -0,0 +1,214 @@
+package states
+
+import (
+    "fmt"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "terraform-http-backend/internal/index"
+)
+
+// Metadata is what a state records about itself, along with whether it's locked
+type Metadata struct {
+    Serial           int64  `json:"serial"`
+    Lineage          string `json:"lineage,omitempty"`
+    TerraformVersion string `json:"terraform_version,omitempty"`
+    Resources        int    `json:"resources"`
+    Locked           bool   `json:"locked"`
+}
+
+// ListOptions select, order and page the states returned by Query
+type ListOptions struct {
+    Prefix string
+    // Recursive lists every state under Prefix, instead of only those directly in it
+    Recursive bool
+    // Sort is "path", "modified" or "size"
+    Sort           string
+    Descending     bool
+    ModifiedAfter  time.Time
+    ModifiedBefore time.Time
+    Offset         int
+    Limit          int
+}
+
+// Page is one page of a state listing
+type Page struct {
+    States []Entry `json:"states"`
+    // Prefixes are the directories directly in the prefix of a non-recursive listing
+    Prefixes []string `json:"prefixes,omitempty"`
+    // Total is the number of states matching the listing across every page
+    Total int `json:"total"`
+    // NextOffset is the offset of the next page, 0 on the last one
+    NextOffset int `json:"next_offset,omitempty"`
+}
+
+const (
+    defaultPageSize = 100
+    maxPageSize     = 1000
+)
+
+var sortKeys = map[string]func(a, b Entry) bool{
+    "path":     func(a, b Entry) bool { return a.Path < b.Path },
+    "modified": func(a, b Entry) bool { return a.Modified.Before(b.Modified) },
+    "size":     func(a, b Entry) bool { return a.Size < b.Size },
+}
+
+// ParseListOptions reads the options of a listing from its query string:
+// prefix, recursive, sort, order, modified_after, modified_before, offset and limit
+func ParseListOptions(query url.Values) (ListOptions, error) {
+    opts := ListOptions{
+        Prefix: query.Get("prefix"),
+        Sort:   "path",
+        Limit:  defaultPageSize,
+    }
+    var err error
+    if value := query.Get("recursive"); value != "" {
+        if opts.Recursive, err = strconv.ParseBool(value); err != nil {
+            return opts, fmt.Errorf("invalid recursive %q", value)
+        }
+    }
+    if value := query.Get("sort"); value != "" {
+        if _, ok := sortKeys[value]; !ok {
+            return opts, fmt.Errorf("invalid sort %q, must be path, modified or size", value)
+        }
+        opts.Sort = value
+    }
+    switch order := query.Get("order"); order {
+    case "", "asc":
+    case "desc":
+        opts.Descending = true
+    default:
+        return opts, fmt.Errorf("invalid order %q, must be asc or desc", order)
+    }
+    if value := query.Get("modified_after"); value != "" {
+        if opts.ModifiedAfter, err = time.Parse(time.RFC3339, value); err != nil {
+            return opts, fmt.Errorf("invalid modified_after %q, must be RFC 3339", value)
+        }
+    }
+    if value := query.Get("modified_before"); value != "" {
+        if opts.ModifiedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+            return opts, fmt.Errorf("invalid modified_before %q, must be RFC 3339", value)
+        }
+    }
+    if value := query.Get("offset"); value != "" {
+        if opts.Offset, err = strconv.Atoi(value); err != nil || opts.Offset < 0 {
+            return opts, fmt.Errorf("invalid offset %q", value)
+        }
+    }
+    if value := query.Get("limit"); value != "" {
+        if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit < 1 || opts.Limit > maxPageSize {
+            return opts, fmt.Errorf("invalid limit %q, must be between 1 and %d", value, maxPageSize)
+        }
+    }
+    return opts, nil
+}
+
+// Query returns the page of states under dataDir selected by opts, with their metadata
+func Query(dataDir string, opts ListOptions) (Page, error) {
+    entries, err := indexedList(dataDir, opts.Prefix)
+    if err != nil {
+        return Page{}, err
+    }
+    prefix := strings.TrimPrefix(opts.Prefix, "/")
+    page := Page{States: []Entry{}}
+    var matched []Entry
+    dirs := map[string]bool{}
+    for _, entry := range entries {
+        if !opts.Recursive {
+            if dir, _, nested := strings.Cut(strings.TrimPrefix(entry.Path, prefix), "/"); nested {
+                dirs[prefix+dir+"/"] = true
+                continue
+            }
+        }
+        if !opts.ModifiedAfter.IsZero() && !entry.Modified.After(opts.ModifiedAfter) {
+            continue
+        }
+        if !opts.ModifiedBefore.IsZero() && !entry.Modified.Before(opts.ModifiedBefore) {
+            continue
+        }
+        matched = append(matched, entry)
+    }
+    for dir := range dirs {
+        page.Prefixes = append(page.Prefixes, dir)
+    }
+    sort.Strings(page.Prefixes)
+
+    less := sortKeys[opts.Sort]
+    if less == nil {
+        less = sortKeys["path"]
+    }
+    sort.SliceStable(matched, func(i, j int) bool {
+        if opts.Descending {
+            return less(matched[j], matched[i])
+        }
+        return less(matched[i], matched[j])
+    })
+
+    page.Total = len(matched)
+    if opts.Limit <= 0 {
+        opts.Limit = defaultPageSize
+    }
+    if opts.Offset < len(matched) {
+        end := opts.Offset + opts.Limit
+        if end < len(matched) {
+            page.NextOffset = end
+        } else {
+            end = len(matched)
+        }
+        page.States = append(page.States, matched[opts.Offset:end]...)
+    }
+    return page, Describe(dataDir, page.States)
+}
+
+// indexedList lists the states under dataDir like List, taking them and
+// their metadata from the index when one is active
+func indexedList(dataDir, prefix string) ([]Entry, error) {
+    ix := index.Active()
+    if ix == nil {
+        return List(dataDir, prefix)
+    }
+    summaries, err := ix.List(dataDir, prefix)
+    if err == index.ErrNotIndexed {
+        return List(dataDir, prefix)
+    } else if err != nil {
+        return nil, err
+    }
+    entries := make([]Entry, len(summaries))
+    for i, summary := range summaries {
+        entries[i] = Entry{Path: summary.Path, Size: summary.Size, Modified: summary.Modified, Metadata: metadata(&summary)}
+    }
+    return entries, nil
+}
+
+// Describe reads the metadata of each state in entries that doesn't have it
+// yet. States that are no longer there or aren't valid JSON are left with
+// empty metadata.
+func Describe(dataDir string, entries []Entry) error {
+    for i := range entries {
+        if entries[i].Metadata != nil {
+            continue
+        }
+        summary, err := index.Read(dataDir, entries[i].Path)
+        if err != nil {
+            return err
+        }
+        entries[i].Metadata = metadata(summary)
+    }
+    return nil
+}
+
+func metadata(summary *index.Summary) *Metadata {
+    if summary == nil {
+        return &Metadata{}
+    }
+    return &Metadata{
+        Serial:           summary.Serial,
+        Lineage:          summary.Lineage,
+        TerraformVersion: summary.TerraformVersion,
+        Resources:        summary.Resources,
+        Locked:           summary.Locked,
+    }
+}
@@ -0,0 +1,156 @@
+package states
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "terraform-http-backend/internal/index"
+)
+
+// writeStates stores each state with its modification time set to base plus its index in minutes
+func writeStates(t *testing.T, dataDir string, base time.Time, paths ...string) {
+    t.Helper()
+    for i, path := range paths {
+        file := FilePath(dataDir, path)
+        os.MkdirAll(filepath.Dir(file), 0755)
+        state := `{"version": 4, "terraform_version": "1.9.0", "serial": 3, "lineage": "abc", "resources": [{"mode": "managed", "instances": [{}, {}]}, {"mode": "data", "instances": [{}]}]}`
+        if err := ioutil.WriteFile(file, []byte(state), 0644); err != nil {
+            t.Fatalf("Failed to write test file: %v", err)
+        }
+        modified := base.Add(time.Duration(i) * time.Minute)
+        os.Chtimes(file, modified, modified)
+    }
+}
+
+func listPaths(page Page) []string {
+    paths := []string{}
+    for _, entry := range page.States {
+        paths = append(paths, entry.Path)
+    }
+    return paths
+}
+
+func TestQuery(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    writeStates(t, tempDir, base, "prod/app", "prod/network/vpc", "prod/db", "staging/app")
+
+    tests := []struct {
+        name     string
+        opts     ListOptions
+        paths    []string
+        prefixes []string
+        next     int
+    }{
+        {"direct children", ListOptions{Prefix: "prod/"}, []string{"prod/app", "prod/db"}, []string{"prod/network/"}, 0},
+        {"recursive", ListOptions{Prefix: "prod/", Recursive: true}, []string{"prod/app", "prod/db", "prod/network/vpc"}, nil, 0},
+        {"newest first", ListOptions{Recursive: true, Sort: "modified", Descending: true}, []string{"staging/app", "prod/db", "prod/network/vpc", "prod/app"}, nil, 0},
+        {"modified after", ListOptions{Recursive: true, ModifiedAfter: base.Add(90 * time.Second)}, []string{"prod/db", "staging/app"}, nil, 0},
+        {"first page", ListOptions{Recursive: true, Limit: 3}, []string{"prod/app", "prod/db", "prod/network/vpc"}, nil, 3},
+        {"last page", ListOptions{Recursive: true, Limit: 3, Offset: 3}, []string{"staging/app"}, nil, 0},
+    }
+    for _, test := range tests {
+        page, err := Query(tempDir, test.opts)
+        if err != nil {
+            t.Fatalf("%s: Query failed: %v", test.name, err)
+        }
+        if paths := listPaths(page); !reflect.DeepEqual(paths, test.paths) {
+            t.Errorf("%s: states = %v; want %v", test.name, paths, test.paths)
+        }
+        if !reflect.DeepEqual(page.Prefixes, test.prefixes) {
+            t.Errorf("%s: prefixes = %v; want %v", test.name, page.Prefixes, test.prefixes)
+        }
+        if page.NextOffset != test.next {
+            t.Errorf("%s: next offset = %d; want %d", test.name, page.NextOffset, test.next)
+        }
+    }
+}
+
+func TestHandleStatesList(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    writeStates(t, tempDir, time.Now(), "prod/app")
+    lockFile := filepath.Join(tempDir, "locks", "prod", "app")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    ioutil.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+
+    req := httptest.NewRequest(http.MethodGet, "/states/?prefix=prod/&recursive=true", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    var page Page
+    if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+        t.Fatalf("Listing isn't JSON: %v", err)
+    }
+    if page.Total != 1 || len(page.States) != 1 || page.States[0].Metadata == nil {
+        t.Fatalf("Listing = %s; want prod/app with metadata", rr.Body.String())
+    }
+    expected := Metadata{Serial: 3, Lineage: "abc", TerraformVersion: "1.9.0", Resources: 2, Locked: true}
+    if got := *page.States[0].Metadata; got != expected {
+        t.Errorf("Metadata = %+v; want %+v", got, expected)
+    }
+
+    for _, query := range []string{"sort=serial", "order=up", "limit=0", "limit=5000", "modified_after=yesterday", "recursive=maybe"} {
+        req := httptest.NewRequest(http.MethodGet, "/states/?"+query, nil)
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if status := rr.Code; status != http.StatusBadRequest {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+        }
+    }
+}
+
+func TestHandleStatesListIndexed(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    ix, err := index.Open(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to open index: %v", err)
+    }
+    defer ix.Close()
+    index.Activate(ix)
+    defer index.Activate(nil)
+
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app", strings.NewReader(`{"serial": 5, "lineage": "indexed"}`))
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    // A state written behind the index's back isn't listed until the next sync
+    writeStates(t, tempDir, time.Now(), "prod/unindexed")
+
+    page, err := Query(tempDir, ListOptions{Recursive: true})
+    if err != nil {
+        t.Fatalf("Query failed: %v", err)
+    }
+    if paths := listPaths(page); !reflect.DeepEqual(paths, []string{"prod/app"}) {
+        t.Fatalf("Indexed listing = %v; want only prod/app", paths)
+    }
+    if page.States[0].Serial != 5 || page.States[0].Lineage != "indexed" {
+        t.Errorf("Indexed metadata = %+v; want serial 5", page.States[0].Metadata)
+    }
+
+    req = httptest.NewRequest(http.MethodDelete, "/states/prod/app", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    if page, _ := Query(tempDir, ListOptions{Recursive: true}); page.Total != 0 {
+        t.Errorf("Listing after delete = %v; want nothing", listPaths(page))
+    }
+}
@@ -0,0 +1,175 @@
+package states
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/aliases"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tombstones"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/utils"
+)
+
+// ErrExists is returned when a move or copy would overwrite a state without being forced to
+var ErrExists = errors.New("destination state exists")
+
+// ErrInvalidPath is returned when a state can't be moved or copied to the destination path
+var ErrInvalidPath = errors.New("invalid destination path")
+
+// actions are the operations on a state requested as POST /states/<path>:<action>
+var actions = map[string]bool{"move": true, "copy": true, "restore": true}
+
+// actionRequest splits a request path of the form <path>:<action> into the state's path and the action
+func actionRequest(statePath string) (string, string, bool) {
+    i := strings.LastIndex(statePath, ":")
+    if i < 0 || !actions[statePath[i+1:]] {
+        return "", "", false
+    }
+    return statePath[:i], statePath[i+1:], true
+}
+
+// Move moves the state at src and its history to dst, leaving an alias at src
+// in MOVE_ALIAS_MODE, or when that is none a tombstone for MOVE_TOMBSTONE_TTL.
+// Either state being locked is refused, as is overwriting an existing state at
+// dst unless force is set.
+func Move(dataDir, src, dst string, force bool, edit Edit) error {
+    return transfer(dataDir, src, dst, force, true, edit)
+}
+
+// Copy copies the state at src and its history to dst, with the same checks as Move
+func Copy(dataDir, src, dst string, force bool, edit Edit) error {
+    return transfer(dataDir, src, dst, force, false, edit)
+}
+
+func transfer(dataDir, src, dst string, force, move bool, edit Edit) error {
+    if dst == "/" || dst == src || strings.HasPrefix(dst, src+"/") {
+        return ErrInvalidPath
+    }
+    defer lockPaths(dataDir, src, dst)()
+    for _, path := range []string{src, dst} {
+        if err := checkUnlocked(dataDir, path, ""); err != nil {
+            return err
+        }
+    }
+    srcFile, dstFile := FilePath(dataDir, src), FilePath(dataDir, dst)
+    if _, err := os.Stat(srcFile); err != nil {
+        return err
+    }
+    now := time.Now()
+    verb := "copy"
+    if move {
+        verb = "move"
+    }
+    if info, err := os.Stat(dstFile); err == nil {
+        if info.IsDir() {
+            return ErrInvalidPath
+        }
+        if !force {
+            return ErrExists
+        }
+        // Keep the state being overwritten when retention is enabled
+        change := history.Change{Operation: "overwritten by " + verb + " from " + strings.TrimPrefix(src, "/"), Operator: edit.Operator, Reason: edit.Reason}
+        if err := saveHistory(dataDir, dst, dstFile, &change); err != nil {
+            return err
+        }
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+
+    if move {
+        if err := os.MkdirAll(filepath.Dir(dstFile), 0755); err != nil {
+            return err
+        }
+        // Leave the alias or tombstone before the state leaves src, so clients
+        // of the old path are never answered as if it was never stored
+        if err := markMoved(dataDir, src, dst, now, edit.Operator); err != nil {
+            return err
+        }
+        if err := os.Rename(srcFile, dstFile); err != nil {
+            unmarkMoved(dataDir, src)
+            return err
+        }
+        if err := history.Move(dataDir, src, dst); err != nil {
+            // Put the state back rather than leave it moved without its history
+            os.Rename(dstFile, srcFile)
+            unmarkMoved(dataDir, src)
+            return err
+        }
+        index.Refresh(srcFile)
+        index.Refresh(dstFile)
+    } else {
+        file, err := os.Open(srcFile)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        if err := replaceFile(dstFile, file, -1, func() error { return nil }); err != nil {
+            return err
+        }
+        if err := history.Copy(dataDir, src, dst); err != nil {
+            return err
+        }
+    }
+    if err := aliases.Remove(dataDir, dst); err != nil {
+        return err
+    }
+    return tombstones.Remove(dataDir, dst)
+}
+
+// markMoved leaves an alias or, with MOVE_ALIAS_MODE none, a tombstone at src
+// pointing at dst
+func markMoved(dataDir, src, dst string, now time.Time, operator string) error {
+    if mode, ok := aliases.DefaultMode(); ok {
+        return aliases.Write(dataDir, aliases.Alias{Path: src, Target: dst, Mode: mode, Created: now, Operator: operator})
+    } else if ttl := config.GetEnvDuration("MOVE_TOMBSTONE_TTL", 7*24*time.Hour); ttl > 0 {
+        return tombstones.Write(dataDir, src, dst, now, ttl)
+    }
+    return nil
+}
+
+// unmarkMoved removes what markMoved left at src when the move failed
+func unmarkMoved(dataDir, src string) {
+    aliases.Remove(dataDir, src)
+    tombstones.Remove(dataDir, src)
+}
+
+func transferState(w http.ResponseWriter, r *http.Request, dataDir, src, action string) {
+    to := r.URL.Query().Get("to")
+    _, dst := utils.SplitPath("/states/" + to)
+    if to == "" {
+        http.Error(w, "A destination path is required in to", http.StatusBadRequest)
+        return
+    }
+    edit, ok := editRequest(w, r)
+    if !ok {
+        return
+    }
+    force := r.URL.Query().Get("force") == "true"
+    // A copy adds a state, which must fit the tenant's quota like any write
+    if info, err := os.Stat(FilePath(dataDir, src)); err == nil && action == "copy" && !quotaAllows(w, r, dataDir, FilePath(dataDir, dst), info.Size()) {
+        return
+    }
+    _, span := tracing.Start(r.Context(), "storage."+action, attribute.String("state.destination", dst))
+    var err error
+    if action == "move" {
+        err = Move(dataDir, src, dst, force, edit)
+    } else {
+        err = Copy(dataDir, src, dst, force, edit)
+    }
+    tracing.End(span, err)
+    if editFailed(w, r, err) {
+        return
+    }
+    w.WriteHeader(http.StatusOK)
+    slog.InfoContext(r.Context(), "Transferred state", "action", action, "path", src, "to", dst, "operator", edit.Operator, "reason", edit.Reason)
+}
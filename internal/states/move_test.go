@@ -0,0 +1,206 @@
+package states
+
+import (
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "terraform-http-backend/internal/aliases"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/tenants"
+)
+
+func TestHandleStatesMoveCopy(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("RETENTION_VERSIONS", "5")
+    // Leave tombstones rather than aliases at old paths
+    t.Setenv("MOVE_ALIAS_MODE", "none")
+    for _, serial := range []string{"1", "2"} {
+        req := httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": `+serial+`}`))
+        HandleStates(httptest.NewRecorder(), req, tempDir)
+    }
+    writeSurgeryState(t, tempDir, "/prod/db")
+    lockFile := filepath.Join(tempDir, "locks", "prod", "locked")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    os.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+
+    tests := []struct {
+        description    string
+        path           string
+        expectedStatus int
+    }{
+        {"copy", "/states/prod/app:copy?to=staging/app&reason=reorg", http.StatusOK},
+        {"move", "/states/prod/app:move?to=prod/apps/web&reason=reorg", http.StatusOK},
+        {"missing destination", "/states/prod/db:move?reason=reorg", http.StatusBadRequest},
+        {"missing reason", "/states/prod/db:move?to=prod/other", http.StatusBadRequest},
+        {"onto itself", "/states/prod/db:move?to=prod/db&reason=reorg", http.StatusBadRequest},
+        {"onto a directory of states", "/states/prod/db:move?to=prod/apps&reason=reorg", http.StatusBadRequest},
+        {"existing destination", "/states/prod/db:move?to=staging/app&reason=reorg", http.StatusConflict},
+        {"locked destination", "/states/prod/db:move?to=prod/locked&reason=reorg", http.StatusLocked},
+        {"missing source", "/states/prod/none:copy?to=prod/other&reason=reorg", http.StatusNotFound},
+        {"forced", "/states/prod/db:copy?to=staging/app&force=true&reason=reorg", http.StatusOK},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodPost, test.path, nil)
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v (%s)", test.description, status, test.expectedStatus, rr.Body.String())
+        }
+    }
+
+    if data, err := os.ReadFile(FilePath(tempDir, "/prod/apps/web")); err != nil || string(data) != `{"serial": 2}` {
+        t.Errorf("Moved state = %q, %v; want the latest state", data, err)
+    }
+    if versions, _ := history.List(tempDir, "/prod/apps/web"); len(versions) != 1 {
+        t.Errorf("Moved state has %d versions; want its history carried along", len(versions))
+    }
+    if versions, _ := history.List(tempDir, "/prod/app"); len(versions) != 0 {
+        t.Errorf("Old path kept %d versions; want none", len(versions))
+    }
+    // The copy's own version plus the state the forced copy overwrote
+    if versions, _ := history.List(tempDir, "/staging/app"); len(versions) != 2 || versions[0].Change == nil {
+        t.Errorf("Copied state has versions %+v; want 2, the newest overwritten by a copy", versions)
+    }
+
+    for _, test := range []struct {
+        method string
+        path   string
+    }{
+        {http.MethodGet, "/states/prod/app"},
+        {http.MethodPost, "/states/prod/app"},
+        {http.MethodGet, "/states/prod/app/outputs"},
+    } {
+        req := httptest.NewRequest(test.method, test.path, bytes.NewBufferString("{}"))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if status := rr.Code; status != http.StatusGone {
+            t.Errorf("%s %s: Handler returned wrong status code: got %v want %v", test.method, test.path, status, http.StatusGone)
+        }
+    }
+
+    // Moving a state back onto the old path clears its tombstone
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/apps/web:move?to=prod/app&reason=reorg", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    req = httptest.NewRequest(http.MethodGet, "/states/prod/app", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code after moving back: got %v want %v", status, http.StatusOK)
+    }
+}
+
+func TestHandleStatesClearTombstone(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("MOVE_ALIAS_MODE", "none")
+    writeSurgeryState(t, tempDir, "/prod/app")
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app:move?to=prod/web&reason=reorg", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+
+    req = httptest.NewRequest(http.MethodDelete, "/states/prod/app?force=true", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code clearing the tombstone: got %v want %v", status, http.StatusOK)
+    }
+
+    // A new state can be stored at the old path before the tombstone would have expired
+    req = httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": 1}`))
+    rr = httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code writing the old path: got %v want %v", status, http.StatusOK)
+    }
+}
+
+func TestHandleStatesMovedAlias(t *testing.T) {
+    for _, mode := range []string{"redirect", "forward"} {
+        tempDir := t.TempDir()
+        t.Setenv("MOVE_ALIAS_MODE", mode)
+        writeSurgeryState(t, tempDir, "/prod/app")
+        req := httptest.NewRequest(http.MethodPost, "/states/prod/app:move?to=prod/web&reason=reorg", nil)
+        HandleStates(httptest.NewRecorder(), req, tempDir)
+
+        req = httptest.NewRequest(http.MethodGet, "/states/prod/app/outputs?x=1", nil)
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if mode == "redirect" {
+            if status := rr.Code; status != http.StatusPermanentRedirect {
+                t.Errorf("%s: Handler returned wrong status code: got %v want %v", mode, status, http.StatusPermanentRedirect)
+            }
+            if location := rr.Header().Get("Location"); location != "/states/prod/web/outputs?x=1" {
+                t.Errorf("%s: Location = %q; want the new path", mode, location)
+            }
+            continue
+        }
+        if status := rr.Code; status != http.StatusOK {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", mode, status, http.StatusOK)
+        }
+        if rr.Header().Get("Warning") == "" {
+            t.Errorf("%s: Forwarded response doesn't explain the move", mode)
+        }
+
+        // Writes to the old path land in the moved state
+        req = httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": 9}`))
+        HandleStates(httptest.NewRecorder(), req, tempDir)
+        if data, err := os.ReadFile(FilePath(tempDir, "/prod/web")); err != nil || string(data) != `{"serial": 9}` {
+            t.Errorf("%s: Moved state = %q, %v; want the forwarded write", mode, data, err)
+        }
+        if _, err := os.Stat(FilePath(tempDir, "/prod/app")); !os.IsNotExist(err) {
+            t.Errorf("%s: Forwarded write recreated the old path", mode)
+        }
+    }
+}
+
+func TestHandleStatesMoveFailureKeepsState(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    os.MkdirAll(history.Dir(tempDir, "/prod/app"), 0755)
+    os.WriteFile(filepath.Join(history.Dir(tempDir, "/prod/app"), "1.tfstate"), []byte(`{"serial": 1}`), 0644)
+    // A file where the destination's history goes makes carrying it along fail
+    os.MkdirAll(filepath.Dir(history.Dir(tempDir, "/prod/web")), 0755)
+    os.WriteFile(history.Dir(tempDir, "/prod/web"), nil, 0644)
+
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app:move?to=prod/web&reason=reorg", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusInternalServerError {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+    }
+    if _, err := os.Stat(FilePath(tempDir, "/prod/app")); err != nil {
+        t.Errorf("Failed move didn't put the state back: %v", err)
+    }
+    if _, err := os.Stat(FilePath(tempDir, "/prod/web")); !os.IsNotExist(err) {
+        t.Errorf("Failed move left the state at its destination")
+    }
+    if alias, _ := aliases.Read(tempDir, "/prod/app"); alias != nil {
+        t.Errorf("Failed move left an alias at the old path")
+    }
+}
+
+func TestHandleStatesCopyTenantQuota(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    size := int64(len(surgeryState))
+    for _, test := range []struct {
+        description    string
+        quota          tenants.Quota
+        expectedStatus int
+    }{
+        {"state quota", tenants.Quota{MaxStates: 1}, http.StatusInsufficientStorage},
+        {"byte quota", tenants.Quota{MaxBytes: 2*size - 1}, http.StatusInsufficientStorage},
+        {"within quota", tenants.Quota{MaxStates: 2, MaxBytes: 2 * size}, http.StatusOK},
+    } {
+        tenant := &tenants.Tenant{Name: "payments", Quota: test.quota}
+        req := httptest.NewRequest(http.MethodPost, "/states/prod/app:copy?to=prod/copy&reason=reorg", nil)
+        req = req.WithContext(tenants.WithTenant(req.Context(), tenant))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.description, status, test.expectedStatus)
+        }
+    }
+}
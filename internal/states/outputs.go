@@ -0,0 +1,72 @@
+package states
+
+import (
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "os"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/utils"
+)
+
+// SensitiveMask replaces the value of sensitive outputs for callers without
+// the read-sensitive permission
+const SensitiveMask = "<sensitive>"
+
+// readOutputs serves a state's outputs as a JSON object of their values, or
+// the value of the single output name
+func readOutputs(w http.ResponseWriter, r *http.Request, dataDir, statePath, name string) {
+    statefilePath := FilePath(dataDir, statePath)
+    _, span := tracing.Start(r.Context(), "storage.read", attribute.String("state.output", name))
+    data, err := os.ReadFile(statefilePath)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HandleFileError(w, r, statefilePath, err)
+        return
+    }
+    var state struct {
+        Outputs map[string]struct {
+            Value     json.RawMessage `json:"value"`
+            Sensitive bool            `json:"sensitive"`
+        } `json:"outputs"`
+    }
+    if err := json.Unmarshal(data, &state); err != nil {
+        http.Error(w, "State is not valid JSON", http.StatusUnprocessableEntity)
+        return
+    }
+    readSensitive := auth.CanReadSensitive(r)
+
+    if name != "" {
+        output, ok := state.Outputs[name]
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        if output.Sensitive && !readSensitive {
+            http.Error(w, "Output is sensitive", http.StatusForbidden)
+            slog.WarnContext(r.Context(), "Refused sensitive output", "path", statePath, "output", name)
+            return
+        }
+        if output.Value == nil {
+            output.Value = json.RawMessage("null")
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusOK)
+        w.Write(output.Value)
+        return
+    }
+
+    values := make(map[string]json.RawMessage, len(state.Outputs))
+    for name, output := range state.Outputs {
+        if output.Sensitive && !readSensitive {
+            values[name] = json.RawMessage(`"` + SensitiveMask + `"`)
+        } else {
+            values[name] = output.Value
+        }
+    }
+    utils.WriteJSON(w, values)
+}
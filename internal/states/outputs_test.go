@@ -0,0 +1,65 @@
+package states
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "terraform-http-backend/internal/auth"
+)
+
+func TestHandleStatesGetOutputs(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.MkdirAll(filepath.Join(tempDir, "states", "prod"), 0755)
+    if err := ioutil.WriteFile(FilePath(tempDir, "prod/app"), []byte(summaryState), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+
+    reader := auth.Principal{Username: "ci", Role: auth.RoleReadOnly}
+    trusted := auth.Principal{Username: "deploy", Role: auth.RoleReadOnly, ReadSensitive: true}
+    tests := []struct {
+        description    string
+        path           string
+        principal      auth.Principal
+        expectedStatus int
+        expectedBody   string
+    }{
+        {"all outputs masked", "/states/prod/app/outputs", reader, http.StatusOK, `{"db_password":"<sensitive>","vpc_id":"vpc-1"}`},
+        {"all outputs with permission", "/states/prod/app/outputs", trusted, http.StatusOK, `{"db_password":"hunter2","vpc_id":"vpc-1"}`},
+        {"single output", "/states/prod/app/outputs/vpc_id", reader, http.StatusOK, `"vpc-1"`},
+        {"single sensitive output", "/states/prod/app/outputs/db_password", reader, http.StatusForbidden, ""},
+        {"single sensitive output with permission", "/states/prod/app/outputs/db_password", trusted, http.StatusOK, `"hunter2"`},
+        {"missing output", "/states/prod/app/outputs/nope", reader, http.StatusNotFound, ""},
+        {"missing state", "/states/prod/other/outputs", reader, http.StatusNotFound, ""},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodGet, test.path, nil)
+        req = req.WithContext(auth.WithPrincipal(req.Context(), test.principal))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.description, status, test.expectedStatus)
+        }
+        if test.expectedBody == "" {
+            continue
+        }
+        var got, want interface{}
+        if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+            t.Errorf("%s: Response isn't JSON: %s", test.description, rr.Body.String())
+        }
+        json.Unmarshal([]byte(test.expectedBody), &want)
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: Handler returned unexpected body: got %v want %v", test.description, rr.Body.String(), test.expectedBody)
+        }
+    }
+}
@@ -0,0 +1,232 @@
+package states
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/utils"
+)
+
+// Resource is a resource instance parsed from a state, served by GET /states/<path>/resources
+type Resource struct {
+    Address    string                 `json:"address"`
+    Module     string                 `json:"module,omitempty"`
+    Mode       string                 `json:"mode"`
+    Type       string                 `json:"type"`
+    Name       string                 `json:"name"`
+    IndexKey   interface{}            `json:"index_key,omitempty"`
+    Provider   string                 `json:"provider"`
+    Attributes map[string]interface{} `json:"attributes"`
+}
+
+// ResourceFilter selects resource instances and which of their attributes are returned
+type ResourceFilter struct {
+    Type string
+    // Module matches exactly, "root" selects the root module
+    Module string
+    Mode   string
+    // Attributes limits the attributes returned, all when empty
+    Attributes []string
+    // Where matches top-level attribute values, compared as strings
+    Where map[string]string
+}
+
+// ParseResourceFilter reads a filter from the type, module, mode, attributes
+// and where query parameters
+func ParseResourceFilter(query url.Values) (ResourceFilter, error) {
+    filter := ResourceFilter{
+        Type:   query.Get("type"),
+        Module: query.Get("module"),
+        Mode:   query.Get("mode"),
+        Where:  map[string]string{},
+    }
+    switch filter.Mode {
+    case "", "managed", "data":
+    default:
+        return filter, fmt.Errorf("invalid mode %q, must be managed or data", filter.Mode)
+    }
+    if value := query.Get("attributes"); value != "" {
+        for _, name := range strings.Split(value, ",") {
+            if name = strings.TrimSpace(name); name != "" {
+                filter.Attributes = append(filter.Attributes, name)
+            }
+        }
+    }
+    for _, value := range query["where"] {
+        name, want, ok := strings.Cut(value, "=")
+        if !ok || name == "" {
+            return filter, fmt.Errorf("invalid where %q, must be <attribute>=<value>", value)
+        }
+        filter.Where[name] = want
+    }
+    return filter, nil
+}
+
+// Resources parses the resource instances of a state document that match
+// filter. Sensitive attributes are replaced by SensitiveMask unless
+// readSensitive is set.
+func Resources(data []byte, filter ResourceFilter, readSensitive bool) ([]Resource, error) {
+    var state struct {
+        Resources []struct {
+            Module    string `json:"module"`
+            Mode      string `json:"mode"`
+            Type      string `json:"type"`
+            Name      string `json:"name"`
+            Provider  string `json:"provider"`
+            Instances []struct {
+                IndexKey            interface{}                `json:"index_key"`
+                Attributes          map[string]interface{}     `json:"attributes"`
+                SensitiveAttributes [][]map[string]interface{} `json:"sensitive_attributes"`
+            } `json:"instances"`
+        } `json:"resources"`
+    }
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if err := decoder.Decode(&state); err != nil {
+        return nil, err
+    }
+    module := filter.Module
+    if module == "root" {
+        module = ""
+    }
+    resources := []Resource{}
+    for _, resource := range state.Resources {
+        if filter.Type != "" && resource.Type != filter.Type ||
+            filter.Module != "" && resource.Module != module ||
+            filter.Mode != "" && resource.Mode != filter.Mode {
+            continue
+        }
+        for _, instance := range resource.Instances {
+            if instance.Attributes == nil {
+                instance.Attributes = map[string]interface{}{}
+            }
+            if !readSensitive {
+                for _, path := range instance.SensitiveAttributes {
+                    redact(instance.Attributes, path)
+                }
+            }
+            if !matches(instance.Attributes, filter.Where) {
+                continue
+            }
+            attributes := instance.Attributes
+            if len(filter.Attributes) > 0 {
+                attributes = map[string]interface{}{}
+                for _, name := range filter.Attributes {
+                    if value, ok := instance.Attributes[name]; ok {
+                        attributes[name] = value
+                    }
+                }
+            }
+            resources = append(resources, Resource{
+                Address:    index.Address(resource.Module, resource.Mode, resource.Type, resource.Name, instance.IndexKey),
+                Module:     resource.Module,
+                Mode:       resource.Mode,
+                Type:       resource.Type,
+                Name:       resource.Name,
+                IndexKey:   instance.IndexKey,
+                Provider:   index.ProviderName(resource.Provider),
+                Attributes: attributes,
+            })
+        }
+    }
+    return resources, nil
+}
+
+// redact masks the value at path, a list of get_attr and index steps as
+// recorded in an instance's sensitive_attributes
+func redact(value interface{}, path []map[string]interface{}) {
+    for i, step := range path {
+        last := i == len(path)-1
+        switch container := value.(type) {
+        case map[string]interface{}:
+            key, ok := step["value"].(string)
+            if !ok {
+                return
+            }
+            if _, ok := container[key]; !ok {
+                return
+            }
+            if last {
+                container[key] = SensitiveMask
+                return
+            }
+            value = container[key]
+        case []interface{}:
+            number, ok := step["value"].(json.Number)
+            if !ok {
+                return
+            }
+            n, err := strconv.Atoi(number.String())
+            if err != nil || n < 0 || n >= len(container) {
+                return
+            }
+            if last {
+                container[n] = SensitiveMask
+                return
+            }
+            value = container[n]
+        default:
+            return
+        }
+    }
+}
+
+// matches reports whether every attribute in where has the wanted value
+func matches(attributes map[string]interface{}, where map[string]string) bool {
+    for name, want := range where {
+        value, ok := attributes[name]
+        if !ok || value == nil || fmt.Sprint(value) != want {
+            return false
+        }
+    }
+    return true
+}
+
+// readResources serves a state's resource instances, or the single instance at address
+func readResources(w http.ResponseWriter, r *http.Request, dataDir, statePath, address string) {
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && principal.OutputsOnly {
+        http.Error(w, "Resources are not readable with outputs-only access", http.StatusForbidden)
+        return
+    }
+    filter, err := ParseResourceFilter(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    statefilePath := FilePath(dataDir, statePath)
+    _, span := tracing.Start(r.Context(), "storage.read", attribute.String("state.resource", address))
+    data, err := os.ReadFile(statefilePath)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HandleFileError(w, r, statefilePath, err)
+        return
+    }
+    resources, err := Resources(data, filter, auth.CanReadSensitive(r))
+    if err != nil {
+        http.Error(w, "State is not valid JSON", http.StatusUnprocessableEntity)
+        return
+    }
+
+    if address != "" {
+        for _, resource := range resources {
+            if resource.Address == address {
+                utils.WriteJSON(w, resource)
+                return
+            }
+        }
+        http.NotFound(w, r)
+        return
+    }
+    utils.WriteJSON(w, map[string][]Resource{"resources": resources})
+}
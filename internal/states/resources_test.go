@@ -0,0 +1,139 @@
+package states
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "terraform-http-backend/internal/auth"
+)
+
+const resourcesState = `{
+  "version": 4,
+  "serial": 3,
+  "resources": [
+    {"mode": "managed", "type": "aws_security_group", "name": "web", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"attributes": {"id": "sg-1", "name": "web"}}]},
+    {"module": "module.vpc", "mode": "managed", "type": "aws_subnet", "name": "private", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"index_key": 0, "attributes": {"id": "subnet-1", "cidr_block": "10.0.0.0/24"}}, {"index_key": 1, "attributes": {"id": "subnet-2", "cidr_block": "10.0.1.0/24"}}]},
+    {"module": "module.db", "mode": "managed", "type": "aws_db_instance", "name": "main", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"index_key": "primary", "attributes": {"id": "db-1", "password": "hunter2", "users": [{"name": "app", "token": "t0ken"}]},
+                    "sensitive_attributes": [[{"type": "get_attr", "value": "password"}], [{"type": "get_attr", "value": "users"}, {"type": "index", "value": 0}, {"type": "get_attr", "value": "token"}]]}]},
+    {"mode": "data", "type": "aws_ami", "name": "ubuntu", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"attributes": {"id": "ami-1"}}]}
+  ]
+}`
+
+func resourceAddresses(resources []Resource) []string {
+    addresses := []string{}
+    for _, resource := range resources {
+        addresses = append(addresses, resource.Address)
+    }
+    return addresses
+}
+
+func TestResources(t *testing.T) {
+    tests := []struct {
+        query     string
+        addresses []string
+    }{
+        {"", []string{"aws_security_group.web", "module.vpc.aws_subnet.private[0]", "module.vpc.aws_subnet.private[1]", `module.db.aws_db_instance.main["primary"]`, "data.aws_ami.ubuntu"}},
+        {"type=aws_subnet", []string{"module.vpc.aws_subnet.private[0]", "module.vpc.aws_subnet.private[1]"}},
+        {"module=root", []string{"aws_security_group.web", "data.aws_ami.ubuntu"}},
+        {"module=root&mode=managed", []string{"aws_security_group.web"}},
+        {"where=id=subnet-2", []string{"module.vpc.aws_subnet.private[1]"}},
+        {"where=id=sg-1&where=name=other", []string{}},
+    }
+    for _, test := range tests {
+        query, _ := url.ParseQuery(test.query)
+        filter, err := ParseResourceFilter(query)
+        if err != nil {
+            t.Fatalf("%q: ParseResourceFilter failed: %v", test.query, err)
+        }
+        resources, err := Resources([]byte(resourcesState), filter, false)
+        if err != nil {
+            t.Fatalf("%q: Resources failed: %v", test.query, err)
+        }
+        if addresses := resourceAddresses(resources); !reflect.DeepEqual(addresses, test.addresses) {
+            t.Errorf("%q: addresses = %v; want %v", test.query, addresses, test.addresses)
+        }
+    }
+
+    resources, _ := Resources([]byte(resourcesState), ResourceFilter{Type: "aws_db_instance"}, false)
+    if len(resources) != 1 {
+        t.Fatalf("Resources = %v; want the database", resourceAddresses(resources))
+    }
+    attributes := resources[0].Attributes
+    if attributes["password"] != SensitiveMask || attributes["users"].([]interface{})[0].(map[string]interface{})["token"] != SensitiveMask {
+        t.Errorf("Sensitive attributes weren't redacted: %v", attributes)
+    }
+    if attributes["id"] != "db-1" || resources[0].Provider != "registry.terraform.io/hashicorp/aws" {
+        t.Errorf("Resource = %+v; want db-1 from the aws provider", resources[0])
+    }
+    resources, _ = Resources([]byte(resourcesState), ResourceFilter{Type: "aws_db_instance"}, true)
+    if resources[0].Attributes["password"] != "hunter2" {
+        t.Errorf("Sensitive attributes were redacted with permission: %v", resources[0].Attributes)
+    }
+}
+
+func TestHandleStatesGetResources(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.MkdirAll(filepath.Join(tempDir, "states", "prod"), 0755)
+    if err := ioutil.WriteFile(FilePath(tempDir, "prod/app"), []byte(resourcesState), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+
+    reader := auth.Principal{Username: "ci", Role: auth.RoleReadOnly}
+    outputsOnly := auth.Principal{Username: "ci", Role: auth.RoleReadOnly, OutputsOnly: true}
+    tests := []struct {
+        description    string
+        path           string
+        principal      auth.Principal
+        expectedStatus int
+        expectedBody   string
+    }{
+        {"filtered with attributes", "/states/prod/app/resources?type=aws_subnet&attributes=id", reader, http.StatusOK,
+            `{"resources": [
+                {"address": "module.vpc.aws_subnet.private[0]", "module": "module.vpc", "mode": "managed", "type": "aws_subnet", "name": "private", "index_key": 0, "provider": "registry.terraform.io/hashicorp/aws", "attributes": {"id": "subnet-1"}},
+                {"address": "module.vpc.aws_subnet.private[1]", "module": "module.vpc", "mode": "managed", "type": "aws_subnet", "name": "private", "index_key": 1, "provider": "registry.terraform.io/hashicorp/aws", "attributes": {"id": "subnet-2"}}]}`},
+        {"single instance", "/states/prod/app/resources/aws_security_group.web", reader, http.StatusOK,
+            `{"address": "aws_security_group.web", "mode": "managed", "type": "aws_security_group", "name": "web", "provider": "registry.terraform.io/hashicorp/aws", "attributes": {"id": "sg-1", "name": "web"}}`},
+        {"single instance with key", "/states/prod/app/resources/" + url.PathEscape(`module.db.aws_db_instance.main["primary"]`) + "?attributes=password", reader, http.StatusOK,
+            `{"address": "module.db.aws_db_instance.main[\"primary\"]", "module": "module.db", "mode": "managed", "type": "aws_db_instance", "name": "main", "index_key": "primary", "provider": "registry.terraform.io/hashicorp/aws", "attributes": {"password": "<sensitive>"}}`},
+        {"missing instance", "/states/prod/app/resources/aws_instance.nope", reader, http.StatusNotFound, ""},
+        {"bad filter", "/states/prod/app/resources?where=id", reader, http.StatusBadRequest, ""},
+        {"outputs only", "/states/prod/app/resources", outputsOnly, http.StatusForbidden, ""},
+        {"missing state", "/states/prod/other/resources", reader, http.StatusNotFound, ""},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(http.MethodGet, test.path, nil)
+        req = req.WithContext(auth.WithPrincipal(req.Context(), test.principal))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.description, status, test.expectedStatus)
+        }
+        if test.expectedBody == "" {
+            continue
+        }
+        var got, want interface{}
+        if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+            t.Errorf("%s: Response isn't JSON: %s", test.description, rr.Body.String())
+        }
+        json.Unmarshal([]byte(test.expectedBody), &want)
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%s: Handler returned unexpected body: got %s want %s", test.description, rr.Body.String(), test.expectedBody)
+        }
+    }
+}
@@ -1,62 +1,327 @@
 package states
 
 import (
-    "io"
-    "log"
+    "encoding/json"
+    "errors"
+    "log/slog"
     "net/http"
     "os"
+    "path/filepath"
+    "strings"
+    "time"
 
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/aliases"
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/tombstones"
+    "terraform-http-backend/internal/tracing"
     "terraform-http-backend/internal/utils"
 )
 
 func HandleStates(w http.ResponseWriter, r *http.Request, dataDir string) {
-    statefilePath, dir := utils.GetFilePaths(r.URL.Path, dataDir)
+    r, handled := aliases.Apply(w, r, dataDir)
+    if handled {
+        return
+    }
+    statefilePath, _ := utils.GetFilePaths(r.URL.Path, dataDir)
+    _, statePath := utils.SplitPath(r.URL.Path)
+    ctx, span := tracing.Start(r.Context(), "states "+r.Method, attribute.String("state.path", statePath))
+    defer span.End()
+    r = r.WithContext(ctx)
+    if !strings.HasSuffix(r.URL.Path, "/") && r.Method == http.MethodDelete && r.URL.Query().Get("force") == "true" && tombstones.Clear(w, r, dataDir, statePath) {
+        return
+    }
+    if !strings.HasSuffix(r.URL.Path, "/") && tombstones.Check(w, r, dataDir, statePath) {
+        return
+    }
     switch r.Method {
     case http.MethodGet:
-        readState(w, r, statefilePath)
+        if strings.HasSuffix(r.URL.Path, "/") {
+            listStates(w, r, dataDir)
+        } else if r.URL.Query().Has("history") {
+            listHistory(w, r, dataDir, statePath)
+        } else if r.URL.Query().Has("trash") {
+            listTrash(w, r, dataDir, statePath)
+        } else if r.URL.Query().Has("summary") {
+            summarizeState(w, r, statefilePath)
+        } else if target, view, name, ok := viewRequest(dataDir, statePath); ok && view == "outputs" {
+            readOutputs(w, r, dataDir, target, name)
+        } else if ok && view == "resources" {
+            readResources(w, r, dataDir, target, name)
+        } else {
+            readState(w, r, statefilePath)
+        }
     case http.MethodPost, http.MethodPut:
-        writeState(w, r, dir, statefilePath)
+        if id := r.URL.Query().Get("rollback"); id != "" {
+            rollbackState(w, r, dataDir, statePath, id)
+        } else if src, action, ok := actionRequest(statePath); ok && action == "restore" {
+            restoreState(w, r, dataDir, src)
+        } else if ok {
+            transferState(w, r, dataDir, src, action)
+        } else if r.URL.Query().Has("bump_serial") {
+            bumpSerial(w, r, dataDir, statePath)
+        } else if target, view, name, ok := viewRequest(dataDir, statePath); ok && view == "resources" && name != "" {
+            moveResources(w, r, dataDir, target, name)
+        } else {
+            writeState(w, r, dataDir, statePath, statefilePath)
+        }
     case http.MethodDelete:
-        deleteState(w, r, statefilePath)
+        if target, view, name, ok := viewRequest(dataDir, statePath); ok && view == "resources" && name != "" {
+            removeResources(w, r, dataDir, target, name)
+        } else {
+            deleteState(w, r, dataDir, statePath, statefilePath)
+        }
     default:
         utils.MethodNotAllowed(w, r)
     }
 }
 
+// views are the parts of a state served below its path, e.g. /states/<path>/outputs
+var views = map[string]bool{"outputs": true, "resources": true}
+
+// viewRequest splits a request path of the form <path>/<view>[/<name>] into the
+// state's path, the view and the name within it. It only matches when <path>
+// is a stored state, as a state can't also be a directory holding another
+// state called .../outputs.
+func viewRequest(dataDir, statePath string) (string, string, string, bool) {
+    segments := strings.Split(strings.TrimPrefix(statePath, "/"), "/")
+    for i := 1; i < len(segments); i++ {
+        if !views[segments[i]] {
+            continue
+        }
+        target := "/" + strings.Join(segments[:i], "/")
+        if info, err := os.Stat(FilePath(dataDir, target)); err == nil && info.Mode().IsRegular() {
+            return target, segments[i], strings.Join(segments[i+1:], "/"), true
+        }
+    }
+    return "", "", "", false
+}
+
+func listStates(w http.ResponseWriter, r *http.Request, dataDir string) {
+    opts, err := ParseListOptions(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    _, span := tracing.Start(r.Context(), "storage.list", attribute.String("list.prefix", opts.Prefix))
+    page, err := Query(dataDir, opts)
+    span.SetAttributes(attribute.Int("list.total", page.Total))
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error listing states", err)
+        return
+    }
+    utils.WriteJSON(w, page)
+}
+
+func listHistory(w http.ResponseWriter, r *http.Request, dataDir, statePath string) {
+    _, span := tracing.Start(r.Context(), "storage.history")
+    versions, err := history.List(dataDir, statePath)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error listing state history", err)
+        return
+    }
+    utils.WriteJSON(w, map[string]interface{}{"versions": versions})
+}
+
+func rollbackState(w http.ResponseWriter, r *http.Request, dataDir, statePath, id string) {
+    _, span := tracing.Start(r.Context(), "storage.rollback", attribute.String("state.version", id))
+    err := Rollback(dataDir, statePath, id)
+    tracing.End(span, err)
+    if err == ErrLocked {
+        http.Error(w, "State is locked", http.StatusLocked)
+        return
+    } else if errors.Is(err, os.ErrNotExist) {
+        http.NotFound(w, r)
+        return
+    } else if err != nil {
+        utils.HTTPError(w, r, "Error rolling back state", err)
+        return
+    }
+    w.WriteHeader(http.StatusOK)
+    slog.InfoContext(r.Context(), "Rolled back state", "path", statePath, "version", id)
+}
+
 func readState(w http.ResponseWriter, r *http.Request, statefilePath string) {
+    _, span := tracing.Start(r.Context(), "storage.read")
     data, err := os.ReadFile(statefilePath)
+    span.SetAttributes(attribute.Int("state.bytes", len(data)))
+    tracing.End(span, err)
     if err != nil {
         utils.HandleFileError(w, r, statefilePath, err)
         return
     }
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && principal.OutputsOnly {
+        if data, err = outputsOnly(data, auth.CanReadSensitive(r)); err != nil {
+            utils.HTTPError(w, r, "Error stripping state", err)
+            return
+        }
+    }
     w.WriteHeader(http.StatusOK)
     w.Write(data)
 }
 
-func writeState(w http.ResponseWriter, r *http.Request, dir, statefilePath string) {
-    if err := os.MkdirAll(dir, 0755); err != nil {
-        utils.HTTPError(w, "Error creating directory", err)
-        return
+// outputsOnly strips every section of a state except its header and outputs,
+// so consumers of terraform_remote_state can't read resource attributes.
+// Sensitive output values are masked unless readSensitive is set.
+func outputsOnly(data []byte, readSensitive bool) ([]byte, error) {
+    var state map[string]json.RawMessage
+    if err := json.Unmarshal(data, &state); err != nil {
+        return nil, err
+    }
+    if outputs, ok := state["outputs"]; ok && !readSensitive {
+        masked, err := maskOutputs(outputs)
+        if err != nil {
+            return nil, err
+        }
+        state["outputs"] = masked
+    }
+    stripped := map[string]json.RawMessage{
+        "resources": json.RawMessage("[]"),
+    }
+    for _, key := range []string{"version", "terraform_version", "serial", "lineage", "outputs"} {
+        if value, ok := state[key]; ok {
+            stripped[key] = value
+        }
     }
-    file, err := os.Create(statefilePath)
+    return json.Marshal(stripped)
+}
+
+// maskOutputs replaces the values of sensitive outputs in a state's outputs
+// section with SensitiveMask, leaving it untouched when there are none
+func maskOutputs(data json.RawMessage) (json.RawMessage, error) {
+    var outputs map[string]map[string]json.RawMessage
+    if err := json.Unmarshal(data, &outputs); err != nil {
+        return nil, err
+    }
+    var masked bool
+    for _, output := range outputs {
+        if string(output["sensitive"]) == "true" {
+            output["value"] = json.RawMessage(`"` + SensitiveMask + `"`)
+            masked = true
+        }
+    }
+    if !masked {
+        return data, nil
+    }
+    return json.Marshal(outputs)
+}
+
+func writeState(w http.ResponseWriter, r *http.Request, dataDir, statePath, statefilePath string) {
+    limit, err := quotaLimit(w, r, dataDir, statefilePath)
     if err != nil {
-        utils.HTTPError(w, "Error creating file", err)
+        utils.HTTPError(w, r, "Error checking tenant quota", err)
+        return
+    }
+    if limit == 0 || !utils.LimitBody(w, r, config.GetEnvSize("MAX_STATE_SIZE", 128<<20)) {
         return
     }
-    defer file.Close()
-    if _, err := io.Copy(file, r.Body); err != nil {
-        utils.HTTPError(w, "Error writing to file", err)
+    _, span := tracing.Start(r.Context(), "storage.write")
+    err = saveFile(dataDir, statePath, statefilePath, r.Body, limit)
+    tracing.End(span, err)
+    if utils.BodyTooLarge(w, r, err) {
+        return
+    } else if err == errTooLarge {
+        http.Error(w, "Tenant storage quota exceeded", http.StatusInsufficientStorage)
+        slog.WarnContext(r.Context(), "Tenant storage quota exceeded", "path", statefilePath)
+        return
+    } else if err != nil {
+        utils.HTTPError(w, r, "Error writing state", err)
         return
     }
     w.WriteHeader(http.StatusOK)
-    log.Printf("Updated state %s", statefilePath)
+    slog.InfoContext(r.Context(), "Updated state", "path", statefilePath)
 }
 
-func deleteState(w http.ResponseWriter, r *http.Request, statefilePath string) {
-    if err := os.Remove(statefilePath); err != nil {
+// saveHistory keeps the state about to be replaced as a version, when retention
+// is enabled for its path, recording change when it's made through the API
+func saveHistory(dataDir, statePath, statefilePath string, change *history.Change) error {
+    retention := config.ForPath(statePath)
+    if retention.RetentionVersions == 0 {
+        return nil
+    }
+    _, err := os.Stat(statefilePath)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    now := time.Now()
+    if change != nil {
+        err = history.SaveChange(dataDir, statePath, statefilePath, now, *change)
+    } else {
+        err = history.Save(dataDir, statePath, statefilePath, now)
+    }
+    if err != nil {
+        return err
+    }
+    if err := history.Prune(dataDir, statePath, retention.RetentionVersions, retention.RetentionMaxAge, now); err != nil {
+        slog.Error("Error pruning state history", "path", statefilePath, "error", err)
+    }
+    return nil
+}
+
+// quotaLimit returns how many bytes the request may write under its tenant's
+// quota, -1 when unlimited. When the quota is already used up it writes the
+// error response and returns 0.
+func quotaLimit(w http.ResponseWriter, r *http.Request, dataDir, statefilePath string) (int64, error) {
+    tenant, ok := tenants.FromContext(r.Context())
+    if !ok || (tenant.Quota.MaxStates == 0 && tenant.Quota.MaxBytes == 0) {
+        return -1, nil
+    }
+    _, span := tracing.Start(r.Context(), "storage.usage", attribute.String("tenant", tenant.Name))
+    count, size, err := tenants.Usage(filepath.Join(dataDir, "states"), statefilePath)
+    tracing.End(span, err)
+    if err != nil {
+        return 0, err
+    }
+    if _, err := os.Stat(statefilePath); os.IsNotExist(err) && tenant.Quota.MaxStates > 0 && count >= tenant.Quota.MaxStates {
+        http.Error(w, "Tenant state quota exceeded", http.StatusInsufficientStorage)
+        slog.WarnContext(r.Context(), "Tenant state quota exceeded", "tenant", tenant.Name, "path", statefilePath)
+        return 0, nil
+    }
+    if tenant.Quota.MaxBytes == 0 {
+        return -1, nil
+    }
+    if remaining := tenant.Quota.MaxBytes - size; remaining > 0 {
+        return remaining, nil
+    }
+    http.Error(w, "Tenant storage quota exceeded", http.StatusInsufficientStorage)
+    slog.WarnContext(r.Context(), "Tenant storage quota exceeded", "tenant", tenant.Name, "path", statefilePath)
+    return 0, nil
+}
+
+// quotaAllows reports whether a state of size bytes may be stored at
+// statefilePath under the tenant's quota, writing the response when it may not
+func quotaAllows(w http.ResponseWriter, r *http.Request, dataDir, statefilePath string, size int64) bool {
+    limit, err := quotaLimit(w, r, dataDir, statefilePath)
+    if err != nil {
+        utils.HTTPError(w, r, "Error checking tenant quota", err)
+        return false
+    }
+    if limit == 0 {
+        return false
+    }
+    if limit > 0 && size > limit {
+        http.Error(w, "Tenant storage quota exceeded", http.StatusInsufficientStorage)
+        slog.WarnContext(r.Context(), "Tenant storage quota exceeded", "path", statefilePath)
+        return false
+    }
+    return true
+}
+
+// deleteState moves the state into the trash, see deleteFile
+func deleteState(w http.ResponseWriter, r *http.Request, dataDir, statePath, statefilePath string) {
+    _, span := tracing.Start(r.Context(), "storage.delete")
+    err := deleteFile(dataDir, statePath, statefilePath, operator(r))
+    tracing.End(span, err)
+    if err != nil {
         utils.HandleFileError(w, r, statefilePath, err)
         return
     }
     w.WriteHeader(http.StatusOK)
-    log.Printf("Deleted state %s", statefilePath)
-}
\ No newline at end of file
+    slog.InfoContext(r.Context(), "Deleted state", "path", statefilePath, "operator", operator(r))
+}
@@ -2,12 +2,18 @@ package states
 
 import (
     "bytes"
+    "encoding/json"
+    "fmt"
     "io/ioutil"
     "net/http"
     "net/http/httptest"
     "os"
     "path/filepath"
     "testing"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/tenants"
 )
 
 func TestHandleStatesGet(t *testing.T) {
@@ -107,4 +113,207 @@ func TestHandleStatesMethodNotAllowed(t *testing.T) {
     if status := rr.Code; status != http.StatusMethodNotAllowed {
         t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
     }
-}
\ No newline at end of file
+}
+
+func TestHandleStatesGetOutputsOnly(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+
+    testFilePath := filepath.Join(tempDir, "statefile.tfstate")
+    testData := []byte(`{"version":4,"serial":3,"lineage":"abc","outputs":{"vpc_id":{"value":"vpc-1","type":"string"}},"resources":[{"type":"aws_db_instance","instances":[{"attributes":{"password":"secret"}}]}]}`)
+    err = ioutil.WriteFile(testFilePath, testData, 0644)
+    if err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/statefile.tfstate", nil)
+    req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "reader", Role: auth.RoleReadOnly, OutputsOnly: true}))
+    rr := httptest.NewRecorder()
+
+    HandleStates(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    expected := `{"lineage":"abc","outputs":{"vpc_id":{"value":"vpc-1","type":"string"}},"resources":[],"serial":3,"version":4}`
+    if rr.Body.String() != expected {
+        t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+    }
+}
+
+func TestHandleStatesGetOutputsOnlyMasksSensitive(t *testing.T) {
+    tempDir := t.TempDir()
+    testFilePath := filepath.Join(tempDir, "statefile.tfstate")
+    testData := []byte(`{"version":4,"outputs":{"db_password":{"value":"hunter2","type":"string","sensitive":true},"vpc_id":{"value":"vpc-1","type":"string"}}}`)
+    if err := ioutil.WriteFile(testFilePath, testData, 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+
+    for _, readSensitive := range []bool{false, true} {
+        principal := auth.Principal{Username: "reader", Role: auth.RoleReadOnly, OutputsOnly: true, ReadSensitive: readSensitive}
+        req := httptest.NewRequest(http.MethodGet, "/statefile.tfstate", nil)
+        req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+
+        var state struct {
+            Outputs map[string]struct {
+                Value string `json:"value"`
+            } `json:"outputs"`
+        }
+        if err := json.Unmarshal(rr.Body.Bytes(), &state); err != nil {
+            t.Fatalf("Failed to decode state: %v", err)
+        }
+        expected := SensitiveMask
+        if readSensitive {
+            expected = "hunter2"
+        }
+        if value := state.Outputs["db_password"].Value; value != expected {
+            t.Errorf("read-sensitive %v: sensitive output = %q; want %q", readSensitive, value, expected)
+        }
+        if value := state.Outputs["vpc_id"].Value; value != "vpc-1" {
+            t.Errorf("read-sensitive %v: output = %q; want vpc-1", readSensitive, value)
+        }
+    }
+}
+
+func TestHandleStatesPutTenantQuota(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+
+    tenant := &tenants.Tenant{Name: "payments", Quota: tenants.Quota{MaxStates: 1, MaxBytes: 20}}
+    write := func(path string, data []byte) int {
+        req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+        req = req.WithContext(tenants.WithTenant(req.Context(), tenant))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        return rr.Code
+    }
+
+    if status := write("/states/first", []byte(`{"version": 4}`)); status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code for first state: got %v want %v", status, http.StatusOK)
+    }
+    if status := write("/states/second", []byte(`{"version": 4}`)); status != http.StatusInsufficientStorage {
+        t.Errorf("Handler returned wrong status code past state quota: got %v want %v", status, http.StatusInsufficientStorage)
+    }
+    if status := write("/states/first", []byte(`{"version": 4, "serial": 1000}`)); status != http.StatusInsufficientStorage {
+        t.Errorf("Handler returned wrong status code past byte quota: got %v want %v", status, http.StatusInsufficientStorage)
+    }
+
+    data, err := ioutil.ReadFile(filepath.Join(tempDir, "states", "first"))
+    if err != nil {
+        t.Fatalf("Failed to read state file: %v", err)
+    }
+    if string(data) != `{"version": 4}` {
+        t.Errorf("State was modified by a rejected write: got %v", string(data))
+    }
+}
+
+func TestHandleStatesPutKeepsHistory(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.Setenv("RETENTION_VERSIONS", "2")
+    defer os.Unsetenv("RETENTION_VERSIONS")
+
+    for serial := 1; serial <= 4; serial++ {
+        data := []byte(fmt.Sprintf(`{"serial": %d}`, serial))
+        req := httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewReader(data))
+        rr := httptest.NewRecorder()
+
+        HandleStates(rr, req, tempDir)
+
+        if status := rr.Code; status != http.StatusOK {
+            t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+        }
+    }
+
+    versions, err := history.List(tempDir, "/prod/app")
+    if err != nil {
+        t.Fatalf("Failed to list history: %v", err)
+    }
+    if len(versions) != 2 {
+        t.Fatalf("Expected 2 versions to be kept, got %d", len(versions))
+    }
+    data, err := history.Read(tempDir, "/prod/app", versions[0].ID)
+    if err != nil || string(data) != `{"serial": 3}` {
+        t.Errorf("Newest version = %q, %v; want serial 3", data, err)
+    }
+}
+
+func TestHandleStatesRollback(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.Setenv("RETENTION_VERSIONS", "5")
+    defer os.Unsetenv("RETENTION_VERSIONS")
+
+    for serial := 1; serial <= 2; serial++ {
+        if err := Save(tempDir, "/prod/app", bytes.NewReader([]byte(fmt.Sprintf(`{"serial": %d}`, serial)))); err != nil {
+            t.Fatalf("Failed to save state: %v", err)
+        }
+    }
+    versions, err := history.List(tempDir, "/prod/app")
+    if err != nil || len(versions) != 1 {
+        t.Fatalf("Expected 1 version, got %v, %v", versions, err)
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app?rollback=missing", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusNotFound {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+    }
+
+    req = httptest.NewRequest(http.MethodPost, "/states/prod/app?rollback="+versions[0].ID, nil)
+    rr = httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    data, err := ioutil.ReadFile(FilePath(tempDir, "/prod/app"))
+    if err != nil || string(data) != `{"serial": 1}` {
+        t.Errorf("State after rollback = %q, %v; want serial 1", data, err)
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/states/?prefix=prod/", nil)
+    rr = httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if !bytes.Contains(rr.Body.Bytes(), []byte(`"path":"prod/app"`)) {
+        t.Errorf("Listing doesn't contain the state: %s", rr.Body.String())
+    }
+}
+
+func TestHandleStatesPutTooLarge(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    t.Setenv("MAX_STATE_SIZE", "10")
+
+    req := httptest.NewRequest(http.MethodPost, "/statefile.tfstate", bytes.NewReader([]byte(`{"version": 4, "serial": 1}`)))
+    rr := httptest.NewRecorder()
+
+    HandleStates(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "statefile.tfstate")); !os.IsNotExist(err) {
+        t.Errorf("Oversized state was written: %v", err)
+    }
+}
@@ -0,0 +1,158 @@
+package states
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/utils"
+)
+
+// ErrLocked is returned when an operation would replace a state that is locked
+var ErrLocked = errors.New("state is locked")
+
+var errTooLarge = errors.New("state exceeds size limit")
+
+// Entry describes a stored state
+type Entry struct {
+    Path     string    `json:"path"`
+    Size     int64     `json:"size"`
+    Modified time.Time `json:"modified"`
+    // Metadata is only read for listings that ask for it
+    *Metadata
+}
+
+// FilePath returns the file the state at statePath is stored in
+func FilePath(dataDir, statePath string) string {
+    statefilePath, _ := utils.GetFilePaths("/states/"+statePath, dataDir)
+    return statefilePath
+}
+
+// List returns the states stored under dataDir whose path starts with prefix, sorted by path
+func List(dataDir, prefix string) ([]Entry, error) {
+    root := filepath.Join(dataDir, "states")
+    prefix = strings.TrimPrefix(prefix, "/")
+    var entries []Entry
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+            return nil
+        }
+        rel, err := filepath.Rel(root, path)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if !strings.HasPrefix(rel, prefix) {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return err
+        }
+        entries = append(entries, Entry{Path: rel, Size: info.Size(), Modified: info.ModTime().UTC()})
+        return nil
+    })
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+    return entries, err
+}
+
+// Save replaces the state at statePath with body, keeping the previous version
+// when retention is enabled. Locked states are refused.
+func Save(dataDir, statePath string, body io.Reader) error {
+    if err := checkUnlocked(dataDir, statePath, ""); err != nil {
+        return err
+    }
+    return saveFile(dataDir, statePath, FilePath(dataDir, statePath), body, -1)
+}
+
+// Delete moves the state at statePath into the trash, recording operator as
+// who deleted it, or removes it when TRASH_RETENTION is 0. Locked states are refused.
+func Delete(dataDir, statePath, operator string) error {
+    if err := checkUnlocked(dataDir, statePath, ""); err != nil {
+        return err
+    }
+    return deleteFile(dataDir, statePath, FilePath(dataDir, statePath), operator)
+}
+
+// Rollback replaces the state at statePath with one of its previous versions,
+// keeping the replaced state as a version in turn when retention is enabled.
+// Locked states are refused.
+func Rollback(dataDir, statePath, id string) error {
+    defer lockPaths(dataDir, statePath)()
+    if err := checkUnlocked(dataDir, statePath, ""); err != nil {
+        return err
+    }
+    data, err := history.Read(dataDir, statePath, id)
+    if err != nil {
+        return err
+    }
+    statefilePath := FilePath(dataDir, statePath)
+    return replaceFile(statefilePath, bytes.NewReader(data), -1, func() error {
+        return saveHistory(dataDir, statePath, statefilePath, nil)
+    })
+}
+
+// saveFile atomically replaces statefilePath with body, failing with
+// errTooLarge when body is longer than limit (unless limit is negative). Only
+// the rename waits for edits of the state in progress, not the upload.
+func saveFile(dataDir, statePath, statefilePath string, body io.Reader, limit int64) error {
+    unlock := func() {}
+    defer func() { unlock() }()
+    return replaceFile(statefilePath, body, limit, func() error {
+        unlock = lockPaths(dataDir, statePath)
+        return saveHistory(dataDir, statePath, statefilePath, nil)
+    })
+}
+
+// replaceFile writes body to a temporary file and renames it over statefilePath,
+// calling beforeRename once the body has been written successfully
+func replaceFile(statefilePath string, body io.Reader, limit int64, beforeRename func() error) error {
+    dir := filepath.Dir(statefilePath)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    file, err := os.CreateTemp(dir, ".tfstate-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+    defer file.Close()
+    if err := file.Chmod(0644); err != nil {
+        return err
+    }
+    if limit >= 0 {
+        body = io.LimitReader(body, limit+1)
+    }
+    written, err := io.Copy(file, body)
+    if err != nil {
+        return err
+    }
+    if limit >= 0 && written > limit {
+        return errTooLarge
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    if err := beforeRename(); err != nil {
+        return err
+    }
+    if err := os.Rename(file.Name(), statefilePath); err != nil {
+        return err
+    }
+    index.Refresh(statefilePath)
+    return nil
+}
@@ -0,0 +1,115 @@
+package states
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "sort"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/utils"
+)
+
+// Summary is a compact digest of a state, served by GET /states/<path>?summary
+type Summary struct {
+    TerraformVersion string `json:"terraform_version"`
+    Serial           int64  `json:"serial"`
+    Lineage          string `json:"lineage"`
+    // Resources counts managed resource instances, in total, by type and by module ("root" for the root module)
+    Resources         int            `json:"resources"`
+    ResourcesByType   map[string]int `json:"resources_by_type"`
+    ResourcesByModule map[string]int `json:"resources_by_module"`
+    DataSources       int            `json:"data_sources"`
+    Providers         []string       `json:"providers"`
+    Outputs           []OutputName   `json:"outputs"`
+}
+
+// OutputName names an output without revealing its value
+type OutputName struct {
+    Name      string `json:"name"`
+    Sensitive bool   `json:"sensitive"`
+}
+
+// Summarize digests a state document
+func Summarize(data []byte) (*Summary, error) {
+    var state struct {
+        TerraformVersion string `json:"terraform_version"`
+        Serial           int64  `json:"serial"`
+        Lineage          string `json:"lineage"`
+        Outputs          map[string]struct {
+            Sensitive bool `json:"sensitive"`
+        } `json:"outputs"`
+        Resources []struct {
+            Module    string            `json:"module"`
+            Mode      string            `json:"mode"`
+            Type      string            `json:"type"`
+            Provider  string            `json:"provider"`
+            Instances []json.RawMessage `json:"instances"`
+        } `json:"resources"`
+    }
+    if err := json.Unmarshal(data, &state); err != nil {
+        return nil, err
+    }
+    summary := &Summary{
+        TerraformVersion:  state.TerraformVersion,
+        Serial:            state.Serial,
+        Lineage:           state.Lineage,
+        ResourcesByType:   map[string]int{},
+        ResourcesByModule: map[string]int{},
+        Providers:         []string{},
+        Outputs:           []OutputName{},
+    }
+    providers := map[string]bool{}
+    for _, resource := range state.Resources {
+        if resource.Provider != "" {
+            providers[index.ProviderName(resource.Provider)] = true
+        }
+        if resource.Mode == "data" {
+            summary.DataSources += len(resource.Instances)
+            continue
+        }
+        module := resource.Module
+        if module == "" {
+            module = "root"
+        }
+        summary.Resources += len(resource.Instances)
+        summary.ResourcesByType[resource.Type] += len(resource.Instances)
+        summary.ResourcesByModule[module] += len(resource.Instances)
+    }
+    for provider := range providers {
+        summary.Providers = append(summary.Providers, provider)
+    }
+    sort.Strings(summary.Providers)
+    for name, output := range state.Outputs {
+        summary.Outputs = append(summary.Outputs, OutputName{Name: name, Sensitive: output.Sensitive})
+    }
+    sort.Slice(summary.Outputs, func(i, j int) bool { return summary.Outputs[i].Name < summary.Outputs[j].Name })
+    return summary, nil
+}
+
+func summarizeState(w http.ResponseWriter, r *http.Request, statefilePath string) {
+    _, span := tracing.Start(r.Context(), "storage.read")
+    data, err := os.ReadFile(statefilePath)
+    span.SetAttributes(attribute.Int("state.bytes", len(data)))
+    tracing.End(span, err)
+    if err != nil {
+        utils.HandleFileError(w, r, statefilePath, err)
+        return
+    }
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && principal.OutputsOnly {
+        if data, err = outputsOnly(data, auth.CanReadSensitive(r)); err != nil {
+            utils.HTTPError(w, r, "Error stripping state", err)
+            return
+        }
+    }
+    summary, err := Summarize(data)
+    if err != nil {
+        http.Error(w, "State is not valid JSON", http.StatusUnprocessableEntity)
+        return
+    }
+    utils.WriteJSON(w, summary)
+}
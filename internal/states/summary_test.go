@@ -0,0 +1,88 @@
+package states
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+const summaryState = `{
+  "version": 4,
+  "terraform_version": "1.9.0",
+  "serial": 12,
+  "lineage": "abc",
+  "outputs": {
+    "vpc_id": {"value": "vpc-1", "type": "string"},
+    "db_password": {"value": "hunter2", "type": "string", "sensitive": true}
+  },
+  "resources": [
+    {"mode": "managed", "type": "aws_vpc", "name": "main", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "instances": [{}]},
+    {"module": "module.subnets", "mode": "managed", "type": "aws_subnet", "name": "private", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "instances": [{}, {}, {}]},
+    {"module": "module.subnets", "mode": "managed", "type": "random_id", "name": "suffix", "provider": "provider[\"registry.terraform.io/hashicorp/random\"]", "instances": [{}]},
+    {"mode": "data", "type": "aws_ami", "name": "ubuntu", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "instances": [{}]}
+  ]
+}`
+
+func TestSummarize(t *testing.T) {
+    summary, err := Summarize([]byte(summaryState))
+    if err != nil {
+        t.Fatalf("Summarize failed: %v", err)
+    }
+    expected := &Summary{
+        TerraformVersion:  "1.9.0",
+        Serial:            12,
+        Lineage:           "abc",
+        Resources:         5,
+        ResourcesByType:   map[string]int{"aws_vpc": 1, "aws_subnet": 3, "random_id": 1},
+        ResourcesByModule: map[string]int{"root": 1, "module.subnets": 4},
+        DataSources:       1,
+        Providers:         []string{"registry.terraform.io/hashicorp/aws", "registry.terraform.io/hashicorp/random"},
+        Outputs:           []OutputName{{Name: "db_password", Sensitive: true}, {Name: "vpc_id"}},
+    }
+    if !reflect.DeepEqual(summary, expected) {
+        t.Errorf("Summarize = %+v; want %+v", summary, expected)
+    }
+}
+
+func TestHandleStatesGetSummary(t *testing.T) {
+    tempDir, err := ioutil.TempDir("", "testdata")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+    os.MkdirAll(filepath.Join(tempDir, "states"), 0755)
+    if err := ioutil.WriteFile(FilePath(tempDir, "app"), []byte(summaryState), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/states/app?summary", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+
+    if status := rr.Code; status != http.StatusOK {
+        t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    var summary Summary
+    if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+        t.Fatalf("Summary isn't JSON: %v", err)
+    }
+    if summary.Serial != 12 || summary.Resources != 5 {
+        t.Errorf("Summary = %+v; want serial 12 with 5 resources", summary)
+    }
+    if strings.Contains(rr.Body.String(), "hunter2") {
+        t.Errorf("Summary leaks an output value: %s", rr.Body.String())
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/states/missing?summary", nil)
+    rr = httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusNotFound {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+    }
+}
@@ -0,0 +1,728 @@
+package states
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/trash"
+    "terraform-http-backend/internal/utils"
+)
+
+// ErrNoMatch is returned when an address matches nothing in the state
+var ErrNoMatch = errors.New("address matches no resources")
+
+// ErrConflict is returned when an edit would overwrite a resource instance that already exists
+var ErrConflict = errors.New("resource already exists")
+
+// ErrInvalidAddress is returned for addresses that can't be parsed or edits between incompatible addresses
+var ErrInvalidAddress = errors.New("invalid address")
+
+var errInvalidState = errors.New("state is not valid JSON")
+
+// pathLocks serialize edits of each state path, as edits read a state and
+// write it back, and no other write may land in between
+var pathLocks = struct {
+    sync.Mutex
+    held map[string]*pathLock
+}{held: map[string]*pathLock{}}
+
+type pathLock struct {
+    sync.Mutex
+    file    string
+    waiters int
+}
+
+// lockPaths locks the states at paths under dataDir, returning the function
+// unlocking them. Paths are locked in order, so edits of two states can't
+// deadlock.
+func lockPaths(dataDir string, paths ...string) func() {
+    var files []string
+    for _, path := range paths {
+        files = append(files, FilePath(dataDir, path))
+    }
+    sort.Strings(files)
+    var locks []*pathLock
+    for i, file := range files {
+        if i > 0 && file == files[i-1] {
+            continue
+        }
+        pathLocks.Lock()
+        lock, ok := pathLocks.held[file]
+        if !ok {
+            lock = &pathLock{file: file}
+            pathLocks.held[file] = lock
+        }
+        lock.waiters++
+        pathLocks.Unlock()
+        lock.Lock()
+        locks = append(locks, lock)
+    }
+    return func() {
+        for _, lock := range locks {
+            lock.Unlock()
+            pathLocks.Lock()
+            if lock.waiters--; lock.waiters == 0 {
+                delete(pathLocks.held, lock.file)
+            }
+            pathLocks.Unlock()
+        }
+    }
+}
+
+// Edit identifies who is editing a state and why, and the lock they hold if any
+type Edit struct {
+    Operator string
+    Reason   string
+    // LockID lets the holder of the state's lock edit it
+    LockID string
+}
+
+// EditResult is the outcome of an edit: the state's new serial and the number of instances affected
+type EditResult struct {
+    Serial    int64 `json:"serial"`
+    Instances int   `json:"instances"`
+}
+
+// RemoveResources removes the module, resource or resource instance at addr
+// from the state at statePath, like terraform state rm
+func RemoveResources(dataDir, statePath, addr string, edit Edit) (EditResult, error) {
+    a, err := parseAddress(addr)
+    if err != nil {
+        return EditResult{}, err
+    }
+    defer lockPaths(dataDir, statePath)()
+    if err := checkUnlocked(dataDir, statePath, edit.LockID); err != nil {
+        return EditResult{}, err
+    }
+    doc, err := readDocument(dataDir, statePath)
+    if err != nil {
+        return EditResult{}, err
+    }
+    removed := countInstances(doc.extract(a))
+    if removed == 0 {
+        return EditResult{}, ErrNoMatch
+    }
+    serial := doc.bumpSerial()
+    if err := writeDocument(dataDir, statePath, doc, edit, "rm "+addr); err != nil {
+        return EditResult{}, err
+    }
+    return EditResult{Serial: serial, Instances: removed}, nil
+}
+
+// MoveResources moves the module, resource or resource instance at addr to
+// toAddr, in the same state or into the state at toStatePath, like terraform
+// state mv. A missing destination state is created. The destination is
+// written before the source, so a failure in between leaves the resources in
+// both states rather than in neither.
+func MoveResources(dataDir, statePath, addr, toStatePath, toAddr string, edit Edit) (EditResult, error) {
+    from, err := parseAddress(addr)
+    if err != nil {
+        return EditResult{}, err
+    }
+    to, err := parseAddress(toAddr)
+    if err != nil {
+        return EditResult{}, err
+    }
+    if toStatePath == statePath && from.String() == to.String() {
+        return EditResult{}, fmt.Errorf("%w: %s is already at %s", ErrInvalidAddress, addr, toAddr)
+    }
+    defer lockPaths(dataDir, statePath, toStatePath)()
+    for _, path := range []string{statePath, toStatePath} {
+        if err := checkUnlocked(dataDir, path, edit.LockID); err != nil {
+            return EditResult{}, err
+        }
+    }
+    doc, err := readDocument(dataDir, statePath)
+    if err != nil {
+        return EditResult{}, err
+    }
+    moved := doc.extract(from)
+    if len(moved) == 0 {
+        return EditResult{}, ErrNoMatch
+    }
+    if err := rename(moved, from, to); err != nil {
+        return EditResult{}, err
+    }
+
+    operation := "mv " + addr + " " + toAddr
+    if toStatePath == statePath {
+        if err := doc.insert(moved); err != nil {
+            return EditResult{}, err
+        }
+    } else {
+        operation = "mv " + addr + " " + strings.TrimPrefix(toStatePath, "/") + ":" + toAddr
+        dest, err := readDocument(dataDir, toStatePath)
+        if errors.Is(err, os.ErrNotExist) {
+            // Create the destination empty first, so moving the resources in
+            // is recorded as a version like any other edit
+            dest = newDocument(doc)
+            if err := writeDocument(dataDir, toStatePath, dest, edit, operation); err != nil {
+                return EditResult{}, err
+            }
+        } else if err != nil {
+            return EditResult{}, err
+        }
+        if err := dest.insert(moved); err != nil {
+            return EditResult{}, err
+        }
+        dest.bumpSerial()
+        if err := writeDocument(dataDir, toStatePath, dest, edit, operation); err != nil {
+            return EditResult{}, err
+        }
+    }
+    serial := doc.bumpSerial()
+    if err := writeDocument(dataDir, statePath, doc, edit, operation); err != nil {
+        return EditResult{}, err
+    }
+    return EditResult{Serial: serial, Instances: countInstances(moved)}, nil
+}
+
+// BumpSerial increments the serial of the state at statePath without changing
+// anything else, so terraform treats it as newer than copies elsewhere
+func BumpSerial(dataDir, statePath string, edit Edit) (EditResult, error) {
+    defer lockPaths(dataDir, statePath)()
+    if err := checkUnlocked(dataDir, statePath, edit.LockID); err != nil {
+        return EditResult{}, err
+    }
+    doc, err := readDocument(dataDir, statePath)
+    if err != nil {
+        return EditResult{}, err
+    }
+    serial := doc.bumpSerial()
+    if err := writeDocument(dataDir, statePath, doc, edit, "bump serial"); err != nil {
+        return EditResult{}, err
+    }
+    return EditResult{Serial: serial}, nil
+}
+
+// editRequest reads who is editing a state and why from the request, writing
+// the error response when no reason is given
+func editRequest(w http.ResponseWriter, r *http.Request) (Edit, bool) {
+    reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+    if reason == "" {
+        http.Error(w, "A reason for the edit is required", http.StatusBadRequest)
+        return Edit{}, false
+    }
+    return Edit{Operator: operator(r), Reason: reason, LockID: r.URL.Query().Get("lock_id")}, true
+}
+
+// operator names who made a request: the authenticated username, or the client's address without authentication
+func operator(r *http.Request) string {
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+        return principal.Username
+    }
+    return auth.ClientIP(r)
+}
+
+func removeResources(w http.ResponseWriter, r *http.Request, dataDir, statePath, addr string) {
+    edit, ok := editRequest(w, r)
+    if !ok {
+        return
+    }
+    _, span := tracing.Start(r.Context(), "storage.edit", attribute.String("state.resource", addr))
+    result, err := RemoveResources(dataDir, statePath, addr, edit)
+    tracing.End(span, err)
+    if !editFailed(w, r, err) {
+        utils.WriteJSON(w, result)
+        slog.InfoContext(r.Context(), "Removed resources from state", "path", statePath, "address", addr,
+            "instances", result.Instances, "operator", edit.Operator, "reason", edit.Reason)
+    }
+}
+
+func moveResources(w http.ResponseWriter, r *http.Request, dataDir, statePath, addr string) {
+    edit, ok := editRequest(w, r)
+    if !ok {
+        return
+    }
+    toAddr, toStatePath := addr, statePath
+    if to := r.URL.Query().Get("to"); to != "" {
+        toAddr = to
+    }
+    if toState := r.URL.Query().Get("to_state"); toState != "" {
+        if _, toStatePath = utils.SplitPath("/states/" + toState); toStatePath == "/" {
+            http.Error(w, "Invalid to_state", http.StatusBadRequest)
+            return
+        }
+    }
+    // Moving into a state that doesn't exist yet creates it, which must fit the tenant's quota
+    if _, err := os.Stat(FilePath(dataDir, toStatePath)); os.IsNotExist(err) && !quotaAllows(w, r, dataDir, FilePath(dataDir, toStatePath), 0) {
+        return
+    }
+    _, span := tracing.Start(r.Context(), "storage.edit", attribute.String("state.resource", addr))
+    result, err := MoveResources(dataDir, statePath, addr, toStatePath, toAddr, edit)
+    tracing.End(span, err)
+    if !editFailed(w, r, err) {
+        utils.WriteJSON(w, result)
+        slog.InfoContext(r.Context(), "Moved resources", "path", statePath, "address", addr, "to_path", toStatePath, "to_address", toAddr,
+            "instances", result.Instances, "operator", edit.Operator, "reason", edit.Reason)
+    }
+}
+
+func bumpSerial(w http.ResponseWriter, r *http.Request, dataDir, statePath string) {
+    edit, ok := editRequest(w, r)
+    if !ok {
+        return
+    }
+    _, span := tracing.Start(r.Context(), "storage.edit")
+    result, err := BumpSerial(dataDir, statePath, edit)
+    tracing.End(span, err)
+    if !editFailed(w, r, err) {
+        utils.WriteJSON(w, result)
+        slog.InfoContext(r.Context(), "Bumped state serial", "path", statePath, "serial", result.Serial,
+            "operator", edit.Operator, "reason", edit.Reason)
+    }
+}
+
+// editFailed writes the error response for a failed edit
+func editFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+    switch {
+    case err == nil:
+        return false
+    case err == ErrLocked:
+        http.Error(w, "State is locked", http.StatusLocked)
+    case errors.Is(err, os.ErrNotExist):
+        http.NotFound(w, r)
+    case errors.Is(err, ErrNoMatch), err == trash.ErrNotFound:
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case err == ErrOccupied:
+        http.Error(w, err.Error(), http.StatusConflict)
+    case errors.Is(err, ErrConflict):
+        http.Error(w, err.Error(), http.StatusConflict)
+    case err == ErrExists:
+        http.Error(w, "Destination state exists, set force=true to overwrite it", http.StatusConflict)
+    case err == ErrInvalidPath:
+        http.Error(w, "Invalid destination path", http.StatusBadRequest)
+    case errors.Is(err, ErrInvalidAddress):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case err == errInvalidState:
+        http.Error(w, "State is not valid JSON", http.StatusUnprocessableEntity)
+    default:
+        utils.HTTPError(w, r, "Error editing state", err)
+    }
+    return true
+}
+
+// checkUnlocked returns ErrLocked when the state at statePath is locked, unless
+// lockID is the lock's ID. Locks older than the path's LOCK_TTL have expired,
+// as they have for acquiring a lock.
+func checkUnlocked(dataDir, statePath, lockID string) error {
+    lockfilePath, _ := utils.GetFilePaths("/locks/"+statePath, dataDir)
+    info, err := os.Stat(lockfilePath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    if ttl := config.ForPath(statePath).LockTTL; ttl > 0 && time.Since(info.ModTime()) > ttl {
+        return nil
+    }
+    data, err := os.ReadFile(lockfilePath)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    var lock struct {
+        ID string `json:"ID"`
+    }
+    if json.Unmarshal(data, &lock) == nil && lockID != "" && lock.ID == lockID {
+        return nil
+    }
+    return ErrLocked
+}
+
+// document is a state decoded generically, so edits keep the fields this server doesn't know about
+type document map[string]interface{}
+
+func readDocument(dataDir, statePath string) (document, error) {
+    data, err := os.ReadFile(FilePath(dataDir, statePath))
+    if err != nil {
+        return nil, err
+    }
+    var doc document
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    if err := decoder.Decode(&doc); err != nil || doc == nil {
+        return nil, errInvalidState
+    }
+    return doc, nil
+}
+
+// newDocument returns an empty state for resources moved out of src
+func newDocument(src document) document {
+    lineage := make([]byte, 16)
+    rand.Read(lineage)
+    lineage[6] = lineage[6]&0x0f | 0x40
+    lineage[8] = lineage[8]&0x3f | 0x80
+    doc := document{
+        "version":   json.Number("4"),
+        "serial":    json.Number("0"),
+        "lineage":   fmt.Sprintf("%x-%x-%x-%x-%x", lineage[0:4], lineage[4:6], lineage[6:8], lineage[8:10], lineage[10:]),
+        "outputs":   map[string]interface{}{},
+        "resources": []interface{}{},
+    }
+    if version, ok := src["terraform_version"]; ok {
+        doc["terraform_version"] = version
+    }
+    return doc
+}
+
+// writeDocument replaces the state at statePath with doc, keeping the
+// replaced state as a version recording the change when retention is enabled
+func writeDocument(dataDir, statePath string, doc document, edit Edit, operation string) error {
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(doc); err != nil {
+        return err
+    }
+    statefilePath := FilePath(dataDir, statePath)
+    change := history.Change{Operation: operation, Operator: edit.Operator, Reason: edit.Reason}
+    return replaceFile(statefilePath, &buf, -1, func() error {
+        return saveHistory(dataDir, statePath, statefilePath, &change)
+    })
+}
+
+func (d document) bumpSerial() int64 {
+    serial, _ := strconv.ParseInt(fmt.Sprint(d["serial"]), 10, 64)
+    serial++
+    d["serial"] = json.Number(strconv.FormatInt(serial, 10))
+    return serial
+}
+
+func (d document) resources() []map[string]interface{} {
+    list, _ := d["resources"].([]interface{})
+    resources := make([]map[string]interface{}, 0, len(list))
+    for _, item := range list {
+        if resource, ok := item.(map[string]interface{}); ok {
+            resources = append(resources, resource)
+        }
+    }
+    return resources
+}
+
+func (d document) setResources(resources []map[string]interface{}) {
+    list := make([]interface{}, len(resources))
+    for i, resource := range resources {
+        list[i] = resource
+    }
+    d["resources"] = list
+}
+
+// extract removes what a addresses from the state, returning it as resources
+// holding just the extracted instances
+func (d document) extract(a address) []map[string]interface{} {
+    var kept, extracted []map[string]interface{}
+    for _, resource := range d.resources() {
+        if a.Type == "" && inModule(resource, a.Module) || a.Type != "" && a.matches(resource) && !a.HasKey {
+            extracted = append(extracted, resource)
+            continue
+        }
+        if a.Type != "" && a.matches(resource) {
+            var rest, matched []interface{}
+            for _, instance := range instances(resource) {
+                if keysEqual(instance["index_key"], a.Key) {
+                    matched = append(matched, instance)
+                } else {
+                    rest = append(rest, instance)
+                }
+            }
+            if len(matched) > 0 {
+                moved := make(map[string]interface{}, len(resource))
+                for k, v := range resource {
+                    moved[k] = v
+                }
+                moved["instances"] = matched
+                extracted = append(extracted, moved)
+                if len(rest) == 0 {
+                    continue
+                }
+                resource["instances"] = rest
+            }
+        }
+        kept = append(kept, resource)
+    }
+    d.setResources(kept)
+    return extracted
+}
+
+// insert adds resources to the state, merging the instances of resources it
+// already has, and fails with ErrConflict when an instance already exists
+func (d document) insert(moved []map[string]interface{}) error {
+    resources := d.resources()
+    for _, resource := range moved {
+        var existing map[string]interface{}
+        for _, candidate := range resources {
+            if sameResource(candidate, resource) {
+                existing = candidate
+            }
+        }
+        if existing == nil {
+            resources = append(resources, resource)
+            continue
+        }
+        merged := instances(existing)
+        for _, instance := range instances(resource) {
+            for _, other := range merged {
+                if keysEqual(instance["index_key"], other["index_key"]) {
+                    return fmt.Errorf("%w: %s", ErrConflict, resourceAddress(resource, instance))
+                }
+            }
+            merged = append(merged, instance)
+        }
+        list := make([]interface{}, len(merged))
+        for i, instance := range merged {
+            list[i] = instance
+        }
+        existing["instances"] = list
+    }
+    d.setResources(resources)
+    return nil
+}
+
+// rename readdresses resources extracted from the from address to the to address
+func rename(resources []map[string]interface{}, from, to address) error {
+    if (from.Type == "") != (to.Type == "") {
+        return fmt.Errorf("%w: can't move between a module and a resource address", ErrInvalidAddress)
+    }
+    if from.Type == "" {
+        for _, resource := range resources {
+            module, _ := resource["module"].(string)
+            setModule(resource, to.Module+strings.TrimPrefix(module, from.Module))
+        }
+        return nil
+    }
+    if from.Mode != to.Mode || from.Type != to.Type {
+        return fmt.Errorf("%w: can't move %s to %s, a different type of resource", ErrInvalidAddress, from, to)
+    }
+    if !from.HasKey && to.HasKey {
+        return fmt.Errorf("%w: can't move the whole of %s to the instance %s", ErrInvalidAddress, from, to)
+    }
+    for _, resource := range resources {
+        setModule(resource, to.Module)
+        resource["name"] = to.Name
+        if !from.HasKey {
+            continue
+        }
+        for _, instance := range instances(resource) {
+            delete(instance, "index_key")
+            delete(resource, "each")
+            switch to.Key.(type) {
+            case json.Number:
+                instance["index_key"] = to.Key
+                resource["each"] = "list"
+            case string:
+                instance["index_key"] = to.Key
+                resource["each"] = "map"
+            }
+        }
+    }
+    return nil
+}
+
+func setModule(resource map[string]interface{}, module string) {
+    if module == "" {
+        delete(resource, "module")
+    } else {
+        resource["module"] = module
+    }
+}
+
+func instances(resource map[string]interface{}) []map[string]interface{} {
+    list, _ := resource["instances"].([]interface{})
+    result := make([]map[string]interface{}, 0, len(list))
+    for _, item := range list {
+        if instance, ok := item.(map[string]interface{}); ok {
+            result = append(result, instance)
+        }
+    }
+    return result
+}
+
+func countInstances(resources []map[string]interface{}) int {
+    count := 0
+    for _, resource := range resources {
+        count += len(instances(resource))
+    }
+    return count
+}
+
+func sameResource(a, b map[string]interface{}) bool {
+    for _, field := range []string{"module", "mode", "type", "name"} {
+        if fmt.Sprint(a[field]) != fmt.Sprint(b[field]) {
+            return false
+        }
+    }
+    return true
+}
+
+func inModule(resource map[string]interface{}, module string) bool {
+    m, _ := resource["module"].(string)
+    return m == module || strings.HasPrefix(m, module+".") || strings.HasPrefix(m, module+"[")
+}
+
+func keysEqual(a, b interface{}) bool {
+    switch a := a.(type) {
+    case nil:
+        return b == nil
+    case json.Number:
+        b, ok := b.(json.Number)
+        return ok && a.String() == b.String()
+    case string:
+        b, ok := b.(string)
+        return ok && a == b
+    }
+    return false
+}
+
+func resourceAddress(resource, instance map[string]interface{}) string {
+    module, _ := resource["module"].(string)
+    mode, _ := resource["mode"].(string)
+    resourceType, _ := resource["type"].(string)
+    name, _ := resource["name"].(string)
+    return index.Address(module, mode, resourceType, name, instance["index_key"])
+}
+
+// address is a parsed module, resource or resource instance address
+type address struct {
+    // Module is e.g. module.vpc or module.app["a"].module.db, empty for the root module
+    Module string
+    // Mode and Type are empty for module addresses
+    Mode   string
+    Type   string
+    Name   string
+    Key    interface{}
+    HasKey bool
+}
+
+func (a address) String() string {
+    if a.Type == "" {
+        return a.Module
+    }
+    return index.Address(a.Module, a.Mode, a.Type, a.Name, a.Key)
+}
+
+// matches reports whether resource is the resource a addresses
+func (a address) matches(resource map[string]interface{}) bool {
+    module, _ := resource["module"].(string)
+    mode, _ := resource["mode"].(string)
+    return module == a.Module && mode == a.Mode && resource["type"] == a.Type && resource["name"] == a.Name
+}
+
+// parseAddress parses addresses such as module.vpc, aws_instance.web,
+// data.aws_ami.ubuntu and module.app["a"].aws_instance.web[0]
+func parseAddress(s string) (address, error) {
+    invalid := fmt.Errorf("%w %q", ErrInvalidAddress, s)
+    segments, ok := splitAddress(s)
+    if !ok {
+        return address{}, invalid
+    }
+    var a address
+    var modules []string
+    i := 0
+    for ; i < len(segments) && segments[i] == "module"; i += 2 {
+        if i+1 >= len(segments) {
+            return address{}, invalid
+        }
+        name, key, _, ok := splitKey(segments[i+1])
+        if !ok {
+            return address{}, invalid
+        }
+        modules = append(modules, index.Address("", "", "module", name, key))
+    }
+    a.Module = strings.Join(modules, ".")
+    rest := segments[i:]
+    if len(rest) == 0 {
+        if a.Module == "" {
+            return address{}, invalid
+        }
+        return a, nil
+    }
+    a.Mode = "managed"
+    if rest[0] == "data" {
+        a.Mode, rest = "data", rest[1:]
+    }
+    if len(rest) != 2 || strings.ContainsAny(rest[0], `["]`) {
+        return address{}, invalid
+    }
+    a.Type = rest[0]
+    if a.Name, a.Key, a.HasKey, ok = splitKey(rest[1]); !ok {
+        return address{}, invalid
+    }
+    return a, nil
+}
+
+// splitAddress splits an address at the dots outside of index brackets
+func splitAddress(s string) ([]string, bool) {
+    var segments []string
+    start, inBracket, inQuote := 0, false, false
+    for i := 0; i < len(s); i++ {
+        switch c := s[i]; {
+        case inQuote && c == '\\':
+            i++
+        case inBracket && c == '"':
+            inQuote = !inQuote
+        case inQuote:
+        case c == '[':
+            if inBracket {
+                return nil, false
+            }
+            inBracket = true
+        case c == ']':
+            if !inBracket {
+                return nil, false
+            }
+            inBracket = false
+        case c == '.' && !inBracket:
+            segments = append(segments, s[start:i])
+            start = i + 1
+        }
+    }
+    segments = append(segments, s[start:])
+    for _, segment := range segments {
+        if segment == "" {
+            return nil, false
+        }
+    }
+    return segments, !inBracket && !inQuote
+}
+
+// splitKey splits a name such as web[0] or web["a"] from its index key
+func splitKey(segment string) (string, interface{}, bool, bool) {
+    open := strings.IndexByte(segment, '[')
+    if open < 0 {
+        return segment, nil, false, true
+    }
+    name, raw := segment[:open], segment[open+1:]
+    if name == "" || !strings.HasSuffix(raw, "]") {
+        return "", nil, false, false
+    }
+    raw = strings.TrimSuffix(raw, "]")
+    if strings.HasPrefix(raw, `"`) {
+        key, err := strconv.Unquote(raw)
+        return name, key, err == nil, err == nil
+    }
+    if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+        return name, json.Number(strconv.Itoa(n)), true, true
+    }
+    return "", nil, false, false
+}
@@ -0,0 +1,311 @@
+package states
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/history"
+    "terraform-http-backend/internal/tenants"
+)
+
+const surgeryState = `{
+  "version": 4,
+  "terraform_version": "1.9.0",
+  "serial": 4,
+  "lineage": "abc",
+  "outputs": {},
+  "resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "web", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]", "each": "list",
+     "instances": [{"index_key": 0, "attributes": {"id": "i-0"}}, {"index_key": 1, "attributes": {"id": "i-1"}}]},
+    {"module": "module.vpc", "mode": "managed", "type": "aws_vpc", "name": "main", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"attributes": {"id": "vpc-1", "tags": {"Name": "<main>"}}}]},
+    {"module": "module.vpc.module.subnets", "mode": "managed", "type": "aws_subnet", "name": "private", "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+     "instances": [{"attributes": {"id": "subnet-1"}}]}
+  ]
+}`
+
+func writeSurgeryState(t *testing.T, dataDir, statePath string) {
+    t.Helper()
+    file := FilePath(dataDir, statePath)
+    os.MkdirAll(filepath.Dir(file), 0755)
+    if err := ioutil.WriteFile(file, []byte(surgeryState), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+}
+
+// stateAddresses reads a stored state's serial and instance addresses
+func stateAddresses(t *testing.T, dataDir, statePath string) (int64, []string) {
+    t.Helper()
+    data, err := os.ReadFile(FilePath(dataDir, statePath))
+    if err != nil {
+        t.Fatalf("Failed to read state: %v", err)
+    }
+    resources, err := Resources(data, ResourceFilter{}, true)
+    if err != nil {
+        t.Fatalf("State isn't valid: %v", err)
+    }
+    summary, _ := Summarize(data)
+    return summary.Serial, resourceAddresses(resources)
+}
+
+func TestParseAddress(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected address
+    }{
+        {"module.vpc", address{Module: "module.vpc"}},
+        {"aws_instance.web", address{Mode: "managed", Type: "aws_instance", Name: "web"}},
+        {"data.aws_ami.ubuntu", address{Mode: "data", Type: "aws_ami", Name: "ubuntu"}},
+        {`module.app["a.b"].module.db.aws_instance.web[2]`, address{Module: `module.app["a.b"].module.db`, Mode: "managed", Type: "aws_instance", Name: "web", Key: json.Number("2"), HasKey: true}},
+        {`aws_instance.web["x"]`, address{Mode: "managed", Type: "aws_instance", Name: "web", Key: "x", HasKey: true}},
+    }
+    for _, test := range tests {
+        a, err := parseAddress(test.input)
+        if err != nil {
+            t.Errorf("parseAddress(%q) failed: %v", test.input, err)
+        } else if !reflect.DeepEqual(a, test.expected) {
+            t.Errorf("parseAddress(%q) = %+v; want %+v", test.input, a, test.expected)
+        }
+        if err == nil && a.String() != test.input {
+            t.Errorf("parseAddress(%q).String() = %q", test.input, a.String())
+        }
+    }
+    for _, input := range []string{"", "aws_instance", "module", "aws_instance.web[", "aws_instance.web[-1]", "aws_instance.web[x]", "a.b.c", "aws_instance..web"} {
+        if _, err := parseAddress(input); err == nil {
+            t.Errorf("parseAddress(%q) succeeded; want an error", input)
+        }
+    }
+}
+
+func TestRemoveResources(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("RETENTION_VERSIONS", "10")
+    writeSurgeryState(t, tempDir, "/app")
+    edit := Edit{Operator: "alice", Reason: "decommissioned"}
+
+    result, err := RemoveResources(tempDir, "/app", "aws_instance.web[1]", edit)
+    if err != nil || result != (EditResult{Serial: 5, Instances: 1}) {
+        t.Fatalf("RemoveResources = %+v, %v; want serial 5 and 1 instance", result, err)
+    }
+    if _, err := RemoveResources(tempDir, "/app", "module.vpc", edit); err != nil {
+        t.Fatalf("RemoveResources(module) failed: %v", err)
+    }
+    serial, addresses := stateAddresses(t, tempDir, "/app")
+    if serial != 6 || !reflect.DeepEqual(addresses, []string{"aws_instance.web[0]"}) {
+        t.Errorf("State after removals = %d %v; want serial 6 with aws_instance.web[0]", serial, addresses)
+    }
+    if _, err := RemoveResources(tempDir, "/app", "aws_instance.db", edit); err != ErrNoMatch {
+        t.Errorf("RemoveResources(missing) = %v; want ErrNoMatch", err)
+    }
+
+    versions, _ := history.List(tempDir, "/app")
+    if len(versions) != 2 || versions[1].Change == nil || *versions[1].Change != (history.Change{Operation: "rm aws_instance.web[1]", Operator: "alice", Reason: "decommissioned"}) {
+        t.Errorf("History = %+v; want both edits recorded", versions)
+    }
+
+    lockFile := filepath.Join(tempDir, "locks", "app")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    ioutil.WriteFile(lockFile, []byte(`{"ID": "lock-1"}`), 0644)
+    if _, err := RemoveResources(tempDir, "/app", "aws_instance.web", edit); err != ErrLocked {
+        t.Errorf("RemoveResources on locked state = %v; want ErrLocked", err)
+    }
+    edit.LockID = "lock-1"
+    if _, err := RemoveResources(tempDir, "/app", "aws_instance.web", edit); err != nil {
+        t.Errorf("RemoveResources by the lock holder failed: %v", err)
+    }
+}
+
+func TestMoveResources(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/app")
+    edit := Edit{Operator: "alice", Reason: "refactor"}
+
+    if _, err := MoveResources(tempDir, "/app", "aws_instance.web[1]", "/app", `module.web.aws_instance.main["b"]`, edit); err != nil {
+        t.Fatalf("MoveResources(instance) failed: %v", err)
+    }
+    if _, err := MoveResources(tempDir, "/app", "module.vpc", "/app", "module.network", edit); err != nil {
+        t.Fatalf("MoveResources(module) failed: %v", err)
+    }
+    serial, addresses := stateAddresses(t, tempDir, "/app")
+    // Moved resources are appended
+    expected := []string{"aws_instance.web[0]", `module.web.aws_instance.main["b"]`, "module.network.aws_vpc.main", "module.network.module.subnets.aws_subnet.private"}
+    if serial != 6 || !reflect.DeepEqual(addresses, expected) {
+        t.Errorf("State after moves = %d %v; want serial 6 with %v", serial, addresses, expected)
+    }
+
+    result, err := MoveResources(tempDir, "/app", "aws_instance.web", "/split/web", "aws_instance.web", edit)
+    if err != nil || result.Instances != 1 {
+        t.Fatalf("MoveResources(into new state) = %+v, %v; want 1 instance", result, err)
+    }
+    serial, addresses = stateAddresses(t, tempDir, "/split/web")
+    if serial != 1 || !reflect.DeepEqual(addresses, []string{"aws_instance.web[0]"}) {
+        t.Errorf("New state = %d %v; want serial 1 with aws_instance.web[0]", serial, addresses)
+    }
+    if data, _ := os.ReadFile(FilePath(tempDir, "/app")); !strings.Contains(string(data), `"<main>"`) {
+        t.Errorf("Edited state changed attribute values: %s", data)
+    }
+    if _, addresses = stateAddresses(t, tempDir, "/app"); len(addresses) != 3 {
+        t.Errorf("Source state after move = %v; want 3 instances left", addresses)
+    }
+
+    writeSurgeryState(t, tempDir, "/other")
+    if _, err := MoveResources(tempDir, "/other", "aws_instance.web[0]", "/split/web", "aws_instance.web[0]", edit); err == nil || err.Error() != "resource already exists: aws_instance.web[0]" {
+        t.Errorf("MoveResources over an existing instance = %v; want a conflict", err)
+    }
+    for _, test := range [][2]string{{"aws_instance.web", "aws_vpc.web"}, {"aws_instance.web", "module.web"}, {"aws_instance.web", "aws_instance.web[0]"}} {
+        if _, err := MoveResources(tempDir, "/other", test[0], "/other", test[1], edit); err == nil {
+            t.Errorf("MoveResources(%s, %s) succeeded; want an error", test[0], test[1])
+        }
+    }
+}
+
+func TestHandleStatesEdit(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("RETENTION_VERSIONS", "10")
+    writeSurgeryState(t, tempDir, "/prod/app")
+    principal := auth.Principal{Username: "alice", Role: auth.RoleReadWrite}
+
+    tests := []struct {
+        description    string
+        method         string
+        path           string
+        expectedStatus int
+        expectedSerial int64
+    }{
+        {"remove without reason", http.MethodDelete, "/states/prod/app/resources/aws_instance.web[0]", http.StatusBadRequest, 0},
+        {"remove", http.MethodDelete, "/states/prod/app/resources/aws_instance.web[0]?reason=gone", http.StatusOK, 5},
+        {"remove missing", http.MethodDelete, "/states/prod/app/resources/aws_instance.web[0]?reason=gone", http.StatusNotFound, 0},
+        {"remove invalid", http.MethodDelete, "/states/prod/app/resources/aws_instance?reason=gone", http.StatusBadRequest, 0},
+        {"move", http.MethodPost, "/states/prod/app/resources/module.vpc?to=module.network&reason=rename", http.StatusOK, 6},
+        {"move to another state", http.MethodPost, "/states/prod/app/resources/aws_instance.web?to_state=prod/web&reason=split", http.StatusOK, 7},
+        {"bump serial", http.MethodPost, "/states/prod/app?bump_serial&reason=" + url.QueryEscape("out of sync"), http.StatusOK, 8},
+        {"missing state", http.MethodPost, "/states/prod/none?bump_serial&reason=x", http.StatusNotFound, 0},
+    }
+    for _, test := range tests {
+        req := httptest.NewRequest(test.method, test.path, nil)
+        req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v", test.description, status, test.expectedStatus)
+            continue
+        }
+        if test.expectedStatus != http.StatusOK {
+            continue
+        }
+        var result EditResult
+        if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || result.Serial != test.expectedSerial {
+            t.Errorf("%s: Handler returned %s; want serial %d", test.description, rr.Body.String(), test.expectedSerial)
+        }
+    }
+
+    versions, _ := history.List(tempDir, "/prod/app")
+    if len(versions) != 4 || versions[0].Change == nil || versions[0].Change.Operator != "alice" || versions[0].Change.Reason != "out of sync" {
+        t.Errorf("History = %+v; want 4 versions, the newest replaced by alice's serial bump", versions)
+    }
+    if _, err := os.Stat(FilePath(tempDir, "/prod/web")); err != nil {
+        t.Errorf("State moved into wasn't created: %v", err)
+    }
+    // Creating the state moved into is recorded like any other edit
+    versions, _ = history.List(tempDir, "/prod/web")
+    if len(versions) != 1 || versions[0].Change == nil || versions[0].Change.Reason != "split" {
+        t.Errorf("History of the created state = %+v; want the move recorded", versions)
+    }
+}
+
+func TestEditsWithoutRetention(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("RETENTION_VERSIONS", "0")
+    writeSurgeryState(t, tempDir, "/app")
+    edit := Edit{Operator: "alice", Reason: "cleanup"}
+    if _, err := BumpSerial(tempDir, "/app", edit); err != nil {
+        t.Fatalf("BumpSerial failed: %v", err)
+    }
+    if _, err := MoveResources(tempDir, "/app", "aws_instance.web", "/web", "aws_instance.web", edit); err != nil {
+        t.Fatalf("MoveResources failed: %v", err)
+    }
+    for _, path := range []string{"/app", "/web"} {
+        if versions, _ := history.List(tempDir, path); len(versions) != 0 {
+            t.Errorf("History of %s = %+v; want none with retention disabled", path, versions)
+        }
+    }
+}
+
+func TestHandleStatesMoveResourcesTenantQuota(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    tenant := &tenants.Tenant{Name: "payments", Quota: tenants.Quota{MaxStates: 1}}
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app/resources/aws_instance.web?to_state=prod/web&reason=split", nil)
+    req = req.WithContext(tenants.WithTenant(req.Context(), tenant))
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusInsufficientStorage {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusInsufficientStorage)
+    }
+    if _, err := os.Stat(FilePath(tempDir, "/prod/web")); !os.IsNotExist(err) {
+        t.Errorf("Destination state was created past the tenant's quota")
+    }
+}
+
+func TestExpiredLockDoesNotBlockEdits(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    lockFile := filepath.Join(tempDir, "locks", "prod", "app")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    os.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+    old := time.Now().Add(-time.Hour)
+    os.Chtimes(lockFile, old, old)
+
+    edit := Edit{Operator: "alice", Reason: "test"}
+    if _, err := BumpSerial(tempDir, "/prod/app", edit); err != ErrLocked {
+        t.Errorf("BumpSerial without LOCK_TTL returned %v; want ErrLocked", err)
+    }
+    t.Setenv("LOCK_TTL", "1m")
+    if _, err := BumpSerial(tempDir, "/prod/app", edit); err != nil {
+        t.Errorf("BumpSerial with an expired lock failed: %v", err)
+    }
+    if err := Rollback(tempDir, "/prod/app", "missing"); err == ErrLocked {
+        t.Errorf("Rollback was refused by an expired lock")
+    }
+}
+
+func TestWritesWaitForEdits(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    // An edit in progress holds the state's lock between reading and writing it
+    unlock := lockPaths(tempDir, "/prod/app")
+    done := make(chan int)
+    go func() {
+        req := httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": 99}`))
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        done <- rr.Code
+    }()
+    select {
+    case <-done:
+        t.Fatalf("State was written while an edit was in progress")
+    case <-time.After(50 * time.Millisecond):
+    }
+    if data, _ := os.ReadFile(FilePath(tempDir, "/prod/app")); string(data) != surgeryState {
+        t.Errorf("State changed while an edit was in progress: %s", data)
+    }
+    unlock()
+    if status := <-done; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if len(pathLocks.held) != 0 {
+        t.Errorf("Path locks still held: %v", pathLocks.held)
+    }
+}
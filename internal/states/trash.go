@@ -0,0 +1,93 @@
+package states
+
+import (
+    "errors"
+    "log/slog"
+    "net/http"
+    "os"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/index"
+    "terraform-http-backend/internal/tracing"
+    "terraform-http-backend/internal/trash"
+    "terraform-http-backend/internal/utils"
+)
+
+// ErrOccupied is returned when restoring a deleted state whose path holds a state again
+var ErrOccupied = errors.New("a state is stored at the path, move or delete it first")
+
+// deleteFile moves statefilePath into the trash of statePath, where it is kept
+// for TRASH_RETENTION, or removes it for good when that is 0
+func deleteFile(dataDir, statePath, statefilePath, operator string) error {
+    defer lockPaths(dataDir, statePath)()
+    var err error
+    if retention := config.GetEnvDuration("TRASH_RETENTION", 30*24*time.Hour); retention > 0 {
+        _, err = trash.Put(dataDir, statePath, statefilePath, time.Now(), operator, retention)
+    } else {
+        err = os.Remove(statefilePath)
+    }
+    if err != nil {
+        return err
+    }
+    index.Refresh(statefilePath)
+    return nil
+}
+
+// Restore moves a deleted state of statePath back out of the trash, the latest
+// one when id is empty. Locked states are refused, as is replacing a state
+// stored at statePath since.
+func Restore(dataDir, statePath, id string) (trash.Entry, error) {
+    defer lockPaths(dataDir, statePath)()
+    if err := checkUnlocked(dataDir, statePath, ""); err != nil {
+        return trash.Entry{}, err
+    }
+    statefilePath := FilePath(dataDir, statePath)
+    entry, err := trash.Restore(dataDir, statePath, id, statefilePath)
+    if os.IsExist(err) {
+        return entry, ErrOccupied
+    } else if err != nil {
+        return entry, err
+    }
+    index.Refresh(statefilePath)
+    return entry, nil
+}
+
+func listTrash(w http.ResponseWriter, r *http.Request, dataDir, statePath string) {
+    _, span := tracing.Start(r.Context(), "storage.trash")
+    entries, err := trash.List(dataDir, statePath)
+    tracing.End(span, err)
+    if err != nil {
+        utils.HTTPError(w, r, "Error listing deleted states", err)
+        return
+    }
+    utils.WriteJSON(w, map[string]interface{}{"trash": entries})
+}
+
+func restoreState(w http.ResponseWriter, r *http.Request, dataDir, statePath string) {
+    id := r.URL.Query().Get("id")
+    // A restored state must fit the tenant's quota like any write
+    entries, err := trash.List(dataDir, statePath)
+    if err != nil {
+        utils.HTTPError(w, r, "Error listing deleted states", err)
+        return
+    }
+    for _, entry := range entries {
+        if id == "" || entry.ID == id {
+            if !quotaAllows(w, r, dataDir, FilePath(dataDir, statePath), entry.Size) {
+                return
+            }
+            break
+        }
+    }
+    _, span := tracing.Start(r.Context(), "storage.restore", attribute.String("state.deleted", id))
+    entry, err := Restore(dataDir, statePath, id)
+    tracing.End(span, err)
+    if editFailed(w, r, err) {
+        return
+    }
+    utils.WriteJSON(w, entry)
+    slog.InfoContext(r.Context(), "Restored state", "path", statePath, "deleted", entry.ID, "operator", operator(r))
+}
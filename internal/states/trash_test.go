@@ -0,0 +1,115 @@
+package states
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "terraform-http-backend/internal/tenants"
+    "terraform-http-backend/internal/trash"
+)
+
+func TestHandleStatesRestore(t *testing.T) {
+    tempDir := t.TempDir()
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": 1}`))
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    req = httptest.NewRequest(http.MethodDelete, "/states/prod/app", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    if _, err := os.Stat(FilePath(tempDir, "/prod/app")); !os.IsNotExist(err) {
+        t.Fatalf("State was not deleted")
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/states/prod/app?trash", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    var listing struct {
+        Trash []trash.Entry `json:"trash"`
+    }
+    if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+        t.Fatalf("Failed to decode trash listing: %v", err)
+    }
+    if len(listing.Trash) != 1 || listing.Trash[0].Operator != "192.0.2.1" {
+        t.Fatalf("Trash = %+v; want the deleted state with who deleted it", listing.Trash)
+    }
+
+    lockFile := filepath.Join(tempDir, "locks", "prod", "app")
+    os.MkdirAll(filepath.Dir(lockFile), 0755)
+    os.WriteFile(lockFile, []byte(`{"ID": "abc"}`), 0644)
+    tests := []struct {
+        description    string
+        path           string
+        unlock         bool
+        expectedStatus int
+    }{
+        {"locked", "/states/prod/app:restore", false, http.StatusLocked},
+        {"missing id", "/states/prod/app:restore?id=20000101T000000.000000000Z", true, http.StatusNotFound},
+        {"nothing deleted", "/states/prod/other:restore", false, http.StatusNotFound},
+        {"restore", "/states/prod/app:restore", false, http.StatusOK},
+        {"already restored", "/states/prod/app:restore", false, http.StatusNotFound},
+    }
+    for _, test := range tests {
+        if test.unlock {
+            os.Remove(lockFile)
+        }
+        req := httptest.NewRequest(http.MethodPost, test.path, nil)
+        rr := httptest.NewRecorder()
+        HandleStates(rr, req, tempDir)
+        if status := rr.Code; status != test.expectedStatus {
+            t.Errorf("%s: Handler returned wrong status code: got %v want %v (%s)", test.description, status, test.expectedStatus, rr.Body.String())
+        }
+    }
+    if data, err := os.ReadFile(FilePath(tempDir, "/prod/app")); err != nil || string(data) != `{"serial": 1}` {
+        t.Errorf("Restored state = %q, %v; want the deleted state", data, err)
+    }
+
+    // A state stored at the path since isn't replaced
+    req = httptest.NewRequest(http.MethodDelete, "/states/prod/app", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    req = httptest.NewRequest(http.MethodPost, "/states/prod/app", bytes.NewBufferString(`{"serial": 2}`))
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    req = httptest.NewRequest(http.MethodPost, "/states/prod/app:restore", nil)
+    rr = httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusConflict {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+    }
+}
+
+func TestHandleStatesDeleteWithoutTrash(t *testing.T) {
+    tempDir := t.TempDir()
+    t.Setenv("TRASH_RETENTION", "0")
+    writeSurgeryState(t, tempDir, "/prod/app")
+    req := httptest.NewRequest(http.MethodDelete, "/states/prod/app", nil)
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusOK {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+    }
+    if entries, _ := trash.List(tempDir, "/prod/app"); len(entries) != 0 {
+        t.Errorf("State was kept in the trash with TRASH_RETENTION 0")
+    }
+}
+
+func TestHandleStatesRestoreTenantQuota(t *testing.T) {
+    tempDir := t.TempDir()
+    writeSurgeryState(t, tempDir, "/prod/app")
+    req := httptest.NewRequest(http.MethodDelete, "/states/prod/app", nil)
+    HandleStates(httptest.NewRecorder(), req, tempDir)
+    writeSurgeryState(t, tempDir, "/prod/other")
+
+    tenant := &tenants.Tenant{Name: "payments", Quota: tenants.Quota{MaxStates: 1}}
+    req = httptest.NewRequest(http.MethodPost, "/states/prod/app:restore", nil)
+    req = req.WithContext(tenants.WithTenant(req.Context(), tenant))
+    rr := httptest.NewRecorder()
+    HandleStates(rr, req, tempDir)
+    if status := rr.Code; status != http.StatusInsufficientStorage {
+        t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusInsufficientStorage)
+    }
+    if _, err := os.Stat(FilePath(tempDir, "/prod/app")); !os.IsNotExist(err) {
+        t.Errorf("State was restored past the tenant's quota")
+    }
+}
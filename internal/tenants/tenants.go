@@ -0,0 +1,234 @@
+package tenants
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/fs"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// User is a credential scoped to a single tenant
+type User struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+    // Role is "readwrite" (default) or "readonly"
+    Role string `json:"role"`
+    // Permissions grants extra rights, currently only "read-sensitive"
+    Permissions []string `json:"permissions"`
+}
+
+// PermissionReadSensitive allows reading the values of sensitive outputs
+const PermissionReadSensitive = "read-sensitive"
+
+// Has reports whether the user was granted permission
+func (u User) Has(permission string) bool {
+    for _, granted := range u.Permissions {
+        if granted == permission {
+            return true
+        }
+    }
+    return false
+}
+
+// Quota limits the storage a tenant may use, zero means unlimited
+type Quota struct {
+    MaxStates int   `json:"max_states"`
+    MaxBytes  int64 `json:"max_bytes"`
+}
+
+// Tenant is an isolated namespace with its own credentials and storage root
+type Tenant struct {
+    Name   string   `json:"name"`
+    Hosts  []string `json:"hosts"`
+    Users  []User   `json:"users"`
+    Admins []string `json:"admins"`
+    Quota  Quota    `json:"quota"`
+}
+
+// Registry holds the configured tenants
+type Registry struct {
+    byName map[string]*Tenant
+    byHost map[string]*Tenant
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Load reads the tenants file, a JSON document of the form {"tenants": [...]}
+func Load(file string) (*Registry, error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    var doc struct {
+        Tenants []*Tenant `json:"tenants"`
+    }
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("parsing %s: %w", file, err)
+    }
+    return NewRegistry(doc.Tenants)
+}
+
+// NewRegistry validates tenants and indexes them by name and host
+func NewRegistry(tenants []*Tenant) (*Registry, error) {
+    reg := &Registry{byName: make(map[string]*Tenant), byHost: make(map[string]*Tenant)}
+    for _, tenant := range tenants {
+        if !validName.MatchString(tenant.Name) {
+            return nil, fmt.Errorf("invalid tenant name %q", tenant.Name)
+        }
+        if _, ok := reg.byName[tenant.Name]; ok {
+            return nil, fmt.Errorf("duplicate tenant %q", tenant.Name)
+        }
+        for _, user := range tenant.Users {
+            if user.Role != "" && user.Role != "readwrite" && user.Role != "readonly" {
+                return nil, fmt.Errorf("tenant %q: invalid role %q for user %q", tenant.Name, user.Role, user.Username)
+            }
+            for _, permission := range user.Permissions {
+                if permission != PermissionReadSensitive {
+                    return nil, fmt.Errorf("tenant %q: invalid permission %q for user %q", tenant.Name, permission, user.Username)
+                }
+            }
+        }
+        reg.byName[tenant.Name] = tenant
+        for _, host := range tenant.Hosts {
+            host = strings.ToLower(host)
+            if other, ok := reg.byHost[host]; ok {
+                return nil, fmt.Errorf("host %q assigned to tenants %q and %q", host, other.Name, tenant.Name)
+            }
+            reg.byHost[host] = tenant
+        }
+    }
+    return reg, nil
+}
+
+// Len returns the number of tenants
+func (reg *Registry) Len() int {
+    if reg == nil {
+        return 0
+    }
+    return len(reg.byName)
+}
+
+// Lookup returns the tenant with the given name
+func (reg *Registry) Lookup(name string) (*Tenant, bool) {
+    if reg == nil {
+        return nil, false
+    }
+    tenant, ok := reg.byName[name]
+    return tenant, ok
+}
+
+// ForHost returns the tenant serving the request's host name
+func (reg *Registry) ForHost(r *http.Request) (*Tenant, bool) {
+    if reg == nil {
+        return nil, false
+    }
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    tenant, ok := reg.byHost[strings.ToLower(host)]
+    return tenant, ok
+}
+
+// User returns the tenant's user with the given username
+func (t *Tenant) User(username string) (User, bool) {
+    for _, user := range t.Users {
+        if user.Username == username {
+            return user, true
+        }
+    }
+    return User{}, false
+}
+
+// IsAdmin reports whether username administers the tenant
+func (t *Tenant) IsAdmin(username string) bool {
+    for _, admin := range t.Admins {
+        if admin == username {
+            return true
+        }
+    }
+    return false
+}
+
+// Root returns the tenant's storage root under dataDir
+func (t *Tenant) Root(dataDir string) string {
+    return filepath.Join(dataDir, "tenants", t.Name)
+}
+
+type tenantKey struct{}
+type requestedKey struct{}
+
+// WithTenant returns a copy of ctx scoped to tenant
+func WithTenant(ctx context.Context, tenant *Tenant) context.Context {
+    return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant a request is scoped to, if any
+func FromContext(ctx context.Context) (*Tenant, bool) {
+    tenant, ok := ctx.Value(tenantKey{}).(*Tenant)
+    return tenant, ok && tenant != nil
+}
+
+// DataDir returns the storage root for the request: the tenant's root when
+// the request is scoped to one, otherwise dataDir itself
+func DataDir(dataDir string, r *http.Request) string {
+    if tenant, ok := FromContext(r.Context()); ok {
+        return tenant.Root(dataDir)
+    }
+    return dataDir
+}
+
+// Requested returns the tenant named by a /tenants/<name>/ path prefix
+func Requested(r *http.Request) string {
+    name, _ := r.Context().Value(requestedKey{}).(string)
+    return name
+}
+
+// StripPrefix serves /tenants/<name>/<rest> by recording the requested tenant
+// and handing /<rest> to next
+func StripPrefix(next http.Handler) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tenants/"), "/")
+        if !validName.MatchString(name) || rest == "" || Requested(r) != "" {
+            http.NotFound(w, r)
+            return
+        }
+        r2 := r.Clone(context.WithValue(r.Context(), requestedKey{}, name))
+        r2.URL.Path = "/" + rest
+        r2.URL.RawPath = ""
+        next.ServeHTTP(w, r2)
+    }
+}
+
+// Usage returns the number of states and their total size under statesDir,
+// skipping the file at exclude. Deleted states in the trash are exempt, as
+// TRASH_RETENTION bounds them and deleting a state should free its share.
+func Usage(statesDir, exclude string) (int, int64, error) {
+    var count int
+    var size int64
+    err := filepath.WalkDir(statesDir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        if d.IsDir() || path == exclude || strings.HasPrefix(d.Name(), ".") {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return err
+        }
+        count++
+        size += info.Size()
+        return nil
+    })
+    return count, size, err
+}
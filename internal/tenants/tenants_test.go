@@ -0,0 +1,108 @@
+package tenants
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestLoad(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "tenants.json")
+    data := []byte(`{"tenants": [
+        {"name": "payments", "hosts": ["Payments.example.com"], "users": [{"username": "ci", "password": "secret"}], "admins": ["ci"]},
+        {"name": "search", "users": [{"username": "reader", "password": "secret", "role": "readonly"}], "quota": {"max_states": 10}}
+    ]}`)
+    if err := os.WriteFile(file, data, 0644); err != nil {
+        t.Fatalf("Failed to write tenants file: %v", err)
+    }
+
+    reg, err := Load(file)
+    if err != nil {
+        t.Fatalf("Load failed: %v", err)
+    }
+    if reg.Len() != 2 {
+        t.Errorf("Load returned %d tenants; want 2", reg.Len())
+    }
+    search, ok := reg.Lookup("search")
+    if !ok || search.Quota.MaxStates != 10 {
+        t.Errorf("Lookup(search) = %+v, %v", search, ok)
+    }
+
+    req := httptest.NewRequest(http.MethodGet, "/states/test", nil)
+    req.Host = "payments.example.com:9944"
+    if tenant, ok := reg.ForHost(req); !ok || tenant.Name != "payments" {
+        t.Errorf("ForHost(%s) = %v, %v; want payments", req.Host, tenant, ok)
+    }
+    if tenant, _ := reg.Lookup("payments"); !tenant.IsAdmin("ci") {
+        t.Errorf("Expected ci to administer payments")
+    }
+}
+
+func TestNewRegistryInvalid(t *testing.T) {
+    tests := []struct {
+        description string
+        tenants     []*Tenant
+    }{
+        {"path traversal name", []*Tenant{{Name: "../other"}}},
+        {"empty name", []*Tenant{{Name: ""}}},
+        {"duplicate name", []*Tenant{{Name: "a"}, {Name: "a"}}},
+        {"duplicate host", []*Tenant{{Name: "a", Hosts: []string{"x"}}, {Name: "b", Hosts: []string{"X"}}}},
+        {"invalid role", []*Tenant{{Name: "a", Users: []User{{Username: "u", Role: "owner"}}}}},
+    }
+
+    for _, test := range tests {
+        if _, err := NewRegistry(test.tenants); err == nil {
+            t.Errorf("NewRegistry accepted %s", test.description)
+        }
+    }
+}
+
+func TestStripPrefix(t *testing.T) {
+    var gotPath, gotTenant string
+    next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        gotPath, gotTenant = r.URL.Path, Requested(r)
+    })
+    handler := StripPrefix(next)
+
+    rr := httptest.NewRecorder()
+    handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tenants/payments/states/prod", nil))
+    if gotPath != "/states/prod" || gotTenant != "payments" {
+        t.Errorf("StripPrefix passed path %q tenant %q; want /states/prod payments", gotPath, gotTenant)
+    }
+
+    for _, path := range []string{"/tenants/payments", "/tenants/Bad.Name/states/prod"} {
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+        if rr.Code != http.StatusNotFound {
+            t.Errorf("StripPrefix(%s) returned status %v; want %v", path, rr.Code, http.StatusNotFound)
+        }
+    }
+}
+
+func TestUsage(t *testing.T) {
+    dir := t.TempDir()
+    files := map[string]string{
+        "a":         "12345",
+        "nested/b":  "123",
+        ".tmp-file": "1234567890",
+    }
+    for name, content := range files {
+        path := filepath.Join(dir, name)
+        os.MkdirAll(filepath.Dir(path), 0755)
+        if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatalf("Failed to write %s: %v", name, err)
+        }
+    }
+
+    count, size, err := Usage(dir, filepath.Join(dir, "a"))
+    if err != nil || count != 1 || size != 3 {
+        t.Errorf("Usage = %d, %d, %v; want 1, 3, nil", count, size, err)
+    }
+
+    count, size, err = Usage(filepath.Join(dir, "missing"), "")
+    if err != nil || count != 0 || size != 0 {
+        t.Errorf("Usage of missing dir = %d, %d, %v; want 0, 0, nil", count, size, err)
+    }
+}
@@ -0,0 +1,124 @@
+package tombstones
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+    "terraform-http-backend/internal/utils"
+)
+
+// Tombstone marks the old path of a moved state for a while, so clients still
+// using it are told where the state went instead of finding no state at all
+type Tombstone struct {
+    MovedTo string    `json:"moved_to"`
+    Moved   time.Time `json:"moved"`
+    Expires time.Time `json:"expires"`
+}
+
+func file(dataDir, statePath string) string {
+    return filepath.Join(dataDir, "tombstones", filepath.Clean("/"+statePath))
+}
+
+// Write leaves a tombstone at statePath pointing to movedTo, expiring after ttl
+func Write(dataDir, statePath, movedTo string, now time.Time, ttl time.Duration) error {
+    data, err := json.Marshal(Tombstone{MovedTo: movedTo, Moved: now.UTC(), Expires: now.Add(ttl).UTC()})
+    if err != nil {
+        return err
+    }
+    tombstoneFile := file(dataDir, statePath)
+    if err := os.MkdirAll(filepath.Dir(tombstoneFile), 0755); err != nil {
+        return err
+    }
+    return os.WriteFile(tombstoneFile, data, 0644)
+}
+
+// Remove removes the tombstone at statePath, if any
+func Remove(dataDir, statePath string) error {
+    if err := os.Remove(file(dataDir, statePath)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// Find returns the tombstone at statePath, or at one of its parents for paths
+// below a state such as <path>/outputs, nil when there is none. Expired
+// tombstones are removed.
+func Find(dataDir, statePath string, now time.Time) (*Tombstone, error) {
+    for path := filepath.Clean("/" + statePath); path != "/"; path = filepath.Dir(path) {
+        // A parent may itself be a tombstone, or states may have been stored
+        // below the old path since, making it a directory
+        if info, err := os.Stat(file(dataDir, path)); err != nil || info.IsDir() {
+            continue
+        }
+        data, err := os.ReadFile(file(dataDir, path))
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        var tombstone Tombstone
+        if err := json.Unmarshal(data, &tombstone); err != nil {
+            return nil, fmt.Errorf("tombstone of %s: %w", path, err)
+        }
+        if now.After(tombstone.Expires) {
+            if err := Remove(dataDir, path); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        return &tombstone, nil
+    }
+    return nil, nil
+}
+
+// Check writes 410 Gone explaining where the state went when the state at
+// statePath has been moved away, returning whether it did
+func Check(w http.ResponseWriter, r *http.Request, dataDir, statePath string) bool {
+    if _, err := os.Stat(filepath.Join(dataDir, "states", filepath.Clean("/"+statePath))); err == nil {
+        return false
+    }
+    tombstone, err := Find(dataDir, statePath, time.Now())
+    if err != nil {
+        utils.HTTPError(w, r, "Error checking for moved state", err)
+        return true
+    }
+    if tombstone == nil {
+        return false
+    }
+    message := fmt.Sprintf("State moved to %s at %s, update the backend address. Requests here fail until %s.",
+        strings.TrimPrefix(tombstone.MovedTo, "/"), tombstone.Moved.Format(time.RFC3339), tombstone.Expires.Format(time.RFC3339))
+    http.Error(w, message, http.StatusGone)
+    slog.WarnContext(r.Context(), "Request for moved state", "path", statePath, "moved_to", tombstone.MovedTo)
+    return true
+}
+
+// Clear removes the tombstone at statePath before it expires, so a new state
+// can be stored there, which only admins may do. It returns whether there was
+// a tombstone to clear and the request has been answered.
+func Clear(w http.ResponseWriter, r *http.Request, dataDir, statePath string) bool {
+    if _, err := os.Stat(filepath.Join(dataDir, "states", filepath.Clean("/"+statePath))); err == nil {
+        return false
+    }
+    if _, err := os.Stat(file(dataDir, statePath)); err != nil {
+        return false
+    }
+    if principal, ok := auth.PrincipalFrom(r.Context()); ok && !principal.Admin {
+        http.Error(w, "Forbidden", http.StatusForbidden)
+        slog.WarnContext(r.Context(), "Forbidden tombstone removal", "path", statePath, "principal", principal.Username)
+        return true
+    }
+    if err := Remove(dataDir, statePath); err != nil {
+        utils.HTTPError(w, r, "Error removing tombstone", err)
+        return true
+    }
+    w.WriteHeader(http.StatusOK)
+    slog.InfoContext(r.Context(), "Tombstone removed", "path", statePath)
+    return true
+}
@@ -0,0 +1,87 @@
+package tombstones
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "terraform-http-backend/internal/auth"
+)
+
+func TestFind(t *testing.T) {
+    dataDir := t.TempDir()
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    if err := Write(dataDir, "/prod/app", "/prod/apps/web", now, time.Hour); err != nil {
+        t.Fatalf("Write failed: %v", err)
+    }
+
+    for _, path := range []string{"/prod/app", "/prod/app/outputs/vpc_id"} {
+        tombstone, err := Find(dataDir, path, now.Add(time.Minute))
+        if err != nil || tombstone == nil || tombstone.MovedTo != "/prod/apps/web" {
+            t.Errorf("Find(%s) = %+v, %v; want the tombstone of /prod/app", path, tombstone, err)
+        }
+    }
+    if tombstone, _ := Find(dataDir, "/prod/other", now); tombstone != nil {
+        t.Errorf("Find(/prod/other) = %+v; want none", tombstone)
+    }
+
+    if tombstone, err := Find(dataDir, "/prod/app", now.Add(2*time.Hour)); err != nil || tombstone != nil {
+        t.Errorf("Find after expiry = %+v, %v; want none", tombstone, err)
+    }
+    if _, err := os.Stat(filepath.Join(dataDir, "tombstones", "prod", "app")); !os.IsNotExist(err) {
+        t.Errorf("Expired tombstone wasn't removed: %v", err)
+    }
+}
+
+func TestCheck(t *testing.T) {
+    dataDir := t.TempDir()
+    Write(dataDir, "/old", "/new", time.Now(), time.Hour)
+
+    req := httptest.NewRequest(http.MethodGet, "/states/old", nil)
+    rr := httptest.NewRecorder()
+    if !Check(rr, req, dataDir, "/old") {
+        t.Fatalf("Check passed a moved state")
+    }
+    if status := rr.Code; status != http.StatusGone {
+        t.Errorf("Check returned wrong status code: got %v want %v", status, http.StatusGone)
+    }
+    if !strings.Contains(rr.Body.String(), "State moved to new") {
+        t.Errorf("Check didn't explain the move: %s", rr.Body.String())
+    }
+
+    // A state stored at the old path since takes precedence
+    os.MkdirAll(filepath.Join(dataDir, "states"), 0755)
+    os.WriteFile(filepath.Join(dataDir, "states", "old"), []byte("{}"), 0644)
+    if Check(httptest.NewRecorder(), req, dataDir, "/old") {
+        t.Errorf("Check refused a state stored at the old path")
+    }
+}
+
+func TestClear(t *testing.T) {
+    dataDir := t.TempDir()
+    Write(dataDir, "/old", "/new", time.Now(), time.Hour)
+
+    if Clear(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/states/other?force=true", nil), dataDir, "/other") {
+        t.Errorf("Clear answered for a path without a tombstone")
+    }
+
+    req := httptest.NewRequest(http.MethodDelete, "/states/old?force=true", nil)
+    forbidden := req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "dev", Role: auth.RoleReadWrite}))
+    rr := httptest.NewRecorder()
+    if !Clear(rr, forbidden, dataDir, "/old") || rr.Code != http.StatusForbidden {
+        t.Errorf("Clear returned wrong status code for a non-admin: got %v want %v", rr.Code, http.StatusForbidden)
+    }
+
+    admin := req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Username: "ops", Role: auth.RoleReadWrite, Admin: true}))
+    rr = httptest.NewRecorder()
+    if !Clear(rr, admin, dataDir, "/old") || rr.Code != http.StatusOK {
+        t.Errorf("Clear returned wrong status code for an admin: got %v want %v", rr.Code, http.StatusOK)
+    }
+    if tombstone, _ := Find(dataDir, "/old", time.Now()); tombstone != nil {
+        t.Errorf("Clear left the tombstone: %+v", tombstone)
+    }
+}
@@ -0,0 +1,123 @@
+package tracing
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "os"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+
+    "terraform-http-backend/internal/config"
+    "terraform-http-backend/internal/utils"
+)
+
+const name = "terraform-http-backend"
+
+// Initialize installs the tracer provider selected by TRACING_EXPORTER:
+// "none" (the default), "stdout", "file" (TRACING_FILE) or "otlp", which is
+// configured by the standard OTEL_EXPORTER_OTLP_* variables. The returned
+// function flushes and stops the exporter.
+func Initialize(ctx context.Context, version string) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+    var exporter sdktrace.SpanExporter
+    var file *os.File
+    var err error
+    switch kind := config.GetEnv("TRACING_EXPORTER", "none"); kind {
+    case "none":
+        return func(context.Context) error { return nil }, nil
+    case "stdout":
+        exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+    case "file":
+        file, err = os.OpenFile(config.GetEnv("TRACING_FILE", "traces.json"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+        if err == nil {
+            exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+        }
+    case "otlp":
+        exporter, err = otlptracehttp.New(ctx)
+    default:
+        err = fmt.Errorf("unknown tracing exporter %q", kind)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+        semconv.ServiceName(config.GetEnv("OTEL_SERVICE_NAME", name)),
+        semconv.ServiceVersion(version),
+    ))
+    if err != nil {
+        return nil, err
+    }
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(res),
+        sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.GetEnvFloat("TRACING_SAMPLE_RATIO", 1)))),
+    )
+    otel.SetTracerProvider(provider)
+    return func(ctx context.Context) error {
+        err := provider.Shutdown(ctx)
+        if file != nil {
+            file.Close()
+        }
+        return err
+    }, nil
+}
+
+// Start starts a span named spanName as a child of the span in ctx
+func Start(ctx context.Context, spanName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+    return otel.Tracer(name).Start(ctx, spanName, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed when err is set
+func End(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// Middleware continues the trace from the request's W3C traceparent header,
+// or starts a new one, with a server span covering the whole request
+func Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+        ctx, span := otel.Tracer(name).Start(ctx, r.Method+" "+route(r.URL.Path),
+            trace.WithSpanKind(trace.SpanKindServer),
+            trace.WithAttributes(
+                semconv.HTTPRequestMethodKey.String(r.Method),
+                semconv.URLPath(r.URL.Path),
+                semconv.ClientAddress(r.RemoteAddr),
+            ),
+        )
+        defer span.End()
+        recorder := utils.NewResponseRecorder(w)
+
+        next.ServeHTTP(recorder, r.WithContext(ctx))
+
+        span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Status()))
+        if recorder.Status() >= http.StatusInternalServerError {
+            span.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+        }
+    })
+}
+
+// route names the span after the request's route rather than its full path, e.g. "/states/"
+func route(path string) string {
+    route, _ := utils.SplitPath(path)
+    if route == "tenants" {
+        return "/tenants/"
+    }
+    return "/" + route + "/"
+}
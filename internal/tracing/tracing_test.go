@@ -0,0 +1,80 @@
+package tracing
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+    t.Helper()
+    exporter := tracetest.NewInMemoryExporter()
+    previous := otel.GetTracerProvider()
+    otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    t.Cleanup(func() { otel.SetTracerProvider(previous) })
+    return exporter
+}
+
+func TestMiddleware(t *testing.T) {
+    exporter := recordSpans(t)
+    handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, span := Start(r.Context(), "storage.write")
+        End(span, errors.New("disk full"))
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+
+    req := httptest.NewRequest(http.MethodPost, "/states/prod/app", nil)
+    req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+
+    spans := exporter.GetSpans()
+    if len(spans) != 2 {
+        t.Fatalf("Got %d spans; want 2", len(spans))
+    }
+    storage, server := spans[0], spans[1]
+    if server.Name != "POST /states/" {
+        t.Errorf("Server span name = %q; want %q", server.Name, "POST /states/")
+    }
+    if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+        t.Errorf("Server span trace ID = %s; want the incoming traceparent's", got)
+    }
+    if got := server.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+        t.Errorf("Server span parent = %s; want the incoming traceparent's span", got)
+    }
+    if server.Status.Code != codes.Error {
+        t.Errorf("Server span status = %v; want error for a 500", server.Status.Code)
+    }
+    if storage.Parent.SpanID() != server.SpanContext.SpanID() {
+        t.Errorf("Storage span isn't a child of the server span")
+    }
+    if storage.Status.Code != codes.Error || len(storage.Events) == 0 {
+        t.Errorf("Storage span doesn't record its error: %+v", storage.Status)
+    }
+}
+
+func TestInitializeNone(t *testing.T) {
+    shutdown, err := Initialize(context.Background(), "test")
+    if err != nil {
+        t.Fatalf("Initialize failed: %v", err)
+    }
+    if err := shutdown(context.Background()); err != nil {
+        t.Errorf("Shutdown failed: %v", err)
+    }
+}
+
+func TestInitializeUnknownExporter(t *testing.T) {
+    t.Setenv("TRACING_EXPORTER", "zipkin")
+    if _, err := Initialize(context.Background(), "test"); err == nil {
+        t.Errorf("Initialize accepted an unknown exporter")
+    }
+}
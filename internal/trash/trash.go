@@ -0,0 +1,258 @@
+package trash
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// idFormat names deleted states by the UTC time they were deleted, so they sort chronologically
+const idFormat = "20060102T150405.000000000Z"
+
+const (
+    suffix     = ".tfstate"
+    metaSuffix = ".json"
+)
+
+var validID = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+
+// ErrNotFound is returned when the trash holds no matching deleted state
+var ErrNotFound = errors.New("no deleted state in trash")
+
+// Entry is a deleted state kept in the trash until it is restored or purged
+type Entry struct {
+    ID       string    `json:"id"`
+    Path     string    `json:"path"`
+    Size     int64     `json:"size"`
+    Deleted  time.Time `json:"deleted"`
+    Operator string    `json:"operator"`
+    Expires  time.Time `json:"expires"`
+}
+
+// Dir returns the directory holding the deleted states of statePath
+func Dir(dataDir, statePath string) string {
+    return filepath.Join(dataDir, "trash", filepath.Clean("/"+statePath))
+}
+
+// Put moves the file at statefilePath into the trash of statePath, recording
+// who deleted it and keeping it for retention
+func Put(dataDir, statePath, statefilePath string, now time.Time, operator string, retention time.Duration) (Entry, error) {
+    info, err := os.Stat(statefilePath)
+    if err != nil {
+        return Entry{}, err
+    }
+    now = now.UTC()
+    entry := Entry{
+        ID:       now.Format(idFormat),
+        Path:     strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+statePath)), "/"),
+        Size:     info.Size(),
+        Deleted:  now,
+        Operator: operator,
+        Expires:  now.Add(retention),
+    }
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return Entry{}, err
+    }
+    dir := Dir(dataDir, statePath)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return Entry{}, err
+    }
+    // Record the metadata first, so a state in the trash always has it
+    metaFile := filepath.Join(dir, entry.ID+metaSuffix)
+    if err := os.WriteFile(metaFile, data, 0644); err != nil {
+        return Entry{}, err
+    }
+    if err := os.Rename(statefilePath, filepath.Join(dir, entry.ID+suffix)); err != nil {
+        os.Remove(metaFile)
+        return Entry{}, err
+    }
+    return entry, nil
+}
+
+// List returns the deleted states of statePath in the trash, newest first
+func List(dataDir, statePath string) ([]Entry, error) {
+    dir := Dir(dataDir, statePath)
+    files, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return []Entry{}, nil
+    } else if err != nil {
+        return nil, err
+    }
+    entries := []Entry{}
+    for _, file := range files {
+        id := strings.TrimSuffix(file.Name(), metaSuffix)
+        if file.IsDir() || !validID.MatchString(id) || id == file.Name() {
+            continue
+        }
+        entry, err := readEntry(dir, id)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, entry)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+    return entries, nil
+}
+
+func readEntry(dir, id string) (Entry, error) {
+    var entry Entry
+    data, err := os.ReadFile(filepath.Join(dir, id+metaSuffix))
+    if err != nil {
+        return entry, err
+    }
+    if err := json.Unmarshal(data, &entry); err != nil {
+        return entry, fmt.Errorf("trash entry %s: %w", id, err)
+    }
+    return entry, nil
+}
+
+// Restore moves a deleted state of statePath out of the trash to statefilePath,
+// the latest one when id is empty. It fails with an error satisfying
+// os.IsExist rather than replace a state stored there since.
+func Restore(dataDir, statePath, id, statefilePath string) (Entry, error) {
+    if id == "" {
+        entries, err := List(dataDir, statePath)
+        if err != nil {
+            return Entry{}, err
+        }
+        if len(entries) == 0 {
+            return Entry{}, ErrNotFound
+        }
+        id = entries[0].ID
+    }
+    dir := Dir(dataDir, statePath)
+    if !validID.MatchString(id) {
+        return Entry{}, ErrNotFound
+    }
+    entry, err := readEntry(dir, id)
+    if os.IsNotExist(err) {
+        return Entry{}, ErrNotFound
+    } else if err != nil {
+        return Entry{}, err
+    }
+    if err := os.MkdirAll(filepath.Dir(statefilePath), 0755); err != nil {
+        return Entry{}, err
+    }
+    // Linking never replaces an existing file, unlike renaming
+    trashFile := filepath.Join(dir, id+suffix)
+    if err := link(trashFile, statefilePath); os.IsExist(err) {
+        return Entry{}, err
+    } else if err != nil {
+        // Filesystems without hard links get an exclusive create and a copy instead
+        if err := copyExclusive(trashFile, statefilePath); err != nil {
+            return Entry{}, err
+        }
+    }
+    return entry, remove(dir, id)
+}
+
+// link is os.Link, replaced in tests to act like a filesystem without hard links
+var link = os.Link
+
+// copyExclusive copies src to a new file at dst, failing with an error
+// satisfying os.IsExist when dst exists
+func copyExclusive(src, dst string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        os.Remove(dst)
+        return err
+    }
+    if err := out.Close(); err != nil {
+        os.Remove(dst)
+        return err
+    }
+    return nil
+}
+
+func remove(dir, id string) error {
+    for _, name := range []string{id + suffix, id + metaSuffix} {
+        if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return nil
+}
+
+// Purge permanently removes the deleted states under root (DATA_DIR or a
+// tenant's root) whose retention ended before now, returning how many it removed
+func Purge(root string, now time.Time) (int, error) {
+    var purged int
+    err := filepath.WalkDir(filepath.Join(root, "trash"), func(path string, d fs.DirEntry, err error) error {
+        if os.IsNotExist(err) {
+            return nil
+        } else if err != nil || d.IsDir() {
+            return err
+        }
+        id := strings.TrimSuffix(d.Name(), metaSuffix)
+        if !validID.MatchString(id) || id == d.Name() {
+            return nil
+        }
+        entry, err := readEntry(filepath.Dir(path), id)
+        if err != nil || now.Before(entry.Expires) {
+            return err
+        }
+        if err := remove(filepath.Dir(path), id); err != nil {
+            return err
+        }
+        purged++
+        return nil
+    })
+    return purged, err
+}
+
+// Watch purges expired deleted states from dataDir and every tenant's root,
+// now and then every interval until ctx is done
+func Watch(ctx context.Context, dataDir string, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        purgeRoots(dataDir, time.Now())
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func purgeRoots(dataDir string, now time.Time) {
+    roots := []string{dataDir}
+    tenants, err := os.ReadDir(filepath.Join(dataDir, "tenants"))
+    if err != nil && !os.IsNotExist(err) {
+        slog.Error("Error listing tenants to purge trash", "error", err)
+    }
+    for _, tenant := range tenants {
+        if tenant.IsDir() {
+            roots = append(roots, filepath.Join(dataDir, "tenants", tenant.Name()))
+        }
+    }
+    for _, root := range roots {
+        purged, err := Purge(root, now)
+        if err != nil {
+            slog.Error("Error purging trash", "root", root, "error", err)
+        }
+        if purged > 0 {
+            slog.Info("Purged deleted states from trash", "root", root, "states", purged)
+        }
+    }
+}
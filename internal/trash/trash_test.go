@@ -0,0 +1,118 @@
+package trash
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func writeFile(t *testing.T, path, data string) {
+    t.Helper()
+    os.MkdirAll(filepath.Dir(path), 0755)
+    if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+        t.Fatalf("Failed to write test file: %v", err)
+    }
+}
+
+func TestPutRestore(t *testing.T) {
+    tempDir := t.TempDir()
+    stateFile := filepath.Join(tempDir, "states", "prod", "app")
+    now := time.Now()
+    for i, data := range []string{`{"serial": 1}`, `{"serial": 2}`} {
+        writeFile(t, stateFile, data)
+        if _, err := Put(tempDir, "/prod/app", stateFile, now.Add(time.Duration(i)*time.Second), "alice", time.Hour); err != nil {
+            t.Fatalf("Put failed: %v", err)
+        }
+    }
+    if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+        t.Errorf("Deleted state was left in place")
+    }
+
+    entries, err := List(tempDir, "/prod/app")
+    if err != nil || len(entries) != 2 {
+        t.Fatalf("List = %+v, %v; want 2 deleted states", entries, err)
+    }
+    if entries[0].Path != "prod/app" || entries[0].Operator != "alice" || entries[0].Size != 13 || !entries[0].Expires.After(entries[0].Deleted) {
+        t.Errorf("Entry = %+v; want prod/app deleted by alice", entries[0])
+    }
+    if !entries[0].Deleted.After(entries[1].Deleted) {
+        t.Errorf("Entries are not newest first: %+v", entries)
+    }
+
+    // The latest deletion is restored by default
+    entry, err := Restore(tempDir, "/prod/app", "", stateFile)
+    if err != nil || entry.ID != entries[0].ID {
+        t.Fatalf("Restore = %+v, %v; want the latest deletion", entry, err)
+    }
+    if data, _ := os.ReadFile(stateFile); string(data) != `{"serial": 2}` {
+        t.Errorf("Restored state = %q; want the latest", data)
+    }
+    if _, err := Restore(tempDir, "/prod/app", entries[1].ID, stateFile); !os.IsExist(err) {
+        t.Errorf("Restore over a stored state returned %v; want it refused", err)
+    }
+    if _, err := Restore(tempDir, "/prod/app", "20000101T000000.000000000Z", stateFile); err != ErrNotFound {
+        t.Errorf("Restore of a missing id returned %v; want ErrNotFound", err)
+    }
+    if entries, _ := List(tempDir, "/prod/app"); len(entries) != 1 {
+        t.Errorf("Trash holds %d deleted states after restoring one; want 1", len(entries))
+    }
+}
+
+func TestRestoreWithoutHardLinks(t *testing.T) {
+    link = func(oldname, newname string) error {
+        return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+    }
+    defer func() { link = os.Link }()
+
+    tempDir := t.TempDir()
+    stateFile := filepath.Join(tempDir, "states", "prod", "app")
+    now := time.Now()
+    for i := 0; i < 2; i++ {
+        writeFile(t, stateFile, `{"serial": 1}`)
+        Put(tempDir, "/prod/app", stateFile, now.Add(time.Duration(i)*time.Second), "alice", time.Hour)
+    }
+
+    if _, err := Restore(tempDir, "/prod/app", "", stateFile); err != nil {
+        t.Fatalf("Restore failed: %v", err)
+    }
+    if data, _ := os.ReadFile(stateFile); string(data) != `{"serial": 1}` {
+        t.Errorf("Restored state = %q; want the deleted state", data)
+    }
+    if _, err := Restore(tempDir, "/prod/app", "", stateFile); !os.IsExist(err) {
+        t.Errorf("Restore over a stored state returned %v; want it refused", err)
+    }
+    if entries, _ := List(tempDir, "/prod/app"); len(entries) != 1 {
+        t.Errorf("Trash holds %d deleted states after restoring one; want 1", len(entries))
+    }
+}
+
+func TestPurge(t *testing.T) {
+    tempDir := t.TempDir()
+    now := time.Now()
+    for _, test := range []struct {
+        path      string
+        retention time.Duration
+    }{
+        {"/prod/old", time.Minute},
+        {"/prod/recent", 48 * time.Hour},
+        {"/prod/old/nested", time.Minute},
+    } {
+        stateFile := filepath.Join(tempDir, "states", filepath.FromSlash(test.path))
+        writeFile(t, stateFile, "{}")
+        if _, err := Put(tempDir, test.path, stateFile, now, "bob", test.retention); err != nil {
+            t.Fatalf("Put failed: %v", err)
+        }
+    }
+
+    purged, err := Purge(tempDir, now.Add(time.Hour))
+    if err != nil || purged != 2 {
+        t.Errorf("Purge = %d, %v; want 2 expired states purged", purged, err)
+    }
+    for path, expected := range map[string]int{"/prod/old": 0, "/prod/old/nested": 0, "/prod/recent": 1} {
+        if entries, _ := List(tempDir, path); len(entries) != expected {
+            t.Errorf("Trash of %s holds %d deleted states; want %d", path, len(entries), expected)
+        }
+    }
+}
@@ -1,33 +1,177 @@
 package utils
 
 import (
-    "log"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "log/slog"
     "net/http"
     "os"
     "path/filepath"
+    "strings"
+    "sync/atomic"
+    "time"
 )
 
+// GetFilePaths maps a request path such as /states/<path> to its file under
+// dataDir and that file's directory. ".." segments are resolved within the
+// path's first segment, so a crafted path can never leave dataDir or reach
+// another route's (or tenant's) files.
 func GetFilePaths(path, dataDir string) (string, string) {
-    filePath := filepath.Clean(path)
-    fullPath := filepath.Join(dataDir, filePath)
+    route, rest := SplitPath(path)
+    fullPath := filepath.Join(dataDir, route, rest)
     return fullPath, filepath.Dir(fullPath)
 }
 
+// SplitPath splits a request path such as /states/<path> into its route
+// ("states") and the cleaned state path after it ("/<path>")
+func SplitPath(path string) (string, string) {
+    route, rest, _ := strings.Cut(strings.TrimLeft(filepath.ToSlash(path), "/"), "/")
+    return filepath.Base(filepath.Clean("/" + route)), filepath.Clean("/" + rest)
+}
+
 func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
-    log.Printf("Method not allowed: %s", r.Method)
+    slog.WarnContext(r.Context(), "Method not allowed", "method", r.Method, "path", r.URL.Path)
     http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
 func HandleFileError(w http.ResponseWriter, r *http.Request, filePath string, err error) {
     if os.IsNotExist(err) {
-        // log.Printf("File not found: %s", filePath)
+        slog.DebugContext(r.Context(), "File not found", "path", filePath)
         http.NotFound(w, r)
     } else {
-        HTTPError(w, "Error accessing file", err)
+        HTTPError(w, r, "Error accessing file", err)
     }
 }
 
-func HTTPError(w http.ResponseWriter, message string, err error) {
-    log.Printf("%s: %v", message, err)
+var storageErrors atomic.Uint64
+
+func HTTPError(w http.ResponseWriter, r *http.Request, message string, err error) {
+    if isStorageError(err) {
+        storageErrors.Add(1)
+    }
+    slog.ErrorContext(r.Context(), message, "path", r.URL.Path, "error", err)
     http.Error(w, err.Error(), http.StatusInternalServerError)
-}
\ No newline at end of file
+}
+
+// LimitBody caps the request body at max bytes (when positive), writing a 413
+// response and returning false when its declared length is already too large
+func LimitBody(w http.ResponseWriter, r *http.Request, max int64) bool {
+    if max <= 0 {
+        return true
+    }
+    if r.ContentLength > max {
+        tooLarge(w, r, max)
+        return false
+    }
+    r.Body = http.MaxBytesReader(w, r.Body, max)
+    return true
+}
+
+// BodyTooLarge writes a 413 response and returns true when err comes from reading past a LimitBody cap
+func BodyTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+    var maxBytesErr *http.MaxBytesError
+    if !errors.As(err, &maxBytesErr) {
+        return false
+    }
+    tooLarge(w, r, maxBytesErr.Limit)
+    return true
+}
+
+func tooLarge(w http.ResponseWriter, r *http.Request, max int64) {
+    slog.WarnContext(r.Context(), "Request body too large", "path", r.URL.Path, "limit", max)
+    http.Error(w, fmt.Sprintf("Request body exceeds the limit of %d bytes", max), http.StatusRequestEntityTooLarge)
+}
+
+// BodyReadTimeout fails requests whose body stalls for longer than timeout
+// between reads. Unlike a server-wide read timeout, uploads of any size are
+// allowed as long as the client keeps sending.
+func BodyReadTimeout(next http.Handler, timeout time.Duration) http.Handler {
+    if timeout <= 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Body != nil && r.Body != http.NoBody {
+            r.Body = &deadlineReader{ReadCloser: r.Body, controller: http.NewResponseController(w), timeout: timeout}
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// deadlineReader pushes the connection's read deadline forward before every read
+type deadlineReader struct {
+    io.ReadCloser
+    controller *http.ResponseController
+    timeout    time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+    d.controller.SetReadDeadline(time.Now().Add(d.timeout))
+    return d.ReadCloser.Read(p)
+}
+
+// StorageErrors returns the number of requests HTTPError failed with a file
+// I/O error since startup
+func StorageErrors() uint64 {
+    return storageErrors.Load()
+}
+
+// isStorageError reports whether err comes from reading or writing files
+func isStorageError(err error) bool {
+    var pathErr *fs.PathError
+    var linkErr *os.LinkError
+    var syscallErr *os.SyscallError
+    return errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr)
+}
+
+// WriteJSON writes v as a JSON response with status 200
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        slog.Error("Error encoding response", "error", err)
+    }
+}
+
+// ResponseRecorder remembers the status code and body size written by a handler
+type ResponseRecorder struct {
+    http.ResponseWriter
+    status int
+    Bytes  int64
+}
+
+// NewResponseRecorder wraps w to record what is written to it
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+    return &ResponseRecorder{ResponseWriter: w}
+}
+
+// Status returns the status code written, 200 when the handler only wrote a body or nothing
+func (r *ResponseRecorder) Status() int {
+    if r.status == 0 {
+        return http.StatusOK
+    }
+    return r.status
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(data)
+    r.Bytes += int64(n)
+    return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
@@ -2,11 +2,16 @@ package utils
 
 import (
     "errors"
+    "fmt"
+    "io"
+    "net"
     "net/http"
     "net/http/httptest"
     "os"
     "path/filepath"
+    "strings"
     "testing"
+    "time"
 )
 
 func TestGetFilePaths(t *testing.T) {
@@ -26,6 +31,21 @@ func TestGetFilePaths(t *testing.T) {
             expectedFull: filepath.Join("/testdata", "nested/dir/statefile.tfstate"),
             expectedDir:  filepath.Dir(filepath.Join("/testdata", "nested/dir/statefile.tfstate")),
         },
+        {
+            inputPath:    "/states/../../etc/passwd",
+            expectedFull: filepath.Join("/testdata", "states/etc/passwd"),
+            expectedDir:  filepath.Join("/testdata", "states/etc"),
+        },
+        {
+            inputPath:    "/states/../tenants/other/states/prod",
+            expectedFull: filepath.Join("/testdata", "states/tenants/other/states/prod"),
+            expectedDir:  filepath.Join("/testdata", "states/tenants/other/states"),
+        },
+        {
+            inputPath:    "../../etc/passwd",
+            expectedFull: filepath.Join("/testdata", "etc/passwd"),
+            expectedDir:  filepath.Join("/testdata", "etc"),
+        },
     }
 
     for _, test := range tests {
@@ -96,7 +116,7 @@ func TestHTTPError(t *testing.T) {
     rr := httptest.NewRecorder()
     err := errors.New("internal server error")
 
-    HTTPError(rr, "Test error message", err)
+    HTTPError(rr, httptest.NewRequest(http.MethodGet, "/states/test", nil), "Test error message", err)
 
     if status := rr.Code; status != http.StatusInternalServerError {
         t.Errorf("HTTPError returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
@@ -106,4 +126,82 @@ func TestHTTPError(t *testing.T) {
     if rr.Body.String() != expectedBody {
         t.Errorf("HTTPError returned wrong body: got %q want %q", rr.Body.String(), expectedBody)
     }
-}
\ No newline at end of file
+}
+
+func TestHTTPErrorCountsStorageErrors(t *testing.T) {
+    before := StorageErrors()
+    req := httptest.NewRequest(http.MethodGet, "/states/test", nil)
+    HTTPError(httptest.NewRecorder(), req, "Error decoding state", errors.New("invalid character"))
+    if got := StorageErrors(); got != before {
+        t.Errorf("StorageErrors = %d after a decoding error; want %d", got, before)
+    }
+    _, err := os.ReadFile(filepath.Join(t.TempDir(), "missing", "state"))
+    HTTPError(httptest.NewRecorder(), req, "Error reading state", fmt.Errorf("reading state: %w", err))
+    if got := StorageErrors(); got != before+1 {
+        t.Errorf("StorageErrors = %d after a file error; want %d", got, before+1)
+    }
+}
+
+func TestLimitBody(t *testing.T) {
+    req := httptest.NewRequest(http.MethodPost, "/states/test", strings.NewReader("0123456789"))
+    rr := httptest.NewRecorder()
+    if LimitBody(rr, req, 5) {
+        t.Errorf("LimitBody accepted a body declared longer than the limit")
+    }
+    if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+        t.Errorf("LimitBody returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+    }
+
+    // a chunked body has no declared length, so the limit applies while reading
+    req = httptest.NewRequest(http.MethodPost, "/states/test", strings.NewReader("0123456789"))
+    req.ContentLength = -1
+    rr = httptest.NewRecorder()
+    if !LimitBody(rr, req, 5) {
+        t.Fatalf("LimitBody refused a body of unknown length")
+    }
+    _, err := io.ReadAll(req.Body)
+    if !BodyTooLarge(rr, req, err) {
+        t.Fatalf("BodyTooLarge didn't recognise %v", err)
+    }
+    if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+        t.Errorf("BodyTooLarge returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+    }
+    if BodyTooLarge(httptest.NewRecorder(), req, errors.New("other")) {
+        t.Errorf("BodyTooLarge matched an unrelated error")
+    }
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+    received := make(chan error, 1)
+    server := httptest.NewServer(BodyReadTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, err := io.ReadAll(r.Body)
+        received <- err
+    }), 100*time.Millisecond))
+    defer server.Close()
+
+    conn, err := net.Dial("tcp", server.Listener.Addr().String())
+    if err != nil {
+        t.Fatalf("Failed to connect: %v", err)
+    }
+    defer conn.Close()
+    // send a body slowly but steadily for longer than the timeout, then stall
+    fmt.Fprintf(conn, "POST /states/test HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\n")
+    for i := 0; i < 5; i++ {
+        conn.Write([]byte("0123456789"))
+        time.Sleep(50 * time.Millisecond)
+    }
+    select {
+    case err := <-received:
+        t.Fatalf("Body timed out while the client was still sending: %v", err)
+    default:
+    }
+
+    select {
+    case err := <-received:
+        if err == nil {
+            t.Errorf("Stalled body was read without error")
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatalf("Stalled body was never timed out")
+    }
+}